@@ -0,0 +1,75 @@
+package analysis
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{}
+}
+
+func (c *FakeClient) Analyze(ctx context.Context, req Request) (*Result, error) {
+	c.mu.Lock()
+	c.Requests = append(c.Requests, req)
+	delay := c.Delay
+	var err error
+	if len(c.Errors) > 0 {
+		err = c.Errors[0]
+		c.Errors = c.Errors[1:]
+	} else {
+		err = c.Err
+	}
+	result := c.Result
+	c.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := copyFile(req.SkeletonPath, req.VideoPath); err != nil {
+		return nil, err
+	}
+	if result != nil {
+		copied := *result
+		return &copied, nil
+	}
+	return &Result{
+		Score:       "80",
+		Suggestions: []string{"這是測試用的分析結果"},
+		Details:     SampleDetails(),
+	}, nil
+}
+
+func copyFile(dst string, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Calls returns the requests received so far.
+func (c *FakeClient) Calls() []Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Request{}, c.Requests...)
+}
@@ -0,0 +1,123 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/HeavenAQ/metrics"
+	"github.com/HeavenAQ/resilience"
+)
+
+// maxErrorBody is how much of an error answer is kept for StatusError
+const maxErrorBody = 4096
+
+// maxBrokenAnswers caps the attempts ending in an answer that broke off after
+// the 200, apart from GENAI_MAX_ATTEMPTS. Each retry uploads the video again
+// and reruns the whole analysis.
+const maxBrokenAnswers = 2
+
+func NewHTTPClient(baseURL string, user string, password string, policy resilience.Policy, breaker *resilience.Breaker, logger *log.Logger) *HTTPClient {
+	client := &http.Client{Timeout: 1 * time.Minute}
+	return &HTTPClient{client, baseURL, user, password, policy, breaker, logger}
+}
+
+// post streams the video up and leaves the answer unread; the caller must
+// close its Body. net/http is used directly since resty copies request
+// bodies into memory.
+func (c *HTTPClient) post(ctx context.Context, req Request) (*http.Response, error) {
+	body, contentType, err := multipartBody(req.VideoPath, req.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	query := url.Values{}
+	query.Set("handedness", req.Handedness)
+	query.Set("skill", req.Skill)
+	query.Set("model", req.Model)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/analyze?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.SetBasicAuth(c.user, c.password)
+	httpReq.Header.Set("Content-Type", contentType)
+	return c.client.Do(httpReq)
+}
+
+// Ping checks that the AI server answers, without retries. The server has no
+// health endpoint, so any answer to a GET of /analyze but a 5xx counts.
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/analyze", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return &StatusError{resp.StatusCode, string(body)}
+	}
+	return nil
+}
+
+// Analyze retries network errors, timeouts, 429 and 5xx answers with backoff,
+// honoring Retry-After. Other answers are returned right away, and answers
+// broken off while they were read are retried only up to maxBrokenAnswers.
+func (c *HTTPClient) Analyze(ctx context.Context, req Request) (*Result, error) {
+	c.logger.Println("\n\tSending video to AI server: " + c.baseURL)
+
+	var result *Result
+	brokenAnswers := 0
+	err := resilience.Call(ctx, c.breaker, c.policy, func(ctx context.Context) error {
+		resp, err := c.post(ctx, req)
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) || ctx.Err() != nil {
+			return err
+		}
+		if err != nil {
+			metrics.AnalysisResponses.WithLabelValues("error").Inc()
+			return resilience.Retryable(err, 0)
+		}
+		defer resp.Body.Close()
+		metrics.AnalysisResponses.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+		if resp.StatusCode != 200 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+			statusErr := &StatusError{resp.StatusCode, string(body)}
+			if resilience.IsTransientStatus(resp.StatusCode) {
+				retryAfter := resilience.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+				return resilience.Retryable(statusErr, retryAfter)
+			}
+			return statusErr
+		}
+
+		result, err = decodeResult(resp.Body, req.SkeletonPath)
+		var retryable *resilience.RetryableError
+		if errors.As(err, &retryable) {
+			brokenAnswers++
+			if brokenAnswers < maxBrokenAnswers {
+				return err
+			}
+			err = retryable.Err
+		}
+		if err != nil {
+			c.logger.Println("\n\tError decoding AI server response:", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,227 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HeavenAQ/resilience"
+)
+
+var discard = log.New(io.Discard, "", 0)
+
+// mockServer starts the stand-in AI server and counts the requests it gets.
+func mockServer(t *testing.T, config MockServerConfig) (*httptest.Server, *int32) {
+	t.Helper()
+	mock := NewMockServer(config, discard)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		mock.ServeHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// testPolicy retries right away, recording the delays asked for.
+func testPolicy(attempts int, delays *[]time.Duration) resilience.Policy {
+	return resilience.Policy{
+		MaxAttempts:   attempts,
+		Backoff:       resilience.Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		MaxRetryAfter: 20 * time.Millisecond,
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			*delays = append(*delays, delay)
+		},
+	}
+}
+
+func testRequest(t *testing.T) Request {
+	t.Helper()
+	dir := t.TempDir()
+	req := Request{
+		VideoPath:    filepath.Join(dir, "resized.mp4"),
+		SkeletonPath: filepath.Join(dir, "skeleton.mp4"),
+		Filename:     "U1234_serve.mp4",
+		Handedness:   "right",
+		Skill:        "serve",
+		Model:        "serve",
+	}
+	if err := os.WriteFile(req.VideoPath, testVideo(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestAnalyzeRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   MockServerConfig
+		attempts int
+		requests int32
+		// status of the error returned, 0 for success
+		status    int
+		retryable bool
+	}{
+		{
+			name:     "recovers after server errors",
+			config:   MockServerConfig{Statuses: []int{502, 500}},
+			attempts: 3,
+			requests: 3,
+		},
+		{
+			name:      "gives up after the last attempt",
+			config:    MockServerConfig{Statuses: []int{500, 503, 502, 500}},
+			attempts:  3,
+			requests:  3,
+			status:    502,
+			retryable: true,
+		},
+		{
+			name:     "bad request is not retried",
+			config:   MockServerConfig{Statuses: []int{400}},
+			attempts: 3,
+			requests: 1,
+			status:   400,
+		},
+		{
+			name:     "unauthorized is not retried",
+			config:   MockServerConfig{User: "genai", Password: "other"},
+			attempts: 3,
+			requests: 1,
+			status:   401,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := mockServer(t, tt.config)
+			var delays []time.Duration
+			breaker := resilience.NewBreaker("genai", 10, time.Minute)
+			client := NewHTTPClient(server.URL, "genai", "secret", testPolicy(tt.attempts, &delays), breaker, discard)
+			req := testRequest(t)
+
+			result, err := client.Analyze(context.Background(), req)
+			if got := atomic.LoadInt32(requests); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+			if len(delays) != int(tt.requests)-1 {
+				t.Errorf("%d retries, want %d", len(delays), tt.requests-1)
+			}
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Score != "80" {
+					t.Errorf("score %q, want 80", result.Score)
+				}
+				skeleton, _ := os.ReadFile(req.SkeletonPath)
+				if !bytes.Equal(skeleton, testVideo()) {
+					t.Errorf("skeleton video of %d bytes is not the echoed upload", len(skeleton))
+				}
+				return
+			}
+
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+				t.Fatalf("err = %v, want status %d", err, tt.status)
+			}
+			if resilience.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", !tt.retryable, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestAnalyzeHonorsRetryAfter(t *testing.T) {
+	server, _ := mockServer(t, MockServerConfig{
+		Statuses:   []int{http.StatusServiceUnavailable},
+		RetryAfter: time.Hour,
+	})
+	var delays []time.Duration
+	client := NewHTTPClient(server.URL, "", "", testPolicy(2, &delays), resilience.NewBreaker("genai", 10, time.Minute), discard)
+
+	if _, err := client.Analyze(context.Background(), testRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+	// the hour asked for is capped by MaxRetryAfter
+	if len(delays) != 1 || delays[0] != 20*time.Millisecond {
+		t.Errorf("delays %v, want [20ms]", delays)
+	}
+}
+
+func TestAnalyzeBreaker(t *testing.T) {
+	server, requests := mockServer(t, MockServerConfig{Statuses: []int{500, 500, 500}})
+	var delays []time.Duration
+	breaker := resilience.NewBreaker("genai", 2, 50*time.Millisecond)
+	client := NewHTTPClient(server.URL, "", "", testPolicy(5, &delays), breaker, discard)
+
+	// the breaker opens after two failures and ends the retries
+	_, err := client.Analyze(context.Background(), testRequest(t))
+	if !errors.Is(err, resilience.ErrCircuitOpen) {
+		t.Fatalf("err = %v, want %v", err, resilience.ErrCircuitOpen)
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("%d requests, want 2", got)
+	}
+	if state, lastErr := breaker.State(); state != resilience.Open || lastErr == nil {
+		t.Errorf("breaker %s with %v, want open with the last failure", state, lastErr)
+	}
+
+	// calls fail fast while it is open
+	_, err = client.Analyze(context.Background(), testRequest(t))
+	if !errors.Is(err, resilience.ErrCircuitOpen) || !resilience.IsUnavailable(err) {
+		t.Fatalf("err = %v, want %v", err, resilience.ErrCircuitOpen)
+	}
+	if got := atomic.LoadInt32(requests); got != 2 {
+		t.Errorf("%d requests while open, want 2", got)
+	}
+
+	// after the cooldown a failed probe opens it again, a successful one
+	// closes it
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.Analyze(context.Background(), testRequest(t))
+	if !errors.Is(err, resilience.ErrCircuitOpen) {
+		t.Fatalf("err = %v, want %v", err, resilience.ErrCircuitOpen)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("%d requests after the first probe, want 3", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.Analyze(context.Background(), testRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := breaker.State(); state != resilience.Closed {
+		t.Errorf("breaker %s after a successful probe, want closed", state)
+	}
+}
+
+func TestAnalyzeCapsBrokenAnswers(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		io.Copy(io.Discard, req.Body)
+		io.WriteString(w, `{"skeleton_video":"Pz8/`)
+	}))
+	t.Cleanup(server.Close)
+	var delays []time.Duration
+	breaker := resilience.NewBreaker("genai", 10, time.Minute)
+	client := NewHTTPClient(server.URL, "", "", testPolicy(6, &delays), breaker, discard)
+
+	_, err := client.Analyze(context.Background(), testRequest(t))
+	if !errors.Is(err, ErrTruncatedAnswer) || resilience.IsRetryable(err) {
+		t.Fatalf("err = %v, want %v that is not retried again", err, ErrTruncatedAnswer)
+	}
+	if got := atomic.LoadInt32(&requests); got != maxBrokenAnswers {
+		t.Errorf("%d requests, want %d", got, maxBrokenAnswers)
+	}
+}
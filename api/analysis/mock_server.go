@@ -0,0 +1,125 @@
+package analysis
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NewMockServer returns a stand-in for the AI server that mimics
+// POST /analyze so the upload flow can run without the pose-estimation
+// service.
+func NewMockServer(config MockServerConfig, logger *log.Logger) *MockServer {
+	if config.Score == "" {
+		config.Score = "80"
+	}
+	if len(config.ErrorCodes) == 0 {
+		config.ErrorCodes = []int{http.StatusInternalServerError, http.StatusBadGateway}
+	}
+	return &MockServer{config: config, logger: logger}
+}
+
+// nextStatus picks the status of the next answer.
+func (s *MockServer) nextStatus() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.requests
+	s.requests++
+	if n < len(s.config.Statuses) {
+		return s.config.Statuses[n]
+	}
+	if s.config.ErrorRate > 0 && rand.Float64() < s.config.ErrorRate {
+		return s.config.ErrorCodes[rand.Intn(len(s.config.ErrorCodes))]
+	}
+	return http.StatusOK
+}
+
+func (s *MockServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/analyze" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.config.User != "" {
+		user, password, ok := req.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(s.config.User)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.config.Password)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	query := req.URL.Query()
+	handedness := query.Get("handedness")
+	if handedness != "left" && handedness != "right" {
+		http.Error(w, "invalid handedness", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := req.FormFile("file")
+	if err != nil {
+		http.Error(w, "missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	status := s.nextStatus()
+	s.logger.Println(
+		"\n\tAnalyze request:", header.Filename,
+		"\n\t\t- Skill:", query.Get("skill"),
+		"\n\t\t- Model:", query.Get("model"),
+		"\n\t\t- Handedness:", handedness,
+		"\n\t\t- Answering:", status,
+	)
+	time.Sleep(s.config.Latency)
+
+	if status != http.StatusOK {
+		if s.config.RetryAfter > 0 && (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.config.RetryAfter.Seconds())))
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	var skeleton io.Reader = file
+	if len(s.config.SkeletonVideo) > 0 {
+		skeleton = bytes.NewReader(s.config.SkeletonVideo)
+	}
+	suggestions := s.config.Suggestions
+	if suggestions == nil {
+		suggestions = []string{}
+	}
+	rest, err := json.Marshal(Result{
+		Score:       s.config.Score,
+		Suggestions: suggestions,
+		Details:     s.config.Details,
+	})
+	if err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
+		return
+	}
+
+	// the video is encoded while it is sent, like a large answer of the real
+	// server would arrive
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"`+skeletonKey+`":"`)
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.Copy(encoder, skeleton); err != nil {
+		s.logger.Println("\n\tError sending skeleton video:", err)
+		return
+	}
+	encoder.Close()
+	io.WriteString(w, `",`)
+	w.Write(rest[1:])
+}
@@ -0,0 +1,93 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/HeavenAQ/api/db"
+)
+
+func toSeverity(severity string) db.FaultSeverity {
+	switch db.FaultSeverity(strings.ToLower(strings.TrimSpace(severity))) {
+	case db.Minor:
+		return db.Minor
+	case db.Major:
+		return db.Major
+	default:
+		return db.Moderate
+	}
+}
+
+// WorkAnalysis converts the structured results for storage on a work. Faults
+// are ordered from the most severe, then by time. It returns nil when the
+// server sent no structured results.
+func (d Details) WorkAnalysis() *db.WorkAnalysis {
+	if len(d.Phases) == 0 && len(d.JointAngles) == 0 && len(d.Faults) == 0 && len(d.Keyframes) == 0 {
+		return nil
+	}
+
+	analysis := &db.WorkAnalysis{}
+	for _, phase := range d.Phases {
+		analysis.Phases = append(analysis.Phases, db.PhaseScore{Phase: phase.Phase, Score: phase.Score})
+	}
+	for _, angle := range d.JointAngles {
+		analysis.JointAngles = append(analysis.JointAngles, db.JointAngle{
+			Joint: angle.Joint,
+			Phase: angle.Phase,
+			Angle: angle.Angle,
+			Min:   angle.ExpectedMin,
+			Max:   angle.ExpectedMax,
+		})
+	}
+	for _, fault := range d.Faults {
+		if fault.Code == "" {
+			continue
+		}
+		analysis.Faults = append(analysis.Faults, db.Fault{
+			Code:        fault.Code,
+			Severity:    toSeverity(fault.Severity),
+			Phase:       fault.Phase,
+			Description: fault.Description,
+			Timestamp:   fault.Timestamp,
+		})
+	}
+	sort.SliceStable(analysis.Faults, func(i, j int) bool {
+		a, b := analysis.Faults[i], analysis.Faults[j]
+		if a.Severity.Rank() != b.Severity.Rank() {
+			return a.Severity.Rank() > b.Severity.Rank()
+		}
+		return a.Timestamp < b.Timestamp
+	})
+	for _, keyframe := range d.Keyframes {
+		analysis.Keyframes = append(analysis.Keyframes, db.Keyframe{Phase: keyframe.Phase, Timestamp: keyframe.Timestamp})
+	}
+	sort.SliceStable(analysis.Keyframes, func(i, j int) bool {
+		return analysis.Keyframes[i].Timestamp < analysis.Keyframes[j].Timestamp
+	})
+	return analysis
+}
+
+// SampleDetails are canned structured results used by the fake client and
+// the mock server.
+func SampleDetails() Details {
+	return Details{
+		Phases: []PhaseScore{
+			{Phase: "準備", Score: 85},
+			{Phase: "擊球", Score: 72},
+			{Phase: "收拍", Score: 90},
+		},
+		JointAngles: []JointAngle{
+			{Joint: "右手肘", Phase: "擊球", Angle: 142, ExpectedMin: 160, ExpectedMax: 180},
+			{Joint: "右膝", Phase: "準備", Angle: 135, ExpectedMin: 120, ExpectedMax: 150},
+		},
+		Faults: []Fault{
+			{Code: "elbow_bent_at_contact", Severity: "moderate", Phase: "擊球", Description: "擊球時手肘未完全伸直", Timestamp: 1.2},
+			{Code: "late_racket_preparation", Severity: "minor", Phase: "準備", Description: "引拍時機稍晚", Timestamp: 0.4},
+		},
+		Keyframes: []Keyframe{
+			{Phase: "準備", Timestamp: 0.4},
+			{Phase: "擊球", Timestamp: 1.2},
+			{Phase: "收拍", Timestamp: 1.8},
+		},
+	}
+}
@@ -0,0 +1,244 @@
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"strconv"
+
+	"github.com/HeavenAQ/resilience"
+)
+
+// skeletonKey is the field of the response holding the base64 video
+const skeletonKey = "skeleton_video"
+
+// base64ChunkSize is how many encoded bytes are decoded at a time; it must be
+// a multiple of 4
+const base64ChunkSize = 64 * 1024
+
+var ErrNoSkeletonVideo = errors.New("AI server response has no skeleton video")
+
+// ErrTruncatedAnswer is returned when the response ends before the JSON does.
+var ErrTruncatedAnswer = fmt.Errorf("AI server response was cut short: %w", io.ErrUnexpectedEOF)
+
+// errInvalidEscape is returned for escapes in the skeleton video that cannot
+// be part of base64
+var errInvalidEscape = errors.New("invalid escape in skeleton video")
+
+// multipartBody streams the video at path as the "file" field of a form, so
+// the upload is never held in memory.
+func multipartBody(path string, filename string) (io.ReadCloser, string, error) {
+	video, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		defer video.Close()
+		part, err := form.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(part, video)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, form.FormDataContentType(), nil
+}
+
+// base64Writer decodes base64 text into w a chunk at a time. Whitespace is
+// skipped since some encoders wrap lines.
+type base64Writer struct {
+	w   io.Writer
+	buf []byte
+	out []byte
+}
+
+func newBase64Writer(w io.Writer) *base64Writer {
+	return &base64Writer{
+		w:   w,
+		buf: make([]byte, 0, base64ChunkSize),
+		out: make([]byte, base64.StdEncoding.DecodedLen(base64ChunkSize)),
+	}
+}
+
+func (b *base64Writer) WriteByte(c byte) error {
+	if c == ' ' || c == '\n' || c == '\r' || c == '\t' {
+		return nil
+	}
+	b.buf = append(b.buf, c)
+	if len(b.buf) == cap(b.buf) {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *base64Writer) flush() error {
+	n, err := base64.StdEncoding.Decode(b.out, b.buf)
+	if err != nil {
+		return err
+	}
+	b.buf = b.buf[:0]
+	_, err = b.w.Write(b.out[:n])
+	return err
+}
+
+// decodeResult reads a response of POST /analyze. The skeleton video, which
+// makes up nearly all of the response, is decoded straight into
+// skeletonPath and only the remaining fields are kept in memory.
+func decodeResult(body io.Reader, skeletonPath string) (*Result, error) {
+	video, err := os.Create(skeletonPath)
+	if err != nil {
+		return nil, err
+	}
+	defer video.Close()
+	buffered := bufio.NewWriter(video)
+	skeleton := newBase64Writer(buffered)
+
+	in := bufio.NewReader(body)
+	rest := &bytes.Buffer{}
+	var (
+		depth     int
+		inString  bool
+		escaped   bool
+		expectKey bool
+		isKey     bool
+		key       []byte
+		uEscape   []byte
+		lastKey   string
+		found     bool
+	)
+	for {
+		c, err := in.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// a broken stream is retried like any other network error
+			return nil, resilience.Retryable(err, 0)
+		}
+
+		// the skeleton video is left out of rest as an empty string
+		if inString && !isKey && lastKey == skeletonKey && depth == 1 {
+			switch {
+			case len(uEscape) > 0:
+				// the 4 hex digits of a \u escape
+				uEscape = append(uEscape, c)
+				if len(uEscape) == 5 {
+					c, err = decodeUnicodeEscape(uEscape[1:])
+					uEscape = uEscape[:0]
+					if err == nil {
+						err = skeleton.WriteByte(c)
+					}
+				}
+			case escaped:
+				escaped = false
+				switch c {
+				case '/':
+					err = skeleton.WriteByte(c)
+				case 'u':
+					uEscape = append(uEscape, c)
+				case 'n', 'r', 't':
+					// escaped line breaks of wrapped base64
+				default:
+					err = fmt.Errorf("%w: \\%c", errInvalidEscape, c)
+				}
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				lastKey = ""
+				rest.WriteByte(c)
+			default:
+				err = skeleton.WriteByte(c)
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		rest.WriteByte(c)
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				if isKey {
+					lastKey = string(key)
+					isKey = false
+					continue
+				}
+			}
+			if isKey {
+				key = append(key, c)
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+			isKey = depth == 1 && expectKey
+			expectKey = false
+			key = key[:0]
+			if !isKey && depth == 1 && lastKey == skeletonKey {
+				found = true
+			}
+		case '{':
+			depth++
+			expectKey = depth == 1
+		case '[':
+			depth++
+		case '}', ']':
+			depth--
+		case ',':
+			expectKey = depth == 1
+		}
+	}
+
+	if inString || depth != 0 {
+		return nil, resilience.Retryable(ErrTruncatedAnswer, 0)
+	}
+	if !found {
+		return nil, ErrNoSkeletonVideo
+	}
+	if err := skeleton.flush(); err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	if err := json.Unmarshal(rest.Bytes(), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// decodeUnicodeEscape returns the character of the hex digits of a \u
+// escape, which must be one of the base64 alphabet.
+func decodeUnicodeEscape(hex []byte) (byte, error) {
+	code, err := strconv.ParseUint(string(hex), 16, 16)
+	if err != nil || code > 0x7f || !isBase64(byte(code)) {
+		return 0, fmt.Errorf("%w: \\u%s", errInvalidEscape, hex)
+	}
+	return byte(code), nil
+}
+
+func isBase64(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '/' || c == '='
+}
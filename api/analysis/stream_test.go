@@ -0,0 +1,253 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/HeavenAQ/resilience"
+)
+
+// chunkReader returns at most size bytes per Read, like a body arriving in
+// uneven pieces.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (c chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.size {
+		p = p[:c.size]
+	}
+	return c.r.Read(p)
+}
+
+// testVideo is larger than base64ChunkSize so it is decoded in several
+// chunks.
+func testVideo() []byte {
+	video := make([]byte, base64ChunkSize)
+	for i := range video {
+		video[i] = byte(i * 7)
+	}
+	return video
+}
+
+// wrapLines splits s into lines of n characters, as MIME encoders do.
+func wrapLines(s string, n int) string {
+	var b strings.Builder
+	for len(s) > n {
+		b.WriteString(s[:n])
+		b.WriteString("\r\n")
+		s = s[n:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func TestDecodeResult(t *testing.T) {
+	video := testVideo()
+	encoded := base64.StdEncoding.EncodeToString(video)
+	// "???" encodes to "Pz8/", whose slash JSON encoders may escape
+	slashes := []byte("???>>>???")
+	slashesEncoded := base64.StdEncoding.EncodeToString(slashes)
+
+	tests := []struct {
+		name     string
+		body     string
+		chunk    int
+		want     *Result
+		skeleton []byte
+	}{
+		{
+			name:     "plain",
+			body:     `{"skeleton_video":"` + encoded + `","score":"85","suggestions":["bend your knees"]}`,
+			want:     &Result{Score: "85", Suggestions: []string{"bend your knees"}},
+			skeleton: video,
+		},
+		{
+			name:     "escaped slashes",
+			body:     `{"score":"70","skeleton_video":"` + strings.ReplaceAll(slashesEncoded, "/", `\/`) + `"}`,
+			want:     &Result{Score: "70"},
+			skeleton: slashes,
+		},
+		{
+			name:     "unicode escapes",
+			body:     `{"skeleton_video":"` + strings.NewReplacer("/", `\u002f`, "+", `\u002B`).Replace(encoded) + `","score":"70"}`,
+			want:     &Result{Score: "70"},
+			skeleton: video,
+		},
+		{
+			name:     "line wrapped base64",
+			body:     `{"skeleton_video":"` + strings.ReplaceAll(wrapLines(encoded, 76), "\r\n", `\r\n`) + "\",\n\t\"score\": \"60\"}",
+			want:     &Result{Score: "60"},
+			skeleton: video,
+		},
+		{
+			name:     "raw whitespace in base64",
+			body:     `{"skeleton_video":"` + wrapLines(encoded, 64) + `","score":"60"}`,
+			want:     &Result{Score: "60"},
+			skeleton: video,
+		},
+		{
+			name:     "chunks not a multiple of 4",
+			body:     `{"skeleton_video":"` + encoded + `","score":"90"}`,
+			chunk:    7,
+			want:     &Result{Score: "90"},
+			skeleton: video,
+		},
+		{
+			name:     "nested key is not the video",
+			body:     `{"meta":{"skeleton_video":"bm90IGl0"},"skeleton_video":"` + encoded + `","score":"80","suggestions":["say \"ready\""]}`,
+			want:     &Result{Score: "80", Suggestions: []string{`say "ready"`}},
+			skeleton: video,
+		},
+		{
+			name: "details",
+			body: `{"skeleton_video":"` + slashesEncoded + `","score":"75","phases":[{"phase":"swing","score":70}],"faults":[{"code":"late_contact","severity":"major"}]}`,
+			want: &Result{Score: "75", Details: Details{
+				Phases: []PhaseScore{{Phase: "swing", Score: 70}},
+				Faults: []Fault{{Code: "late_contact", Severity: "major"}},
+			}},
+			skeleton: slashes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.chunk > 0 {
+				body = chunkReader{body, tt.chunk}
+			}
+			path := filepath.Join(t.TempDir(), "skeleton.mp4")
+
+			result, err := decodeResult(body, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("result %+v, want %+v", result, tt.want)
+			}
+			skeleton, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(skeleton, tt.skeleton) {
+				t.Errorf("skeleton video of %d bytes, want %d", len(skeleton), len(tt.skeleton))
+			}
+		})
+	}
+}
+
+func TestDecodeResultErrors(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testVideo())
+
+	tests := []struct {
+		name      string
+		body      io.Reader
+		want      error
+		retryable bool
+	}{
+		{
+			name: "missing skeleton video",
+			body: strings.NewReader(`{"score":"85","suggestions":[]}`),
+			want: ErrNoSkeletonVideo,
+		},
+		{
+			name: "only a nested skeleton video",
+			body: strings.NewReader(`{"meta":{"skeleton_video":"bm90IGl0"},"score":"85"}`),
+			want: ErrNoSkeletonVideo,
+		},
+		{
+			name: "escaped character outside base64",
+			body: strings.NewReader(`{"skeleton_video":"Pz8\u00e9","score":"70"}`),
+			want: errInvalidEscape,
+		},
+		{
+			name: "escaped quote",
+			body: strings.NewReader(`{"skeleton_video":"Pz8\"","score":"70"}`),
+			want: errInvalidEscape,
+		},
+		{
+			name:      "truncated in the video",
+			body:      strings.NewReader(`{"skeleton_video":"` + encoded[:len(encoded)/2]),
+			want:      ErrTruncatedAnswer,
+			retryable: true,
+		},
+		{
+			name:      "truncated after the video",
+			body:      strings.NewReader(`{"skeleton_video":"` + encoded + `","score":"8`),
+			want:      ErrTruncatedAnswer,
+			retryable: true,
+		},
+		{
+			name:      "broken stream",
+			body:      io.MultiReader(strings.NewReader(`{"skeleton_video":"`+encoded[:100]), iotest.ErrReader(io.ErrClosedPipe)),
+			want:      io.ErrClosedPipe,
+			retryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeResult(tt.body, filepath.Join(t.TempDir(), "skeleton.mp4"))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if resilience.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", !tt.retryable, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestMultipartBody(t *testing.T) {
+	video := testVideo()
+	path := filepath.Join(t.TempDir(), "upload.mp4")
+	if err := os.WriteFile(path, video, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	body, contentType, err := multipartBody(path, "serve.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("content type %q: %v", contentType, err)
+	}
+	form := multipart.NewReader(body, params["boundary"])
+	part, err := form.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.FormName() != "file" || part.FileName() != "serve.mp4" {
+		t.Errorf("part %q with file %q, want file with serve.mp4", part.FormName(), part.FileName())
+	}
+	uploaded, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(uploaded, video) {
+		t.Errorf("uploaded %d bytes, want %d", len(uploaded), len(video))
+	}
+	if _, err := form.NextPart(); err != io.EOF {
+		t.Errorf("more parts after the file: %v", err)
+	}
+}
+
+func TestMultipartBodyMissingFile(t *testing.T) {
+	if _, _, err := multipartBody(filepath.Join(t.TempDir(), "missing.mp4"), "missing.mp4"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want %v", err, os.ErrNotExist)
+	}
+}
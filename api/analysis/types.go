@@ -0,0 +1,141 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/HeavenAQ/resilience"
+)
+
+// Client sends a video to the pose-estimation service and returns its
+// analysis.
+type Client interface {
+	Analyze(ctx context.Context, req Request) (*Result, error)
+}
+
+// Request describes the video to analyze. The video is read from VideoPath
+// on every attempt so a retry never sends a half-consumed stream, and the
+// skeleton video of the answer is written to SkeletonPath.
+type Request struct {
+	VideoPath    string
+	SkeletonPath string
+	Filename     string
+	Handedness   string
+	Skill        string
+	Model        string
+}
+
+// Result is the response of POST /analyze without the base64 encoded
+// skeleton_video, which is saved to Request.SkeletonPath instead.
+type Result struct {
+	Score       string   `json:"score"`
+	Suggestions []string `json:"suggestions"`
+	Details
+}
+
+// Details are the structured results of the AI server. Every field is
+// optional so older servers that only send a score keep working.
+type Details struct {
+	Phases      []PhaseScore `json:"phases,omitempty"`
+	JointAngles []JointAngle `json:"joint_angles,omitempty"`
+	Faults      []Fault      `json:"faults,omitempty"`
+	Keyframes   []Keyframe   `json:"keyframes,omitempty"`
+}
+
+type PhaseScore struct {
+	Phase string  `json:"phase"`
+	Score float32 `json:"score"`
+}
+
+// JointAngle is in degrees; ExpectedMin and ExpectedMax is the range the
+// model considers correct.
+type JointAngle struct {
+	Joint       string  `json:"joint"`
+	Phase       string  `json:"phase"`
+	Angle       float32 `json:"angle"`
+	ExpectedMin float32 `json:"expected_min"`
+	ExpectedMax float32 `json:"expected_max"`
+}
+
+// Fault severity is one of minor, moderate or major; timestamps are seconds
+// from the start of the video.
+type Fault struct {
+	Code        string  `json:"code"`
+	Severity    string  `json:"severity"`
+	Phase       string  `json:"phase"`
+	Description string  `json:"description"`
+	Timestamp   float32 `json:"timestamp"`
+}
+
+type Keyframe struct {
+	Phase     string  `json:"phase"`
+	Timestamp float32 `json:"timestamp"`
+}
+
+// HTTPClient talks to the AI server at GENAI_URL. Calls are retried with
+// policy and fail fast while breaker is open.
+type HTTPClient struct {
+	client   *http.Client
+	baseURL  string
+	user     string
+	password string
+	policy   resilience.Policy
+	breaker  *resilience.Breaker
+	logger   *log.Logger
+}
+
+// StatusError is an answer of the AI server other than 200.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d from AI server", e.StatusCode)
+}
+
+// FakeClient answers without calling any server. Errors are returned one per
+// call, in order, before Err and Result are used. The uploaded video is
+// echoed back as the skeleton video.
+type FakeClient struct {
+	mu       sync.Mutex
+	Result   *Result
+	Err      error
+	Errors   []error
+	Delay    time.Duration
+	Requests []Request
+}
+
+// MockServerConfig controls how the stand-in AI server answers.
+type MockServerConfig struct {
+	// Latency is waited before every answer
+	Latency time.Duration
+	// Statuses are answered one per request, in order, before the server
+	// starts to succeed, e.g. 502, 500 to exercise retries
+	Statuses []int
+	// ErrorRate is the chance of answering one of ErrorCodes afterwards
+	ErrorRate  float64
+	ErrorCodes []int
+	// RetryAfter is sent with 429 and 503 answers when set
+	RetryAfter time.Duration
+	// SkeletonVideo is the canned video returned; the upload is echoed back
+	// when it is empty
+	SkeletonVideo []byte
+	Score         string
+	Suggestions   []string
+	Details       Details
+	// User and Password enable basic auth when set
+	User     string
+	Password string
+}
+
+type MockServer struct {
+	mu       sync.Mutex
+	config   MockServerConfig
+	requests int
+	logger   *log.Logger
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// FaultMatch is a work with the fault a coach searched for.
+type FaultMatch struct {
+	User  *UserData
+	Skill string
+	Work  Work
+	Fault Fault
+}
+
+// FindFaults returns the works of users that have the fault code at least as
+// severe as minSeverity, newest first. An empty skill searches every skill.
+func FindFaults(users []*UserData, code string, minSeverity FaultSeverity, skill string) []FaultMatch {
+	matches := []FaultMatch{}
+	for _, user := range users {
+		for skillId, works := range user.Portfolio.Skills {
+			if skill != "" && skillId != skill {
+				continue
+			}
+			for _, work := range works {
+				if !work.HasFault(code, minSeverity) {
+					continue
+				}
+				for _, fault := range work.Analysis.Faults {
+					if fault.Code == code {
+						matches = append(matches, FaultMatch{user, skillId, work, fault})
+						break
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		dateI, _ := time.Parse("2006-01-02-15-04", matches[i].Work.DateTime)
+		dateJ, _ := time.Parse("2006-01-02-15-04", matches[j].Work.DateTime)
+		return dateI.After(dateJ)
+	})
+	return matches
+}
+
+// CountFaults counts the works having each fault code.
+func CountFaults(users []*UserData) map[string]int {
+	counts := map[string]int{}
+	for _, user := range users {
+		for _, works := range user.Portfolio.Skills {
+			for _, work := range works {
+				if work.Analysis == nil {
+					continue
+				}
+				seen := map[string]bool{}
+				for _, fault := range work.Analysis.Faults {
+					if !seen[fault.Code] {
+						seen[fault.Code] = true
+						counts[fault.Code]++
+					}
+				}
+			}
+		}
+	}
+	return counts
+}
@@ -2,25 +2,28 @@ package db
 
 import (
 	"context"
-	"log"
-	"os"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
 	"github.com/HeavenAQ/api/secret"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
-func NewFirebaseHandler() (*FirebaseHandler, error) {
+// NewFirebaseHandler connects to Firestore with the service account stored
+// in the secret credentials.
+func NewFirebaseHandler(projectId string, secrets secret.Provider, credentials string, collections FirebaseCollections) (*FirebaseHandler, error) {
 	ctx := context.Background()
 
-	// get firebase credentials from secret manager
-	secretName := secret.GetSecretNameString(os.Getenv("FIREBASE_CREDENTIALS"))
-	firebaseCredentials, err := secret.AccessSecretVersion(secretName)
+	// get firebase credentials from the secret provider
+	firebaseCredentials, err := secrets.Get(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
 
 	// get service account and initialize firebase app
 	sa := option.WithCredentialsJSON(firebaseCredentials)
-	conf := &firebase.Config{ProjectID: os.Getenv("FIREBASE_PROJECT_ID")}
+	conf := &firebase.Config{ProjectID: projectId}
 	app, err := firebase.NewApp(ctx, conf, sa)
 	if err != nil {
 		return nil, err
@@ -29,12 +32,21 @@ func NewFirebaseHandler() (*FirebaseHandler, error) {
 	// initialize firestore client
 	client, err := app.Firestore(ctx)
 	if err != nil {
-		log.Fatal("Error initializing firebase database client:", err)
+		return nil, err
+	}
+	return &FirebaseHandler{client, ctx, collections}, nil
+}
+
+// Ping reads at most one user, which needs both the credentials and the
+// collection to be valid.
+func (handler *FirebaseHandler) Ping(ctx context.Context) error {
+	_, err := handler.GetUsersCollection().Limit(1).Documents(ctx).Next()
+	if err == iterator.Done {
+		return nil
 	}
-	return &FirebaseHandler{client, ctx}, nil
+	return err
 }
 
 func (handler *FirebaseHandler) GetUsersCollection() *firestore.CollectionRef {
-	collection := os.Getenv("FIREBASE_USERS")
-	return handler.dbClient.Collection(collection)
+	return handler.dbClient.Collection(handler.collections.Users)
 }
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrJobExists   = errors.New("job already exists")
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobLost     = errors.New("job is no longer owned by this instance")
+)
+
+func newQueuedJob(job *Job) {
+	now := time.Now()
+	job.Status = JobQueued
+	job.Error = ""
+	job.CreatedAt = now
+	job.UpdatedAt = now
+}
+
+func (handler *FirebaseHandler) GetJobsCollection() *firestore.CollectionRef {
+	return handler.dbClient.Collection(handler.collections.Jobs)
+}
+
+func (handler *FirebaseHandler) CreateJob(job *Job) error {
+	newQueuedJob(job)
+	_, err := handler.GetJobsCollection().Doc(job.Id).Create(handler.ctx, job)
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrJobExists
+	}
+	return err
+}
+
+func (handler *FirebaseHandler) GetJob(jobId string) (*Job, error) {
+	doc, err := handler.GetJobsCollection().Doc(jobId).Get(handler.ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{}
+	if err := doc.DataTo(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (handler *FirebaseHandler) UpdateJobStatus(jobId string, jobStatus JobStatus, errMsg string) error {
+	_, err := handler.GetJobsCollection().Doc(jobId).Update(handler.ctx, []firestore.Update{
+		{Path: "Status", Value: jobStatus},
+		{Path: "Error", Value: errMsg},
+		{Path: "UpdatedAt", Value: time.Now()},
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrJobNotFound
+	}
+	return err
+}
+
+func (handler *FirebaseHandler) GetJobsByStatus(status JobStatus) ([]*Job, error) {
+	docs, err := handler.GetJobsCollection().Where("Status", "==", status).Documents(handler.ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []*Job{}
+	for _, doc := range docs {
+		job := &Job{}
+		if err := doc.DataTo(job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (handler *FirebaseHandler) ClaimJob(jobId string, owner string, lease time.Duration) (bool, error) {
+	ref := handler.GetJobsCollection().Doc(jobId)
+	claimed := false
+	err := handler.dbClient.RunTransaction(handler.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		job, err := getJobIn(tx, ref)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		claimed = job.claimable(owner, now)
+		if !claimed {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "Status", Value: JobRunning},
+			{Path: "Error", Value: ""},
+			{Path: "Owner", Value: owner},
+			{Path: "LeaseUntil", Value: now.Add(lease)},
+			{Path: "UpdatedAt", Value: now},
+		})
+	})
+	return claimed, err
+}
+
+func (handler *FirebaseHandler) RenewJobLease(jobId string, owner string, lease time.Duration) error {
+	ref := handler.GetJobsCollection().Doc(jobId)
+	return handler.dbClient.RunTransaction(handler.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		job, err := getJobIn(tx, ref)
+		if err != nil {
+			return err
+		}
+		if job.Status != JobRunning || job.Owner != owner {
+			return ErrJobLost
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "LeaseUntil", Value: time.Now().Add(lease)},
+		})
+	})
+}
+
+func getJobIn(tx *firestore.Transaction, ref *firestore.DocumentRef) (*Job, error) {
+	doc, err := tx.Get(ref)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err := doc.DataTo(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// claimed lets setups hold the job for owner until lease from now, a
+// negative lease leaving it expired.
+func claimed(owner string, lease time.Duration) func(store Store) error {
+	return func(store Store) error {
+		_, err := store.ClaimJob("job", owner, lease)
+		return err
+	}
+}
+
+func finished(status JobStatus) func(store Store) error {
+	return func(store Store) error {
+		return store.UpdateJobStatus("job", status, "")
+	}
+}
+
+func TestClaimJob(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup brings the job to the state under test
+		setup func(store Store) error
+		want  bool
+	}{
+		{"queued", nil, true},
+		{"held by another instance", claimed("a", time.Minute), false},
+		{"lease of another instance expired", claimed("a", -time.Second), true},
+		{"claimed again by its owner", claimed("b", time.Minute), true},
+		{"succeeded", finished(JobSucceeded), false},
+		{"failed", finished(JobFailed), false},
+	}
+
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				if err := store.CreateJob(&Job{Id: "job", UserId: "U1234", Skill: "serve"}); err != nil {
+					t.Fatal(err)
+				}
+				if tt.setup != nil {
+					if err := tt.setup(store); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				ok, err := store.ClaimJob("job", "b", time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != tt.want {
+					t.Fatalf("claimed = %v, want %v", ok, tt.want)
+				}
+				job, err := store.GetJob("job")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok && (job.Status != JobRunning || job.Owner != "b" || !job.LeaseUntil.After(time.Now())) {
+					t.Errorf("claimed job %+v, want running for b", job)
+				}
+			})
+		}
+	}
+}
+
+func TestRenewJobLease(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(store Store) error
+		want  error
+	}{
+		{"held", claimed("b", time.Minute), nil},
+		{"expired but not taken over", claimed("b", -time.Second), nil},
+		{"taken over", func(store Store) error {
+			if err := claimed("b", -time.Second)(store); err != nil {
+				return err
+			}
+			return claimed("c", time.Minute)(store)
+		}, ErrJobLost},
+		{"queued again", nil, ErrJobLost},
+		{"finished", finished(JobFailed), ErrJobLost},
+	}
+
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				if err := store.CreateJob(&Job{Id: "job", UserId: "U1234", Skill: "serve"}); err != nil {
+					t.Fatal(err)
+				}
+				if tt.setup != nil {
+					if err := tt.setup(store); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err := store.RenewJobLease("job", "b", time.Hour)
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("err = %v, want %v", err, tt.want)
+				}
+				job, err := store.GetJob("job")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.want == nil && time.Until(job.LeaseUntil) < 50*time.Minute {
+					t.Errorf("lease until %v, want an hour from now", job.LeaseUntil)
+				}
+			})
+		}
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.ClaimJob("missing", "b", time.Minute); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("claim: err = %v, want %v", err, ErrJobNotFound)
+			}
+			if err := store.RenewJobLease("missing", "b", time.Minute); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("renew: err = %v, want %v", err, ErrJobNotFound)
+			}
+			if err := store.CreateJob(&Job{Id: "job"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.CreateJob(&Job{Id: "job"}); !errors.Is(err, ErrJobExists) {
+				t.Errorf("create twice: err = %v, want %v", err, ErrJobExists)
+			}
+		})
+	}
+}
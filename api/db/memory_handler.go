@@ -0,0 +1,336 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	drive "github.com/HeavenAQ/api/drive"
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+func NewMemoryHandler() *MemoryHandler {
+	return &MemoryHandler{
+		users:    map[string]*UserData{},
+		sessions: map[string]UserSession{},
+		jobs:     map[string]Job{},
+		events:   map[string]time.Time{},
+	}
+}
+
+func cloneWorks(works map[string]Work) map[string]Work {
+	if works == nil {
+		return nil
+	}
+	cloned := make(map[string]Work, len(works))
+	for date, work := range works {
+		work.Analysis = cloneAnalysis(work.Analysis)
+		cloned[date] = work
+	}
+	return cloned
+}
+
+func cloneAnalysis(analysis *WorkAnalysis) *WorkAnalysis {
+	if analysis == nil {
+		return nil
+	}
+	return &WorkAnalysis{
+		Phases:      append([]PhaseScore(nil), analysis.Phases...),
+		JointAngles: append([]JointAngle(nil), analysis.JointAngles...),
+		Faults:      append([]Fault(nil), analysis.Faults...),
+		Keyframes:   append([]Keyframe(nil), analysis.Keyframes...),
+	}
+}
+
+// cloneUserData deep copies the folder and portfolio maps so that callers
+// never share state with the data held by the handler.
+func cloneUserData(user *UserData) *UserData {
+	cloned := *user
+	cloned.FolderIds.Skills = map[string]string{}
+	for skill, folderId := range user.FolderIds.Skills {
+		cloned.FolderIds.Skills[skill] = folderId
+	}
+	cloned.Portfolio = Portfolio{Skills: map[string]map[string]Work{}}
+	for skill, works := range user.Portfolio.Skills {
+		cloned.Portfolio.Skills[skill] = cloneWorks(works)
+	}
+	return &cloned
+}
+
+func (handler *MemoryHandler) CreateUserData(userFolders *drive.UserFolders) (*UserData, error) {
+	newUserTemplate := newUserTemplate(userFolders)
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	handler.users[newUserTemplate.Id] = cloneUserData(newUserTemplate)
+	return newUserTemplate, nil
+}
+
+func (handler *MemoryHandler) GetUserData(userId string) (*UserData, error) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	user, ok := handler.users[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return cloneUserData(user), nil
+}
+
+func (handler *MemoryHandler) updateUserData(user *UserData) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	handler.users[user.Id] = cloneUserData(user)
+	return nil
+}
+
+func (handler *MemoryHandler) UpdateUserHandedness(user *UserData, handedness Handedness) error {
+	user.Handedness = handedness
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserSkillFolder(user *UserData, skill string, folderId string) error {
+	user.FolderIds.Skills[skill] = folderId
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserRole(user *UserData, role Role) error {
+	user.Role = role
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserClassCode(user *UserData, classCode string) error {
+	user.ClassCode = classCode
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserReminderOptOut(user *UserData, optOut bool) error {
+	user.ReminderOptOut = optOut
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserLanguage(user *UserData, language string) error {
+	user.Language = language
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) GetUsersByClass(classCode string) ([]*UserData, error) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	users := []*UserData{}
+	for _, user := range handler.users {
+		if user.ClassCode == classCode {
+			users = append(users, cloneUserData(user))
+		}
+	}
+	return users, nil
+}
+
+func (handler *MemoryHandler) ListUsers() ([]*UserData, error) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	users := []*UserData{}
+	for _, user := range handler.users {
+		users = append(users, cloneUserData(user))
+	}
+	return users, nil
+}
+
+func (handler *MemoryHandler) UpdateUserTestNumber(user *UserData, testNumber int) error {
+	user.TestNumber = testNumber
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) CreateUserPortfolioVideo(user *UserData, userPortfolio *map[string]Work, skill string, sourceMessageId string, videoFile *drive.UploadedFile, thumbnailFile *drive.UploadedFile, originalFile *drive.UploadedFile, aiRating float32, aiSuggestions string, analysis *WorkAnalysis) error {
+	putPortfolioVideo(userPortfolio, sourceMessageId, videoFile, thumbnailFile, originalFile, aiRating, aiSuggestions, analysis)
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserPortfolioReflection(user *UserData, userPortfolio *map[string]Work, session *UserSession, reflection string) error {
+	setWorkReflection(userPortfolio, session.UpdatingDate, reflection)
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserPortfolioPreviewNote(user *UserData, userPortfolio *map[string]Work, session *UserSession, previewNote string) error {
+	setWorkPreviewNote(userPortfolio, session.UpdatingDate, previewNote)
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) UpdateUserPortfolioWork(user *UserData, userPortfolio *map[string]Work, skill string, work Work) error {
+	(*userPortfolio)[work.DateTime] = work
+	return handler.updateUserData(user)
+}
+
+func (handler *MemoryHandler) GetUserSession(userId string) (*UserSession, error) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	session, ok := handler.sessions[userId]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (handler *MemoryHandler) NewUserSession(userId string) (*UserSession, error) {
+	newSession := UserSession{
+		UserState:    None,
+		UpdatingDate: "",
+		Skill:        "",
+	}
+	err := handler.UpdateUserSession(userId, newSession)
+	if err != nil {
+		return nil, err
+	}
+	return &newSession, nil
+}
+
+func (handler *MemoryHandler) UpdateUserSession(userId string, userSession UserSession) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	handler.sessions[userId] = userSession
+	return nil
+}
+
+// UpdateSessionUserState and UpdateSessionUserSkill hold the lock for the
+// whole read-modify-write so concurrent updates cannot drop each other.
+func (handler *MemoryHandler) UpdateSessionUserState(userId string, state UserState) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	userSession, ok := handler.sessions[userId]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	userSession.UserState = state
+	handler.sessions[userId] = userSession
+	return nil
+}
+
+func (handler *MemoryHandler) UpdateSessionUserSkill(userId string, skill string) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	userSession, ok := handler.sessions[userId]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	userSession.Skill = skill
+	handler.sessions[userId] = userSession
+	return nil
+}
+
+func (handler *MemoryHandler) CountSessionsByState() (map[UserState]int, error) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	counts := map[UserState]int{}
+	for _, session := range handler.sessions {
+		counts[session.UserState]++
+	}
+	return counts, nil
+}
+
+func (handler *MemoryHandler) CreateJob(job *Job) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if _, ok := handler.jobs[job.Id]; ok {
+		return ErrJobExists
+	}
+	newQueuedJob(job)
+	handler.jobs[job.Id] = *job
+	return nil
+}
+
+func (handler *MemoryHandler) GetJob(jobId string) (*Job, error) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	job, ok := handler.jobs[jobId]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return &job, nil
+}
+
+func (handler *MemoryHandler) UpdateJobStatus(jobId string, status JobStatus, errMsg string) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	job, ok := handler.jobs[jobId]
+	if !ok {
+		return ErrJobNotFound
+	}
+	job.Status = status
+	job.Error = errMsg
+	job.UpdatedAt = time.Now()
+	handler.jobs[jobId] = job
+	return nil
+}
+
+func (handler *MemoryHandler) GetJobsByStatus(status JobStatus) ([]*Job, error) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	jobs := []*Job{}
+	for _, job := range handler.jobs {
+		if job.Status == status {
+			job := job
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
+
+func (handler *MemoryHandler) ClaimJob(jobId string, owner string, lease time.Duration) (bool, error) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	job, ok := handler.jobs[jobId]
+	if !ok {
+		return false, ErrJobNotFound
+	}
+	now := time.Now()
+	if !job.claimable(owner, now) {
+		return false, nil
+	}
+	job.Status = JobRunning
+	job.Error = ""
+	job.Owner = owner
+	job.LeaseUntil = now.Add(lease)
+	job.UpdatedAt = now
+	handler.jobs[jobId] = job
+	return true, nil
+}
+
+func (handler *MemoryHandler) RenewJobLease(jobId string, owner string, lease time.Duration) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	job, ok := handler.jobs[jobId]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if job.Status != JobRunning || job.Owner != owner {
+		return ErrJobLost
+	}
+	job.LeaseUntil = time.Now().Add(lease)
+	handler.jobs[jobId] = job
+	return nil
+}
+
+func (handler *MemoryHandler) ClaimWebhookEvent(eventId string, ttl time.Duration) (bool, error) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	now := time.Now()
+	for id, expiresAt := range handler.events {
+		if expiresAt.Before(now) {
+			delete(handler.events, id)
+		}
+	}
+	if _, ok := handler.events[eventId]; ok {
+		return false, nil
+	}
+	handler.events[eventId] = now.Add(ttl)
+	return true, nil
+}
+
+func (handler *MemoryHandler) CompleteWebhookEvent(eventId string, ttl time.Duration) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	handler.events[eventId] = time.Now().Add(ttl)
+	return nil
+}
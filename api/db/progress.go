@@ -0,0 +1,118 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// WeeklyProgress counts the portfolio items a user produced in one week.
+type WeeklyProgress struct {
+	Videos       int
+	Reflections  int
+	PreviewNotes int
+}
+
+// WeekStart returns Monday 00:00 of the week t falls in, in t's location.
+func WeekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	year, month, day := t.AddDate(0, 0, -offset).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// WeeklyProgress looks at the works of every skill dated in the week of now.
+// Work dates carry no zone, so now must be in the zone they were stamped in,
+// TIME_ZONE.
+func (user *UserData) WeeklyProgress(now time.Time) WeeklyProgress {
+	start := WeekStart(now)
+	end := start.AddDate(0, 0, 7)
+
+	var progress WeeklyProgress
+	for _, works := range user.Portfolio.Skills {
+		for _, work := range works {
+			date, err := time.ParseInLocation("2006-01-02-15-04", work.DateTime, now.Location())
+			if err != nil || date.Before(start) || !date.Before(end) {
+				continue
+			}
+			progress.Videos++
+			if work.Reflection != DefaultReflection && work.Reflection != "" {
+				progress.Reflections++
+			}
+			if work.PreviewNote != DefaultPreviewNote && work.PreviewNote != "" {
+				progress.PreviewNotes++
+			}
+		}
+	}
+	return progress
+}
+
+// RatingPoint is the AI rating of one work.
+type RatingPoint struct {
+	Date   time.Time
+	Rating float32
+}
+
+// RatingHistory returns the ratings of a skill, oldest first. Work dates are
+// read in loc, the zone they were stamped in.
+func (user *UserData) RatingHistory(skill string, loc *time.Location) []RatingPoint {
+	history := []RatingPoint{}
+	for _, work := range user.Portfolio.Skills[skill] {
+		date, err := time.ParseInLocation("2006-01-02-15-04", work.DateTime, loc)
+		if err != nil {
+			continue
+		}
+		history = append(history, RatingPoint{date, work.Rating})
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Date.Before(history[j].Date)
+	})
+	return history
+}
+
+// TrendStats summarizes a rating history. WeekChange is the average of the
+// week of now minus the average of the week before; HasWeekChange is false
+// when either week has no ratings.
+type TrendStats struct {
+	Count         int
+	Best          float32
+	Latest        float32
+	Average       float32
+	WeekChange    float32
+	HasWeekChange bool
+}
+
+func NewTrendStats(history []RatingPoint, now time.Time) TrendStats {
+	var stats TrendStats
+	if len(history) == 0 {
+		return stats
+	}
+
+	thisWeek := WeekStart(now)
+	lastWeek := thisWeek.AddDate(0, 0, -7)
+	nextWeek := thisWeek.AddDate(0, 0, 7)
+	var sum, thisSum, lastSum float32
+	var thisCount, lastCount int
+	for _, point := range history {
+		sum += point.Rating
+		if point.Rating > stats.Best || stats.Count == 0 {
+			stats.Best = point.Rating
+		}
+		stats.Count++
+
+		switch {
+		case !point.Date.Before(thisWeek) && point.Date.Before(nextWeek):
+			thisSum += point.Rating
+			thisCount++
+		case !point.Date.Before(lastWeek) && point.Date.Before(thisWeek):
+			lastSum += point.Rating
+			lastCount++
+		}
+	}
+
+	stats.Latest = history[len(history)-1].Rating
+	stats.Average = sum / float32(stats.Count)
+	if thisCount > 0 && lastCount > 0 {
+		stats.WeekChange = thisSum/float32(thisCount) - lastSum/float32(lastCount)
+		stats.HasWeekChange = true
+	}
+	return stats
+}
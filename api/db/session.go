@@ -1,14 +1,11 @@
 package db
 
 import (
-	"os"
-
 	"cloud.google.com/go/firestore"
 )
 
 func (handler *FirebaseHandler) GetSessionCollection() *firestore.CollectionRef {
-	collection := os.Getenv("FIREBASE_SESSIONS")
-	return handler.dbClient.Collection(collection)
+	return handler.dbClient.Collection(handler.collections.Sessions)
 }
 
 func (handler *FirebaseHandler) GetUserSession(userId string) (*UserSession, error) {
@@ -59,3 +56,19 @@ func (handler *FirebaseHandler) UpdateSessionUserSkill(userId string, skill stri
 	userSession.Skill = skill
 	return handler.UpdateUserSession(userId, *userSession)
 }
+
+func (handler *FirebaseHandler) CountSessionsByState() (map[UserState]int, error) {
+	docs, err := handler.GetSessionCollection().Select("UserState").Documents(handler.ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	counts := map[UserState]int{}
+	for _, doc := range docs {
+		var session UserSession
+		if err := doc.DataTo(&session); err != nil {
+			return nil, err
+		}
+		counts[session.UserState]++
+	}
+	return counts, nil
+}
@@ -0,0 +1,606 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	drive "github.com/HeavenAQ/api/drive"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLHandler opens a SQLite or Postgres database and brings its schema up
+// to date. driver is either "sqlite" or "postgres".
+func NewSQLHandler(driver string, dsn string) (*SQLHandler, error) {
+	if driver != "sqlite" && driver != "postgres" {
+		return nil, errors.New("unsupported sql driver: " + driver)
+	}
+
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// sqlite only allows a single writer at a time
+	if driver == "sqlite" {
+		conn.SetMaxOpenConns(1)
+		if _, err := conn.Exec("PRAGMA foreign_keys = ON"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	handler := &SQLHandler{conn, driver}
+	if err := handler.migrate(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	return handler, nil
+}
+
+func (handler *SQLHandler) Ping(ctx context.Context) error {
+	return handler.db.PingContext(ctx)
+}
+
+func (handler *SQLHandler) Close() error {
+	return handler.db.Close()
+}
+
+// rebind rewrites the ? placeholders used throughout this file into the
+// $1, $2, ... form expected by Postgres.
+func (handler *SQLHandler) rebind(query string) string {
+	if handler.driver != "postgres" {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// the structured analysis of a work is kept as JSON, an empty string means
+// the work has none
+func encodeAnalysis(analysis *WorkAnalysis) (string, error) {
+	if analysis == nil {
+		return "", nil
+	}
+	encoded, err := json.Marshal(analysis)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func decodeAnalysis(encoded string) (*WorkAnalysis, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	analysis := &WorkAnalysis{}
+	if err := json.Unmarshal([]byte(encoded), analysis); err != nil {
+		return nil, err
+	}
+	return analysis, nil
+}
+
+func (handler *SQLHandler) exec(query string, args ...any) error {
+	_, err := handler.db.Exec(handler.rebind(query), args...)
+	return err
+}
+
+func (handler *SQLHandler) migrate() error {
+	err := handler.exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	row := handler.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	if err := row.Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := handler.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(handler.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (handler *SQLHandler) CreateUserData(userFolders *drive.UserFolders) (*UserData, error) {
+	newUserTemplate := newUserTemplate(userFolders)
+
+	tx, err := handler.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		handler.rebind(`INSERT INTO users (id, name, test_number, handedness, root_folder_id, role, class_code) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		newUserTemplate.Id,
+		newUserTemplate.Name,
+		newUserTemplate.TestNumber,
+		newUserTemplate.Handedness,
+		newUserTemplate.FolderIds.Root,
+		newUserTemplate.Role,
+		newUserTemplate.ClassCode,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for skill, folderId := range newUserTemplate.FolderIds.Skills {
+		_, err = tx.Exec(
+			handler.rebind(`INSERT INTO user_folders (user_id, skill, folder_id) VALUES (?, ?, ?)`),
+			newUserTemplate.Id, skill, folderId,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return newUserTemplate, nil
+}
+
+func (handler *SQLHandler) GetUserData(userId string) (*UserData, error) {
+	user := &UserData{
+		FolderIds: FolderIds{Skills: map[string]string{}},
+		Portfolio: Portfolio{Skills: map[string]map[string]Work{}},
+	}
+	row := handler.db.QueryRow(
+		handler.rebind(`SELECT id, name, test_number, handedness, root_folder_id, role, class_code, reminder_opt_out, language FROM users WHERE id = ?`),
+		userId,
+	)
+	err := row.Scan(&user.Id, &user.Name, &user.TestNumber, &user.Handedness, &user.FolderIds.Root, &user.Role, &user.ClassCode, &user.ReminderOptOut, &user.Language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// folders
+	rows, err := handler.db.Query(handler.rebind(`SELECT skill, folder_id FROM user_folders WHERE user_id = ?`), userId)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var skill, folderId string
+		if err := rows.Scan(&skill, &folderId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		user.FolderIds.Skills[skill] = folderId
+		user.Portfolio.Skills[skill] = map[string]Work{}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// works
+	rows, err = handler.db.Query(
+		handler.rebind(`SELECT skill, date, thumbnail, skeleton_video, reflection, preview_note, ai_note, rating, source_message_id, analysis, original_video FROM works WHERE user_id = ?`),
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var skill, encodedAnalysis string
+		var work Work
+		err := rows.Scan(&skill, &work.DateTime, &work.Thumbnail, &work.SkeletonVideo, &work.Reflection, &work.PreviewNote, &work.AINote, &work.Rating, &work.SourceMessageId, &encodedAnalysis, &work.OriginalVideo)
+		if err != nil {
+			return nil, err
+		}
+		if work.Analysis, err = decodeAnalysis(encodedAnalysis); err != nil {
+			return nil, err
+		}
+		if user.Portfolio.Skills[skill] == nil {
+			user.Portfolio.Skills[skill] = map[string]Work{}
+		}
+		user.Portfolio.Skills[skill][work.DateTime] = work
+	}
+	return user, rows.Err()
+}
+
+// GetSkillPortfolio loads the works of a single skill without reading the
+// rest of the user's portfolio.
+func (handler *SQLHandler) GetSkillPortfolio(userId string, skill string) (map[string]Work, error) {
+	rows, err := handler.db.Query(
+		handler.rebind(`SELECT date, thumbnail, skeleton_video, reflection, preview_note, ai_note, rating, source_message_id, analysis, original_video FROM works WHERE user_id = ? AND skill = ?`),
+		userId, skill,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	works := map[string]Work{}
+	for rows.Next() {
+		var work Work
+		var encodedAnalysis string
+		err := rows.Scan(&work.DateTime, &work.Thumbnail, &work.SkeletonVideo, &work.Reflection, &work.PreviewNote, &work.AINote, &work.Rating, &work.SourceMessageId, &encodedAnalysis, &work.OriginalVideo)
+		if err != nil {
+			return nil, err
+		}
+		if work.Analysis, err = decodeAnalysis(encodedAnalysis); err != nil {
+			return nil, err
+		}
+		works[work.DateTime] = work
+	}
+	return works, rows.Err()
+}
+
+func (handler *SQLHandler) UpdateUserHandedness(user *UserData, handedness Handedness) error {
+	user.Handedness = handedness
+	return handler.exec(`UPDATE users SET handedness = ? WHERE id = ?`, handedness, user.Id)
+}
+
+func (handler *SQLHandler) UpdateUserSkillFolder(user *UserData, skill string, folderId string) error {
+	user.FolderIds.Skills[skill] = folderId
+	return handler.exec(
+		`INSERT INTO user_folders (user_id, skill, folder_id) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, skill) DO UPDATE SET folder_id = excluded.folder_id`,
+		user.Id, skill, folderId,
+	)
+}
+
+func (handler *SQLHandler) UpdateUserRole(user *UserData, role Role) error {
+	user.Role = role
+	return handler.exec(`UPDATE users SET role = ? WHERE id = ?`, role, user.Id)
+}
+
+func (handler *SQLHandler) UpdateUserClassCode(user *UserData, classCode string) error {
+	user.ClassCode = classCode
+	return handler.exec(`UPDATE users SET class_code = ? WHERE id = ?`, classCode, user.Id)
+}
+
+func (handler *SQLHandler) UpdateUserReminderOptOut(user *UserData, optOut bool) error {
+	user.ReminderOptOut = optOut
+	return handler.exec(`UPDATE users SET reminder_opt_out = ? WHERE id = ?`, optOut, user.Id)
+}
+
+func (handler *SQLHandler) UpdateUserLanguage(user *UserData, language string) error {
+	user.Language = language
+	return handler.exec(`UPDATE users SET language = ? WHERE id = ?`, language, user.Id)
+}
+
+// getUsers loads every user whose id is returned by the query.
+func (handler *SQLHandler) getUsers(query string, args ...any) ([]*UserData, error) {
+	rows, err := handler.db.Query(handler.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	users := []*UserData{}
+	for _, id := range ids {
+		user, err := handler.GetUserData(id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (handler *SQLHandler) GetUsersByClass(classCode string) ([]*UserData, error) {
+	return handler.getUsers(`SELECT id FROM users WHERE class_code = ? ORDER BY test_number`, classCode)
+}
+
+func (handler *SQLHandler) ListUsers() ([]*UserData, error) {
+	return handler.getUsers(`SELECT id FROM users ORDER BY test_number`)
+}
+
+func (handler *SQLHandler) UpdateUserTestNumber(user *UserData, testNumber int) error {
+	user.TestNumber = testNumber
+	return handler.exec(`UPDATE users SET test_number = ? WHERE id = ?`, testNumber, user.Id)
+}
+
+func (handler *SQLHandler) CreateUserPortfolioVideo(user *UserData, userPortfolio *map[string]Work, skill string, sourceMessageId string, videoFile *drive.UploadedFile, thumbnailFile *drive.UploadedFile, originalFile *drive.UploadedFile, aiRating float32, aiSuggestions string, analysis *WorkAnalysis) error {
+	work := putPortfolioVideo(userPortfolio, sourceMessageId, videoFile, thumbnailFile, originalFile, aiRating, aiSuggestions, analysis)
+
+	encodedAnalysis, err := encodeAnalysis(work.Analysis)
+	if err != nil {
+		return err
+	}
+
+	return handler.exec(
+		`INSERT INTO works (user_id, skill, date, thumbnail, skeleton_video, reflection, preview_note, ai_note, rating, source_message_id, analysis, original_video)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, skill, date) DO UPDATE SET
+			thumbnail = excluded.thumbnail,
+			skeleton_video = excluded.skeleton_video,
+			ai_note = excluded.ai_note,
+			rating = excluded.rating,
+			source_message_id = excluded.source_message_id,
+			analysis = excluded.analysis,
+			original_video = excluded.original_video`,
+		user.Id, skill, work.DateTime, work.Thumbnail, work.SkeletonVideo, work.Reflection, work.PreviewNote, work.AINote, work.Rating, work.SourceMessageId, encodedAnalysis, work.OriginalVideo,
+	)
+}
+
+func (handler *SQLHandler) UpdateUserPortfolioReflection(user *UserData, userPortfolio *map[string]Work, session *UserSession, reflection string) error {
+	setWorkReflection(userPortfolio, session.UpdatingDate, reflection)
+	return handler.exec(
+		`UPDATE works SET reflection = ? WHERE user_id = ? AND skill = ? AND date = ?`,
+		reflection, user.Id, session.Skill, session.UpdatingDate,
+	)
+}
+
+func (handler *SQLHandler) UpdateUserPortfolioPreviewNote(user *UserData, userPortfolio *map[string]Work, session *UserSession, previewNote string) error {
+	setWorkPreviewNote(userPortfolio, session.UpdatingDate, previewNote)
+	return handler.exec(
+		`UPDATE works SET preview_note = ? WHERE user_id = ? AND skill = ? AND date = ?`,
+		previewNote, user.Id, session.Skill, session.UpdatingDate,
+	)
+}
+
+func (handler *SQLHandler) UpdateUserPortfolioWork(user *UserData, userPortfolio *map[string]Work, skill string, work Work) error {
+	encodedAnalysis, err := encodeAnalysis(work.Analysis)
+	if err != nil {
+		return err
+	}
+	(*userPortfolio)[work.DateTime] = work
+	return handler.exec(
+		`UPDATE works SET thumbnail = ?, skeleton_video = ?, reflection = ?, preview_note = ?, ai_note = ?, rating = ?, analysis = ?, original_video = ?
+		WHERE user_id = ? AND skill = ? AND date = ?`,
+		work.Thumbnail, work.SkeletonVideo, work.Reflection, work.PreviewNote, work.AINote, work.Rating, encodedAnalysis, work.OriginalVideo,
+		user.Id, skill, work.DateTime,
+	)
+}
+
+func (handler *SQLHandler) GetUserSession(userId string) (*UserSession, error) {
+	var session UserSession
+	row := handler.db.QueryRow(
+		handler.rebind(`SELECT skill, updating_date, user_state FROM sessions WHERE user_id = ?`),
+		userId,
+	)
+	err := row.Scan(&session.Skill, &session.UpdatingDate, &session.UserState)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (handler *SQLHandler) NewUserSession(userId string) (*UserSession, error) {
+	newSession := UserSession{
+		UserState:    None,
+		UpdatingDate: "",
+		Skill:        "",
+	}
+	err := handler.UpdateUserSession(userId, newSession)
+	if err != nil {
+		return nil, err
+	}
+	return &newSession, nil
+}
+
+func (handler *SQLHandler) UpdateUserSession(userId string, userSession UserSession) error {
+	return handler.exec(
+		`INSERT INTO sessions (user_id, skill, updating_date, user_state) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			skill = excluded.skill,
+			updating_date = excluded.updating_date,
+			user_state = excluded.user_state`,
+		userId, userSession.Skill, userSession.UpdatingDate, userSession.UserState,
+	)
+}
+
+func (handler *SQLHandler) UpdateSessionUserState(userId string, state UserState) error {
+	if _, err := handler.GetUserSession(userId); err != nil {
+		return err
+	}
+	return handler.exec(`UPDATE sessions SET user_state = ? WHERE user_id = ?`, state, userId)
+}
+
+func (handler *SQLHandler) UpdateSessionUserSkill(userId string, skill string) error {
+	if _, err := handler.GetUserSession(userId); err != nil {
+		return err
+	}
+	return handler.exec(`UPDATE sessions SET skill = ? WHERE user_id = ?`, skill, userId)
+}
+
+func (handler *SQLHandler) CountSessionsByState() (map[UserState]int, error) {
+	rows, err := handler.db.Query(`SELECT user_state, COUNT(*) FROM sessions GROUP BY user_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[UserState]int{}
+	for rows.Next() {
+		var state UserState
+		var count int
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		counts[state] = count
+	}
+	return counts, rows.Err()
+}
+
+func (handler *SQLHandler) CreateJob(job *Job) error {
+	newQueuedJob(job)
+	res, err := handler.db.Exec(
+		handler.rebind(`INSERT INTO jobs (id, user_id, skill, work_date, status, error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`),
+		job.Id, job.UserId, job.Skill, job.WorkDate, job.Status, job.Error, job.CreatedAt, job.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrJobExists
+	}
+	return nil
+}
+
+func (handler *SQLHandler) GetJob(jobId string) (*Job, error) {
+	job := &Job{}
+	row := handler.db.QueryRow(
+		handler.rebind(`SELECT id, user_id, skill, work_date, status, error, owner, lease_until, created_at, updated_at FROM jobs WHERE id = ?`),
+		jobId,
+	)
+	err := row.Scan(&job.Id, &job.UserId, &job.Skill, &job.WorkDate, &job.Status, &job.Error, &job.Owner, &job.LeaseUntil, &job.CreatedAt, &job.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (handler *SQLHandler) UpdateJobStatus(jobId string, status JobStatus, errMsg string) error {
+	res, err := handler.db.Exec(
+		handler.rebind(`UPDATE jobs SET status = ?, error = ?, updated_at = ? WHERE id = ?`),
+		status, errMsg, time.Now(), jobId,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (handler *SQLHandler) GetJobsByStatus(status JobStatus) ([]*Job, error) {
+	rows, err := handler.db.Query(
+		handler.rebind(`SELECT id, user_id, skill, work_date, status, error, owner, lease_until, created_at, updated_at FROM jobs WHERE status = ? ORDER BY created_at`),
+		status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job := &Job{}
+		if err := rows.Scan(&job.Id, &job.UserId, &job.Skill, &job.WorkDate, &job.Status, &job.Error, &job.Owner, &job.LeaseUntil, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (handler *SQLHandler) ClaimJob(jobId string, owner string, lease time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := handler.db.Exec(
+		handler.rebind(`UPDATE jobs SET status = ?, error = '', owner = ?, lease_until = ?, updated_at = ?
+			WHERE id = ? AND (status = ? OR (status = ? AND (owner = ? OR lease_until < ?)))`),
+		JobRunning, owner, now.Add(lease), now,
+		jobId, JobQueued, JobRunning, owner, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		// tell a missing job apart from one held elsewhere
+		if _, err := handler.GetJob(jobId); err != nil {
+			return false, err
+		}
+	}
+	return n == 1, nil
+}
+
+func (handler *SQLHandler) RenewJobLease(jobId string, owner string, lease time.Duration) error {
+	res, err := handler.db.Exec(
+		handler.rebind(`UPDATE jobs SET lease_until = ? WHERE id = ? AND status = ? AND owner = ?`),
+		time.Now().Add(lease), jobId, JobRunning, owner,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// tell a missing job apart from one lost to another instance
+		if _, err := handler.GetJob(jobId); err != nil {
+			return err
+		}
+		return ErrJobLost
+	}
+	return nil
+}
+
+func (handler *SQLHandler) ClaimWebhookEvent(eventId string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	if err := handler.exec(`DELETE FROM webhook_events WHERE expires_at < ?`, now); err != nil {
+		return false, err
+	}
+	res, err := handler.db.Exec(
+		handler.rebind(`INSERT INTO webhook_events (id, expires_at) VALUES (?, ?) ON CONFLICT (id) DO NOTHING`),
+		eventId, now.Add(ttl),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (handler *SQLHandler) CompleteWebhookEvent(eventId string, ttl time.Duration) error {
+	return handler.exec(`UPDATE webhook_events SET expires_at = ? WHERE id = ?`, time.Now().Add(ttl), eventId)
+}
@@ -0,0 +1,75 @@
+package db
+
+// migrations are applied in order and recorded in schema_migrations. Never
+// edit an entry once it has shipped; append a new one instead. The statements
+// stick to the subset of SQL understood by both SQLite and Postgres.
+var migrations = []string{
+	// 1: users, folders, works and sessions
+	`CREATE TABLE IF NOT EXISTS users (
+		id             TEXT PRIMARY KEY,
+		name           TEXT NOT NULL,
+		test_number    INTEGER NOT NULL,
+		handedness     INTEGER NOT NULL,
+		root_folder_id TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS user_folders (
+		user_id   TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		skill     TEXT NOT NULL,
+		folder_id TEXT NOT NULL,
+		PRIMARY KEY (user_id, skill)
+	);
+	CREATE TABLE IF NOT EXISTS works (
+		user_id        TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		skill          TEXT NOT NULL,
+		date           TEXT NOT NULL,
+		thumbnail      TEXT NOT NULL,
+		skeleton_video TEXT NOT NULL,
+		reflection     TEXT NOT NULL,
+		preview_note   TEXT NOT NULL,
+		ai_note        TEXT NOT NULL,
+		rating         REAL NOT NULL,
+		PRIMARY KEY (user_id, skill, date)
+	);
+	CREATE TABLE IF NOT EXISTS sessions (
+		user_id       TEXT PRIMARY KEY,
+		skill         TEXT NOT NULL,
+		updating_date TEXT NOT NULL,
+		user_state    INTEGER NOT NULL
+	);`,
+	// 2: video analysis jobs
+	`CREATE TABLE IF NOT EXISTS jobs (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		skill      TEXT NOT NULL,
+		status     TEXT NOT NULL,
+		error      TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS jobs_status ON jobs (status);`,
+	// 3: teacher role and classes
+	`ALTER TABLE users ADD COLUMN role INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN class_code TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS users_class_code ON users (class_code);`,
+	// 4: webhook deduplication and the source message of each work
+	`CREATE TABLE IF NOT EXISTS webhook_events (
+		id         TEXT PRIMARY KEY,
+		expires_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS webhook_events_expires_at ON webhook_events (expires_at);
+	ALTER TABLE works ADD COLUMN source_message_id TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX IF NOT EXISTS works_source_message_id ON works (user_id, source_message_id) WHERE source_message_id <> '';`,
+	// 5: structured analysis results stored as JSON
+	`ALTER TABLE works ADD COLUMN analysis TEXT NOT NULL DEFAULT '';`,
+	// 6: opt-out of the weekly reminders
+	`ALTER TABLE users ADD COLUMN reminder_opt_out BOOLEAN NOT NULL DEFAULT FALSE;`,
+	// 7: language of the bot's replies
+	`ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT '';`,
+	// 8: instance running a job and until when
+	`ALTER TABLE jobs ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN lease_until TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';`,
+	// 9: re-analysis of stored works
+	`ALTER TABLE jobs ADD COLUMN work_date TEXT NOT NULL DEFAULT '';`,
+	// 10: the upload of each work, analyzed again on re-analysis
+	`ALTER TABLE works ADD COLUMN original_video TEXT NOT NULL DEFAULT '';`,
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"time"
+
+	drive "github.com/HeavenAQ/api/drive"
+)
+
+// Store is the persistence layer used by the app for user data and sessions.
+// FirebaseHandler is the production implementation, SQLHandler persists to
+// SQLite or Postgres for self-hosted deployments and MemoryHandler keeps
+// everything in process for offline runs and tests.
+type Store interface {
+	// users
+	GetUserData(userId string) (*UserData, error)
+	CreateUserData(userFolders *drive.UserFolders) (*UserData, error)
+	UpdateUserHandedness(user *UserData, handedness Handedness) error
+	UpdateUserTestNumber(user *UserData, testNumber int) error
+	UpdateUserSkillFolder(user *UserData, skill string, folderId string) error
+	UpdateUserRole(user *UserData, role Role) error
+	UpdateUserClassCode(user *UserData, classCode string) error
+	UpdateUserReminderOptOut(user *UserData, optOut bool) error
+	UpdateUserLanguage(user *UserData, language string) error
+	GetUsersByClass(classCode string) ([]*UserData, error)
+	ListUsers() ([]*UserData, error)
+
+	// portfolio
+	CreateUserPortfolioVideo(user *UserData, userPortfolio *map[string]Work, skill string, sourceMessageId string, videoFile *drive.UploadedFile, thumbnailFile *drive.UploadedFile, originalFile *drive.UploadedFile, aiRating float32, aiSuggestions string, analysis *WorkAnalysis) error
+	UpdateUserPortfolioReflection(user *UserData, userPortfolio *map[string]Work, session *UserSession, reflection string) error
+	UpdateUserPortfolioPreviewNote(user *UserData, userPortfolio *map[string]Work, session *UserSession, previewNote string) error
+	UpdateUserPortfolioWork(user *UserData, userPortfolio *map[string]Work, skill string, work Work) error
+
+	// sessions
+	GetUserSession(userId string) (*UserSession, error)
+	NewUserSession(userId string) (*UserSession, error)
+	UpdateUserSession(userId string, userSession UserSession) error
+	UpdateSessionUserState(userId string, state UserState) error
+	UpdateSessionUserSkill(userId string, skill string) error
+	CountSessionsByState() (map[UserState]int, error)
+
+	// video analysis jobs
+	CreateJob(job *Job) error
+	GetJob(jobId string) (*Job, error)
+	UpdateJobStatus(jobId string, status JobStatus, errMsg string) error
+	GetJobsByStatus(status JobStatus) ([]*Job, error)
+	// ClaimJob atomically marks the job running for owner until lease from
+	// now, and reports false when another instance holds it.
+	ClaimJob(jobId string, owner string, lease time.Duration) (bool, error)
+	// RenewJobLease extends owner's lease, ErrJobLost is returned once the
+	// job is no longer running for owner.
+	RenewJobLease(jobId string, owner string, lease time.Duration) error
+
+	// webhook deduplication, also claiming the weekly reminders of a class
+	ClaimWebhookEvent(eventId string, ttl time.Duration) (bool, error)
+	CompleteWebhookEvent(eventId string, ttl time.Duration) error
+}
+
+var (
+	_ Store = (*FirebaseHandler)(nil)
+	_ Store = (*SQLHandler)(nil)
+	_ Store = (*MemoryHandler)(nil)
+)
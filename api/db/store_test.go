@@ -0,0 +1,21 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// testStores returns an empty memory store and an empty SQLite store, so the
+// same cases run against both.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	sqlite, err := NewSQLHandler("sqlite", filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]Store{
+		"memory": NewMemoryHandler(),
+		"sqlite": sqlite,
+	}
+}
@@ -2,14 +2,40 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/firestore"
 )
 
 type FirebaseHandler struct {
-	dbClient *firestore.Client
-	ctx      context.Context
+	dbClient    *firestore.Client
+	ctx         context.Context
+	collections FirebaseCollections
+}
+
+// FirebaseCollections names the Firestore collection of each kind of
+// document.
+type FirebaseCollections struct {
+	Users         string
+	Sessions      string
+	Jobs          string
+	WebhookEvents string
+}
+
+type SQLHandler struct {
+	db     *sql.DB
+	driver string
+}
+
+type MemoryHandler struct {
+	mu       sync.RWMutex
+	users    map[string]*UserData
+	sessions map[string]UserSession
+	jobs     map[string]Job
+	events   map[string]time.Time
 }
 
 type UserSession struct {
@@ -20,13 +46,44 @@ type UserSession struct {
 
 type UserState int8
 
+// the first four values are stored in existing sessions, append new states
+// at the end
 const (
-	WritingReflection = iota
+	WritingReflection UserState = iota
 	WritingPreviewNote
 	UploadingVideo
 	None
+	SelectingAnalyzeHandedness
+	SelectingAnalyzeSkill
+	SelectingReflectionSkill
+	SelectingReflectionDate
+	SelectingPreviewNoteSkill
+	SelectingPreviewNoteDate
+	SelectingExpertHandedness
+	SelectingExpertSkill
 )
 
+func (s UserState) String() string {
+	names := [...]string{
+		"writing_reflection",
+		"writing_preview_note",
+		"uploading_video",
+		"none",
+		"selecting_analyze_handedness",
+		"selecting_analyze_skill",
+		"selecting_reflection_skill",
+		"selecting_reflection_date",
+		"selecting_preview_note_skill",
+		"selecting_preview_note_date",
+		"selecting_expert_handedness",
+		"selecting_expert_skill",
+	}
+	if s < 0 || int(s) >= len(names) {
+		return "unknown"
+	}
+	return names[s]
+}
+
 type UserData struct {
 	Portfolio  Portfolio  `json:"portfolio"`
 	FolderIds  FolderIds  `json:"folderIds"`
@@ -34,34 +91,110 @@ type UserData struct {
 	Id         string     `json:"id"`
 	TestNumber int        `json:"testNumber"`
 	Handedness Handedness `json:"handedness"`
+	Role       Role       `json:"role"`
+	ClassCode  string     `json:"classCode"`
+	// ReminderOptOut stops the weekly reminder pushes
+	ReminderOptOut bool `json:"reminderOptOut"`
+	// Language of the bot's replies, empty until it is taken from the LINE
+	// profile
+	Language string `json:"language"`
+}
+
+// Role defaults to Student so existing users keep their behavior.
+type Role int8
+
+const (
+	Student Role = iota
+	Teacher
+)
+
+func (r Role) String() string {
+	return [...]string{"student", "teacher"}[r]
 }
 
 type FolderIds struct {
-	Root  string `json:"root"`
-	Serve string `json:"serve"`
-	Smash string `json:"smash"`
-	Clear string `json:"clear"`
+	Root string `json:"root"`
+	// Skills maps a skill id to the folder holding its videos
+	Skills map[string]string `json:"skills"`
+
+	// Deprecated: kept to read users created before skills became
+	// configurable, see UserData.MigrateLegacySkills.
+	Serve string `json:"serve,omitempty"`
+	Smash string `json:"smash,omitempty"`
+	Clear string `json:"clear,omitempty"`
 }
 
 type Portfolio struct {
-	Serve map[string]Work `json:"serve"`
-	Smash map[string]Work `json:"smash"`
-	Clear map[string]Work `json:"clear"`
+	// Skills maps a skill id to the works keyed by their date
+	Skills map[string]map[string]Work `json:"skills"`
+
+	// Deprecated: kept to read users created before skills became
+	// configurable, see UserData.MigrateLegacySkills.
+	Serve map[string]Work `json:"serve,omitempty"`
+	Smash map[string]Work `json:"smash,omitempty"`
+	Clear map[string]Work `json:"clear,omitempty"`
 }
 
 func (p *Portfolio) GetSkillPortfolio(skill string) map[string]Work {
-	switch skill {
-	case "serve":
-		return p.Serve
-	case "smash":
-		return p.Smash
-	case "clear":
-		return p.Clear
-	default:
-		return nil
+	return p.Skills[skill]
+}
+
+// MigrateLegacySkills moves folders and works stored in the fixed
+// Serve/Smash/Clear fields into the per-skill maps. It reports whether
+// anything was moved so the caller can persist the new layout.
+func (user *UserData) MigrateLegacySkills() bool {
+	migrated := false
+	if user.FolderIds.Skills == nil {
+		user.FolderIds.Skills = map[string]string{}
+	}
+	if user.Portfolio.Skills == nil {
+		user.Portfolio.Skills = map[string]map[string]Work{}
 	}
+
+	legacyFolders := map[string]*string{
+		"serve": &user.FolderIds.Serve,
+		"smash": &user.FolderIds.Smash,
+		"clear": &user.FolderIds.Clear,
+	}
+	for skill, folderId := range legacyFolders {
+		if *folderId == "" {
+			continue
+		}
+		if _, ok := user.FolderIds.Skills[skill]; !ok {
+			user.FolderIds.Skills[skill] = *folderId
+		}
+		*folderId = ""
+		migrated = true
+	}
+
+	legacyWorks := map[string]*map[string]Work{
+		"serve": &user.Portfolio.Serve,
+		"smash": &user.Portfolio.Smash,
+		"clear": &user.Portfolio.Clear,
+	}
+	for skill, works := range legacyWorks {
+		if *works == nil {
+			continue
+		}
+		if user.Portfolio.Skills[skill] == nil {
+			user.Portfolio.Skills[skill] = map[string]Work{}
+		}
+		for date, work := range *works {
+			user.Portfolio.Skills[skill][date] = work
+		}
+		*works = nil
+		migrated = true
+	}
+	return migrated
 }
 
+// placeholders of a work whose reflection or preview note is not written
+// yet, stored as they are and shown in the user's language
+const (
+	DefaultReflection  = "尚未填寫心得"
+	DefaultPreviewNote = "尚未填寫課前檢視要點"
+)
+
 type Work struct {
 	DateTime      string  `json:"date"`
 	Thumbnail     string  `json:"thumbnail"`
@@ -70,6 +203,145 @@ type Work struct {
 	PreviewNote   string  `json:"previewNote"`
 	AINote        string  `json:"aiNote"`
 	Rating        float32 `json:"rating"`
+	// SourceMessageId is the LINE message the video was uploaded in, used
+	// to keep re-analyzed uploads from adding a second work
+	SourceMessageId string `json:"sourceMessageId,omitempty"`
+	// OriginalVideo is the upload before analysis, sent to the AI server
+	// again on re-analysis. It is empty for works stored before uploads were
+	// kept.
+	OriginalVideo string `json:"originalVideo,omitempty"`
+	// Analysis is nil for works analyzed before the AI server reported
+	// structured results
+	Analysis *WorkAnalysis `json:"analysis,omitempty"`
+}
+
+// WorkAnalysis is the structured result of the AI server for a work.
+type WorkAnalysis struct {
+	Phases      []PhaseScore `json:"phases,omitempty"`
+	JointAngles []JointAngle `json:"jointAngles,omitempty"`
+	Faults      []Fault      `json:"faults,omitempty"`
+	Keyframes   []Keyframe   `json:"keyframes,omitempty"`
+}
+
+// PhaseScore rates one phase of the stroke, e.g. preparation or follow-through.
+type PhaseScore struct {
+	Phase string  `json:"phase"`
+	Score float32 `json:"score"`
+}
+
+// JointAngle is the angle of a joint in degrees at a phase, along with the
+// range expected by the model.
+type JointAngle struct {
+	Joint string  `json:"joint"`
+	Phase string  `json:"phase"`
+	Angle float32 `json:"angle"`
+	Min   float32 `json:"min"`
+	Max   float32 `json:"max"`
+}
+
+func (a JointAngle) InRange() bool {
+	return a.Angle >= a.Min && a.Angle <= a.Max
+}
+
+type FaultSeverity string
+
+const (
+	Minor    FaultSeverity = "minor"
+	Moderate FaultSeverity = "moderate"
+	Major    FaultSeverity = "major"
+)
+
+// Rank orders severities from minor (0) to major (2).
+func (s FaultSeverity) Rank() int {
+	switch s {
+	case Major:
+		return 2
+	case Minor:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// Fault is a problem detected by the model. Code identifies the kind of
+// fault so works can be filtered by it; Timestamp is in seconds from the start
+// of the video.
+type Fault struct {
+	Code        string        `json:"code"`
+	Severity    FaultSeverity `json:"severity"`
+	Phase       string        `json:"phase"`
+	Description string        `json:"description"`
+	Timestamp   float32       `json:"timestamp"`
+}
+
+// Keyframe marks where a phase happens in the video, in seconds.
+type Keyframe struct {
+	Phase     string  `json:"phase"`
+	Timestamp float32 `json:"timestamp"`
+}
+
+// HasFault reports whether the work has a fault with the code that is at
+// least as severe as minSeverity; an empty minSeverity matches any.
+func (w Work) HasFault(code string, minSeverity FaultSeverity) bool {
+	if w.Analysis == nil {
+		return false
+	}
+	for _, fault := range w.Analysis.Faults {
+		if fault.Code != code {
+			continue
+		}
+		if minSeverity == "" || fault.Severity.Rank() >= minSeverity.Rank() {
+			return true
+		}
+	}
+	return false
+}
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is a video analysis request. The id of an upload's job is the id of the
+// LINE video message so the upload can be downloaded again if the job is
+// resumed after a restart.
+// A running job belongs to the instance named by Owner until LeaseUntil, see
+// ClaimJob.
+type Job struct {
+	Id     string `json:"id"`
+	UserId string `json:"userId"`
+	Skill  string `json:"skill"`
+	// WorkDate is set when the job analyzes the stored video of that work
+	// again instead of a LINE upload
+	WorkDate   string    `json:"workDate,omitempty"`
+	Status     JobStatus `json:"status"`
+	Error      string    `json:"error"`
+	Owner      string    `json:"owner"`
+	LeaseUntil time.Time `json:"leaseUntil"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Reanalysis reports whether the job was started by staff on a stored work.
+// Its student is not notified.
+func (job *Job) Reanalysis() bool {
+	return job.WorkDate != ""
+}
+
+// claimable reports whether owner may run the job at now: it is queued, or
+// its lease is owner's or has expired.
+func (job *Job) claimable(owner string, now time.Time) bool {
+	switch job.Status {
+	case JobQueued:
+		return true
+	case JobRunning:
+		return job.Owner == owner || job.LeaseUntil.Before(now)
+	}
+	return false
 }
 
 type Handedness int8
@@ -83,10 +355,6 @@ func (h Handedness) String() string {
 	return [...]string{"left", "right"}[h]
 }
 
-func (h Handedness) ChnString() string {
-	return [...]string{"左手", "右手"}[h]
-}
-
 func HandednessStrToEnum(str string) (Handedness, error) {
 	switch str {
 	case "left":
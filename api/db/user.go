@@ -1,29 +1,83 @@
 package db
 
 import (
+	"cloud.google.com/go/firestore"
 	drive "github.com/HeavenAQ/api/drive"
-	googleDrive "google.golang.org/api/drive/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func (handler *FirebaseHandler) CreateUserData(userFolders *drive.UserFolders) (*UserData, error) {
-	ref := handler.GetUsersCollection().Doc(userFolders.UserId)
-	newUserTemplate := &UserData{
+func newUserTemplate(userFolders *drive.UserFolders) *UserData {
+	user := &UserData{
 		Name:       userFolders.UserName,
 		TestNumber: -1,
 		Id:         userFolders.UserId,
 		Handedness: Right,
 		FolderIds: FolderIds{
-			Root:  userFolders.RootFolderId,
-			Serve: userFolders.ServeFolderId,
-			Smash: userFolders.SmashFolderId,
-			Clear: userFolders.ClearFolderId,
+			Root:   userFolders.RootFolderId,
+			Skills: map[string]string{},
 		},
 		Portfolio: Portfolio{
-			Serve: map[string]Work{},
-			Smash: map[string]Work{},
-			Clear: map[string]Work{},
+			Skills: map[string]map[string]Work{},
 		},
 	}
+	for skill, folderId := range userFolders.SkillFolderIds {
+		user.FolderIds.Skills[skill] = folderId
+		user.Portfolio.Skills[skill] = map[string]Work{}
+	}
+	return user
+}
+
+func newPortfolioWork(videoFile *drive.UploadedFile, thumbnailFile *drive.UploadedFile, originalFile *drive.UploadedFile, aiRating float32, aiSuggestions string, analysis *WorkAnalysis) Work {
+	return Work{
+		DateTime:      videoFile.Name,
+		Rating:        aiRating,
+		Reflection:    DefaultReflection,
+		PreviewNote:   DefaultPreviewNote,
+		AINote:        aiSuggestions,
+		SkeletonVideo: videoFile.Id,
+		Thumbnail:     thumbnailFile.Id,
+		OriginalVideo: originalFile.Id,
+		Analysis:      analysis,
+	}
+}
+
+// putPortfolioVideo adds the analyzed video of a source message to the
+// portfolio. A message that was analyzed before replaces its earlier work,
+// keeping its date and the student's notes, so redelivered or retried uploads
+// never create a second work.
+func putPortfolioVideo(userPortfolio *map[string]Work, sourceMessageId string, videoFile *drive.UploadedFile, thumbnailFile *drive.UploadedFile, originalFile *drive.UploadedFile, aiRating float32, aiSuggestions string, analysis *WorkAnalysis) Work {
+	work := newPortfolioWork(videoFile, thumbnailFile, originalFile, aiRating, aiSuggestions, analysis)
+	work.SourceMessageId = sourceMessageId
+	if sourceMessageId != "" {
+		for date, existing := range *userPortfolio {
+			if existing.SourceMessageId == sourceMessageId {
+				work.DateTime = date
+				work.Reflection = existing.Reflection
+				work.PreviewNote = existing.PreviewNote
+				break
+			}
+		}
+	}
+	(*userPortfolio)[work.DateTime] = work
+	return work
+}
+
+func setWorkReflection(userPortfolio *map[string]Work, date string, reflection string) {
+	work := (*userPortfolio)[date]
+	work.Reflection = reflection
+	(*userPortfolio)[date] = work
+}
+
+func setWorkPreviewNote(userPortfolio *map[string]Work, date string, previewNote string) {
+	work := (*userPortfolio)[date]
+	work.PreviewNote = previewNote
+	(*userPortfolio)[date] = work
+}
+
+func (handler *FirebaseHandler) CreateUserData(userFolders *drive.UserFolders) (*UserData, error) {
+	ref := handler.GetUsersCollection().Doc(userFolders.UserId)
+	newUserTemplate := newUserTemplate(userFolders)
 
 	_, err := ref.Set(handler.ctx, newUserTemplate)
 	if err != nil {
@@ -34,11 +88,22 @@ func (handler *FirebaseHandler) CreateUserData(userFolders *drive.UserFolders) (
 
 func (handler *FirebaseHandler) GetUserData(userId string) (*UserData, error) {
 	docsnap, err := handler.GetUsersCollection().Doc(userId).Get(handler.ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	user := &UserData{}
 	docsnap.DataTo(user)
+
+	// move users created with the fixed serve/smash/clear fields to the
+	// per-skill layout the first time they are read
+	if user.MigrateLegacySkills() {
+		if err := handler.updateUserData(user); err != nil {
+			return nil, err
+		}
+	}
 	return user, nil
 }
 
@@ -55,64 +120,81 @@ func (handler *FirebaseHandler) UpdateUserHandedness(user *UserData, handedness
 	return handler.updateUserData(user)
 }
 
-func (handler *FirebaseHandler) UpdateUserTestNumber(user *UserData, testNumber int) error {
-	user.TestNumber = testNumber
+func (handler *FirebaseHandler) UpdateUserSkillFolder(user *UserData, skill string, folderId string) error {
+	user.FolderIds.Skills[skill] = folderId
 	return handler.updateUserData(user)
 }
 
-func (handler *FirebaseHandler) CreateUserPortfolioVideo(user *UserData, userPortfolio *map[string]Work, session *UserSession, driveFile *googleDrive.File, thumbnailFile *googleDrive.File, aiRating float32, aiSuggestions string) error {
-	id := driveFile.Id
-	date := driveFile.Name
-	work := Work{
-		DateTime:      date,
-		Rating:        aiRating,
-		Reflection:    "尚未填寫心得",
-		PreviewNote:   "尚未填寫課前檢視要點",
-		AINote:        aiSuggestions,
-		SkeletonVideo: id,
-		Thumbnail:     thumbnailFile.Id,
-	}
-	(*userPortfolio)[date] = work
-	handler.UpdateUserSession(user.Id, *session)
+func (handler *FirebaseHandler) UpdateUserRole(user *UserData, role Role) error {
+	user.Role = role
 	return handler.updateUserData(user)
 }
 
-func (handler *FirebaseHandler) UpdateUserPortfolioReflection(user *UserData, userPortfolio *map[string]Work, session *UserSession, reflection string) error {
-	targetWork := (*userPortfolio)[session.UpdatingDate]
-	work := Work{
-		DateTime:      targetWork.DateTime,
-		Rating:        targetWork.Rating,
-		Reflection:    reflection,
-		PreviewNote:   targetWork.PreviewNote,
-		SkeletonVideo: targetWork.SkeletonVideo,
-		Thumbnail:     targetWork.Thumbnail,
-		AINote:        targetWork.AINote,
+func (handler *FirebaseHandler) UpdateUserClassCode(user *UserData, classCode string) error {
+	user.ClassCode = classCode
+	return handler.updateUserData(user)
+}
+
+func (handler *FirebaseHandler) UpdateUserReminderOptOut(user *UserData, optOut bool) error {
+	user.ReminderOptOut = optOut
+	return handler.updateUserData(user)
+}
+
+func (handler *FirebaseHandler) UpdateUserLanguage(user *UserData, language string) error {
+	user.Language = language
+	return handler.updateUserData(user)
+}
+
+func (handler *FirebaseHandler) GetUsersByClass(classCode string) ([]*UserData, error) {
+	docs, err := handler.GetUsersCollection().Where("ClassCode", "==", classCode).Documents(handler.ctx).GetAll()
+	if err != nil {
+		return nil, err
 	}
-	(*userPortfolio)[session.UpdatingDate] = work
+	return usersFromDocs(docs)
+}
 
-	err := handler.UpdateUserSession(user.Id, *session)
+func (handler *FirebaseHandler) ListUsers() ([]*UserData, error) {
+	docs, err := handler.GetUsersCollection().Documents(handler.ctx).GetAll()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return usersFromDocs(docs)
+}
+
+func usersFromDocs(docs []*firestore.DocumentSnapshot) ([]*UserData, error) {
+	users := []*UserData{}
+	for _, doc := range docs {
+		user := &UserData{}
+		if err := doc.DataTo(user); err != nil {
+			return nil, err
+		}
+		user.MigrateLegacySkills()
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (handler *FirebaseHandler) UpdateUserTestNumber(user *UserData, testNumber int) error {
+	user.TestNumber = testNumber
+	return handler.updateUserData(user)
+}
+
+func (handler *FirebaseHandler) CreateUserPortfolioVideo(user *UserData, userPortfolio *map[string]Work, skill string, sourceMessageId string, videoFile *drive.UploadedFile, thumbnailFile *drive.UploadedFile, originalFile *drive.UploadedFile, aiRating float32, aiSuggestions string, analysis *WorkAnalysis) error {
+	putPortfolioVideo(userPortfolio, sourceMessageId, videoFile, thumbnailFile, originalFile, aiRating, aiSuggestions, analysis)
+	return handler.updateUserData(user)
+}
+
+func (handler *FirebaseHandler) UpdateUserPortfolioReflection(user *UserData, userPortfolio *map[string]Work, session *UserSession, reflection string) error {
+	setWorkReflection(userPortfolio, session.UpdatingDate, reflection)
 	return handler.updateUserData(user)
 }
 
 func (handler *FirebaseHandler) UpdateUserPortfolioPreviewNote(user *UserData, userPortfolio *map[string]Work, session *UserSession, previewNote string) error {
-	targetWork := (*userPortfolio)[session.UpdatingDate]
-	work := Work{
-		DateTime:      targetWork.DateTime,
-		Reflection:    targetWork.Reflection,
-		Rating:        targetWork.Rating,
-		AINote:        targetWork.AINote,
-		PreviewNote:   previewNote,
-		SkeletonVideo: targetWork.SkeletonVideo,
-		Thumbnail:     targetWork.Thumbnail,
-	}
-	(*userPortfolio)[session.UpdatingDate] = work
+	setWorkPreviewNote(userPortfolio, session.UpdatingDate, previewNote)
+	return handler.updateUserData(user)
+}
 
-	err := handler.UpdateUserSession(user.Id, *session)
-	if err != nil {
-		return err
-	}
+func (handler *FirebaseHandler) UpdateUserPortfolioWork(user *UserData, userPortfolio *map[string]Work, skill string, work Work) error {
+	(*userPortfolio)[work.DateTime] = work
 	return handler.updateUserData(user)
 }
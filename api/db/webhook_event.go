@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WebhookEvent records a processed LINE webhook event so redeliveries can be
+// skipped. Configure ExpiresAt as the TTL field of the collection to have
+// Firestore delete old records.
+type WebhookEvent struct {
+	Id        string
+	ExpiresAt time.Time
+}
+
+func (handler *FirebaseHandler) GetWebhookEventsCollection() *firestore.CollectionRef {
+	return handler.dbClient.Collection(handler.collections.WebhookEvents)
+}
+
+// ClaimWebhookEvent records the event until ttl from now and reports whether
+// it was new. Events whose record has expired are claimed again.
+func (handler *FirebaseHandler) ClaimWebhookEvent(eventId string, ttl time.Duration) (bool, error) {
+	ref := handler.GetWebhookEventsCollection().Doc(eventId)
+	claimed := false
+	err := handler.dbClient.RunTransaction(handler.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now()
+		doc, err := tx.Get(ref)
+		if err == nil {
+			var event WebhookEvent
+			if err := doc.DataTo(&event); err != nil {
+				return err
+			}
+			if event.ExpiresAt.After(now) {
+				claimed = false
+				return nil
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		claimed = true
+		return tx.Set(ref, WebhookEvent{Id: eventId, ExpiresAt: now.Add(ttl)})
+	})
+	if err != nil {
+		return false, err
+	}
+	return claimed, nil
+}
+
+// CompleteWebhookEvent keeps the record of a handled event until ttl from now.
+func (handler *FirebaseHandler) CompleteWebhookEvent(eventId string, ttl time.Duration) error {
+	_, err := handler.GetWebhookEventsCollection().Doc(eventId).Set(handler.ctx, WebhookEvent{
+		Id:        eventId,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	return err
+}
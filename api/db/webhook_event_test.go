@@ -0,0 +1,87 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaimWebhookEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup records the event "event" as the webhook handler would
+		setup func(store Store) error
+		want  bool
+	}{
+		{
+			name: "new event",
+			want: true,
+		},
+		{
+			name: "being handled",
+			setup: func(store Store) error {
+				_, err := store.ClaimWebhookEvent("event", time.Minute)
+				return err
+			},
+		},
+		{
+			name: "claim expired before it was handled",
+			setup: func(store Store) error {
+				_, err := store.ClaimWebhookEvent("event", -time.Second)
+				return err
+			},
+			want: true,
+		},
+		{
+			name: "handled",
+			setup: func(store Store) error {
+				if _, err := store.ClaimWebhookEvent("event", -time.Second); err != nil {
+					return err
+				}
+				return store.CompleteWebhookEvent("event", time.Hour)
+			},
+		},
+		{
+			name: "handled before the dedup ttl",
+			setup: func(store Store) error {
+				if _, err := store.ClaimWebhookEvent("event", time.Minute); err != nil {
+					return err
+				}
+				return store.CompleteWebhookEvent("event", -time.Second)
+			},
+			want: true,
+		},
+		{
+			name: "other event handled",
+			setup: func(store Store) error {
+				if _, err := store.ClaimWebhookEvent("other", time.Minute); err != nil {
+					return err
+				}
+				return store.CompleteWebhookEvent("other", time.Hour)
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		for name, store := range testStores(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				if tt.setup != nil {
+					if err := tt.setup(store); err != nil {
+						t.Fatal(err)
+					}
+				}
+				claimed, err := store.ClaimWebhookEvent("event", time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claimed != tt.want {
+					t.Errorf("claimed = %v, want %v", claimed, tt.want)
+				}
+				// a claim holds off every other delivery
+				if again, err := store.ClaimWebhookEvent("event", time.Minute); err != nil || again {
+					t.Errorf("claimed again = %v, %v", again, err)
+				}
+			})
+		}
+	}
+}
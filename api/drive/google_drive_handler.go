@@ -1,22 +1,34 @@
 package drive
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"os"
 	"time"
 
 	"github.com/HeavenAQ/api/secret"
+	"github.com/HeavenAQ/resilience"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
-func NewGoogleDriveHandler() (*GoogleDriveHandler, error) {
+// uploadChunkSize bounds the memory used by an upload; larger files are sent
+// as a resumable upload of several chunks
+const uploadChunkSize = 8 * 1024 * 1024
+
+// NewGoogleDriveHandler connects to Drive with the service account stored in
+// the secret credentials. User folders are created under rootFolderId and
+// thumbnails are kept in thumbnailFolderId. Uploads are named after their
+// time in loc.
+func NewGoogleDriveHandler(secrets secret.Provider, credentials string, rootFolderId string, thumbnailFolderId string, loc *time.Location) (*GoogleDriveHandler, error) {
 	ctx := context.Background()
 
-	// get google credentials from secret manager
-	secretName := secret.GetSecretNameString(os.Getenv("GOOGLE_DRIVE_CREDENTIALS"))
-	googleDriveCredentials, err := secret.AccessSecretVersion(secretName)
+	// get google credentials from the secret provider
+	googleDriveCredentials, err := secrets.Get(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
 
 	// init google drive service
 	srv, err := drive.NewService(ctx, option.WithCredentialsJSON(googleDriveCredentials))
@@ -25,77 +37,131 @@ func NewGoogleDriveHandler() (*GoogleDriveHandler, error) {
 	}
 
 	return &GoogleDriveHandler{
-		srv, os.Getenv("GOOGLE_DRIVE_ROOT_FOLDER_ID"),
+		srv, rootFolderId, thumbnailFolderId, loc,
 	}, nil
 }
 
-func (handler *GoogleDriveHandler) CreateUserFolders(userId string, userName string) (*UserFolders, error) {
-	folderNames := []string{
-		userId,
-		"serve",
-		"smash",
-		"clear",
+// Ping reads the root folder, which needs both the credentials and access
+// to the folder.
+func (handler *GoogleDriveHandler) Ping(ctx context.Context) error {
+	_, err := handler.srv.Files.Get(handler.RootFolderID).Fields("id").Context(ctx).Do()
+	return err
+}
+
+// driveError marks rate limits and server errors as retryable.
+func driveError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && resilience.IsTransientStatus(apiErr.Code) {
+		return resilience.Retryable(err, resilience.ParseRetryAfter(apiErr.Header.Get("Retry-After"), time.Now()))
 	}
+	return err
+}
 
-	userFolders := UserFolders{
-		UserId:   userId,
-		UserName: userName,
+func (handler *GoogleDriveHandler) createFolder(name string, parentId string) (string, error) {
+	folder, err := handler.srv.Files.Create(&drive.File{
+		Name:     name,
+		MimeType: "application/vnd.google-apps.folder",
+		Parents:  []string{parentId},
+	}).Do()
+	if err != nil {
+		return "", driveError(err)
 	}
+	return folder.Id, nil
+}
 
-	for _, folderName := range folderNames {
-		var parents []string
-		if folderName == userId {
-			parents = []string{handler.RootFolderID}
-		} else {
-			parents = []string{userFolders.RootFolderId}
-		}
+func (handler *GoogleDriveHandler) CreateUserFolders(userId string, userName string, skills []string) (*UserFolders, error) {
+	rootFolderId, err := handler.createFolder(userId, handler.RootFolderID)
+	if err != nil {
+		return nil, err
+	}
 
-		folder, err := handler.srv.Files.Create(&drive.File{
-			Name:     folderName,
-			MimeType: "application/vnd.google-apps.folder",
-			Parents:  parents,
-		}).Do()
+	userFolders := UserFolders{
+		UserId:         userId,
+		UserName:       userName,
+		RootFolderId:   rootFolderId,
+		SkillFolderIds: map[string]string{},
+	}
+	for _, skill := range skills {
+		folderId, err := handler.CreateSkillFolder(rootFolderId, skill)
 		if err != nil {
 			return nil, err
 		}
-
-		switch folderName {
-		case userId:
-			userFolders.RootFolderId = folder.Id
-		case "serve":
-			userFolders.ServeFolderId = folder.Id
-		case "smash":
-			userFolders.SmashFolderId = folder.Id
-		case "clear":
-			userFolders.ClearFolderId = folder.Id
-		}
+		userFolders.SkillFolderIds[skill] = folderId
 	}
-
 	return &userFolders, nil
 }
 
-func (handler *GoogleDriveHandler) UploadVideo(folderId string, videoBlob []byte, thumbnailPath string) (*drive.File, *drive.File, error) {
-	filename := time.Now().Format("2006-01-02-15-04")
+// CreateSkillFolder adds the folder of a skill to an existing user, which is
+// needed when a skill is added to the registry after the user signed up.
+func (handler *GoogleDriveHandler) CreateSkillFolder(rootFolderId string, skill string) (string, error) {
+	return handler.createFolder(skill, rootFolderId)
+}
+
+// upload sends the file at path in chunks, so at most uploadChunkSize of it
+// is buffered.
+func (handler *GoogleDriveHandler) upload(name string, parentId string, path string, contentType string) (*UploadedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-	// upload video file to google drive
-	blob := bytes.NewReader(videoBlob)
 	driveFile, err := handler.srv.Files.Create(&drive.File{
-		Name:    filename,
-		Parents: []string{folderId},
-	}).Media(blob).Do()
+		Name:    name,
+		Parents: []string{parentId},
+	}).Media(
+		file,
+		googleapi.ContentType(contentType),
+		googleapi.ChunkSize(uploadChunkSize),
+	).Do()
 	if err != nil {
-		return nil, nil, err
+		return nil, driveError(err)
 	}
+	return &UploadedFile{driveFile.Id, driveFile.Name}, nil
+}
 
-	// upload video thumbnail to google drive
-	thumbnailData, err := os.ReadFile(thumbnailPath)
-	thumbnailFile, err := handler.srv.Files.Create(&drive.File{
-		Name:    filename + "_thumbnail",
-		Parents: []string{os.Getenv("GOOGLE_DRIVE_THUMBNAIL_FOLDER_ID")},
-	}).Media(bytes.NewReader(thumbnailData)).Do()
+func (handler *GoogleDriveHandler) UploadVideo(folderId string, videoPath string) (*UploadedFile, error) {
+	filename := time.Now().In(handler.Location).Format("2006-01-02-15-04")
+	return handler.upload(filename, folderId, videoPath, "video/mp4")
+}
+
+func (handler *GoogleDriveHandler) UploadThumbnail(video *UploadedFile, thumbnailPath string) (*UploadedFile, error) {
+	return handler.upload(
+		video.Name+"_thumbnail",
+		handler.ThumbnailFolderID,
+		thumbnailPath,
+		"image/jpeg",
+	)
+}
+
+func (handler *GoogleDriveHandler) UploadOriginal(folderId string, video *UploadedFile, videoPath string) (*UploadedFile, error) {
+	return handler.upload(video.Name+"_original", folderId, videoPath, "video/mp4")
+}
+
+func (handler *GoogleDriveHandler) DeleteFile(id string) error {
+	if err := handler.srv.Files.Delete(id).Do(); err != nil {
+		return driveError(err)
+	}
+	return nil
+}
+
+func (handler *GoogleDriveHandler) DownloadVideo(id string, dstPath string) error {
+	resp, err := handler.srv.Files.Get(id).Download()
 	if err != nil {
-		return nil, nil, err
+		return driveError(err)
 	}
+	defer resp.Body.Close()
+	return writeTo(dstPath, resp.Body)
+}
+
+func (handler *GoogleDriveHandler) VideoURL(id string) string {
+	return "https://drive.google.com/uc?export=download&id=" + id
+}
+
+func (handler *GoogleDriveHandler) ThumbnailURL(id string) string {
+	return "https://drive.usercontent.google.com/download?id=" + id
+}
 
-	return driveFile, thumbnailFile, nil
+func (handler *GoogleDriveHandler) FolderURL(id string) string {
+	return "https://drive.google.com/drive/u/0/folders/" + id
 }
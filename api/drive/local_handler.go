@@ -0,0 +1,239 @@
+package drive
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LocalMediaPath is the URL prefix under which the local storage is
+	// served by the bot's HTTP server.
+	LocalMediaPath = "/media/"
+	// LINE fetches videos and thumbnails when they are opened from the chat
+	// history, so links stay valid as long as chart links
+	mediaURLTTL = 30 * 24 * time.Hour
+)
+
+// NewLocalStorageHandler stores videos under rootDir and builds links relative
+// to baseURL, the public https origin of this server, signed with signingKey.
+// Uploads are named after their time in loc.
+func NewLocalStorageHandler(rootDir string, baseURL string, signingKey string, loc *time.Location) (*LocalStorageHandler, error) {
+	if rootDir == "" {
+		return nil, errors.New("local storage directory is not set")
+	}
+	if baseURL == "" {
+		return nil, errors.New("public base url is not set")
+	}
+	if signingKey == "" {
+		return nil, errors.New("media signing key is not set")
+	}
+	if err := os.MkdirAll(rootDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorageHandler{
+		RootDir:    rootDir,
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		SigningKey: signingKey,
+		Location:   loc,
+	}, nil
+}
+
+// Ping checks that the storage directory is still there and writable.
+func (handler *LocalStorageHandler) Ping(ctx context.Context) error {
+	file, err := os.CreateTemp(handler.RootDir, ".ping-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// file ids are slash separated paths relative to the root directory
+func (handler *LocalStorageHandler) localPath(id string) string {
+	return filepath.Join(handler.RootDir, filepath.FromSlash(id))
+}
+
+func (handler *LocalStorageHandler) CreateUserFolders(userId string, userName string, skills []string) (*UserFolders, error) {
+	userFolders := UserFolders{
+		UserId:         userId,
+		UserName:       userName,
+		RootFolderId:   userId,
+		SkillFolderIds: map[string]string{},
+	}
+
+	for _, skill := range skills {
+		folderId, err := handler.CreateSkillFolder(userId, skill)
+		if err != nil {
+			return nil, err
+		}
+		userFolders.SkillFolderIds[skill] = folderId
+	}
+	return &userFolders, nil
+}
+
+func (handler *LocalStorageHandler) CreateSkillFolder(rootFolderId string, skill string) (string, error) {
+	folderId := path.Join(rootFolderId, skill)
+	if err := os.MkdirAll(handler.localPath(folderId), 0o755); err != nil {
+		return "", err
+	}
+	return folderId, nil
+}
+
+func (handler *LocalStorageHandler) writeFile(id string, src io.Reader) error {
+	dst := handler.localPath(id)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return writeTo(dst, src)
+}
+
+// writeTo streams src to a new file at dst, removed again when the copy
+// fails.
+func writeTo(dst string, src io.Reader) error {
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, src); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func (handler *LocalStorageHandler) UploadVideo(folderId string, videoPath string) (*UploadedFile, error) {
+	video, err := os.Open(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer video.Close()
+
+	filename := time.Now().In(handler.Location).Format("2006-01-02-15-04")
+	// the token keeps uploads of the same minute from overwriting each other
+	token := make([]byte, 4)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	id := path.Join(folderId, filename+"_"+hex.EncodeToString(token)+".mp4")
+	if err := handler.writeFile(id, video); err != nil {
+		return nil, err
+	}
+	return &UploadedFile{id, filename}, nil
+}
+
+func (handler *LocalStorageHandler) UploadThumbnail(video *UploadedFile, thumbnailPath string) (*UploadedFile, error) {
+	thumbnail, err := os.Open(thumbnailPath)
+	if err != nil {
+		return nil, err
+	}
+	defer thumbnail.Close()
+
+	// keep the thumbnail next to its video
+	id := strings.TrimSuffix(video.Id, path.Ext(video.Id)) + "_thumbnail.jpeg"
+	if err := handler.writeFile(id, thumbnail); err != nil {
+		return nil, err
+	}
+	return &UploadedFile{id, video.Name + "_thumbnail"}, nil
+}
+
+func (handler *LocalStorageHandler) UploadOriginal(folderId string, video *UploadedFile, videoPath string) (*UploadedFile, error) {
+	original, err := os.Open(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer original.Close()
+
+	id := path.Join(folderId, strings.TrimSuffix(path.Base(video.Id), path.Ext(video.Id))+"_original.mp4")
+	if err := handler.writeFile(id, original); err != nil {
+		return nil, err
+	}
+	return &UploadedFile{id, video.Name + "_original"}, nil
+}
+
+func (handler *LocalStorageHandler) DeleteFile(id string) error {
+	return os.Remove(handler.localPath(path.Clean("/" + id)))
+}
+
+func (handler *LocalStorageHandler) DownloadVideo(id string, dstPath string) error {
+	video, err := os.Open(handler.localPath(path.Clean("/" + id)))
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+	return writeTo(dstPath, video)
+}
+
+// mediaSignature keeps stored files from being fetched by guessing their
+// paths.
+func mediaSignature(key string, id string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (handler *LocalStorageHandler) mediaURL(id string, now time.Time) string {
+	// rounded to the day so repeated requests share the same url
+	expires := now.Add(mediaURLTTL).Truncate(24 * time.Hour).Unix()
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	query.Set("sig", mediaSignature(handler.SigningKey, id, expires))
+	return handler.BaseURL + LocalMediaPath + id + "?" + query.Encode()
+}
+
+func (handler *LocalStorageHandler) VideoURL(id string) string {
+	return handler.mediaURL(id, time.Now())
+}
+
+func (handler *LocalStorageHandler) ThumbnailURL(id string) string {
+	return handler.mediaURL(id, time.Now())
+}
+
+// FolderURL is empty since folders are not browsable over HTTP.
+func (handler *LocalStorageHandler) FolderURL(id string) string {
+	return ""
+}
+
+// ServeHTTP serves stored files under LocalMediaPath to links signed by
+// VideoURL and ThumbnailURL. Directory listings are refused so that one
+// student cannot enumerate the others' videos.
+func (handler *LocalStorageHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, LocalMediaPath)
+	if id == "" || strings.HasSuffix(id, "/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	query := req.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "link expired", http.StatusForbidden)
+		return
+	}
+	expected := mediaSignature(handler.SigningKey, id, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("sig"))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	info, err := os.Stat(handler.localPath(path.Clean("/" + id)))
+	if err != nil || info.IsDir() {
+		http.NotFound(w, req)
+		return
+	}
+	http.StripPrefix(strings.TrimSuffix(LocalMediaPath, "/"), http.FileServer(http.Dir(handler.RootDir))).ServeHTTP(w, req)
+}
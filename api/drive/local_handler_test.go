@@ -0,0 +1,116 @@
+package drive
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) *LocalStorageHandler {
+	t.Helper()
+	handler, err := NewLocalStorageHandler(t.TempDir(), "https://bot.example.com/", "media-key", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func writeTemp(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUploadsOfTheSameMinute(t *testing.T) {
+	handler := newTestStorage(t)
+	first, err := handler.UploadVideo("U1234/serve", writeTemp(t, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := handler.UploadVideo("U1234/serve", writeTemp(t, "second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Id == second.Id {
+		t.Fatalf("both uploads stored as %s", first.Id)
+	}
+	// the name stays the date key of the portfolio
+	if _, err := time.Parse("2006-01-02-15-04", first.Name); err != nil {
+		t.Errorf("name %q is not the upload minute", first.Name)
+	}
+
+	for id, want := range map[string]string{first.Id: "first", second.Id: "second"} {
+		dst := filepath.Join(t.TempDir(), "download.mp4")
+		if err := handler.DownloadVideo(id, dst); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(dst); string(got) != want {
+			t.Errorf("video %s holds %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestUploadNamedInLocation(t *testing.T) {
+	taipei, err := time.LoadLocation("Asia/Taipei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newTestStorage(t)
+	handler.Location = taipei
+	video, err := handler.UploadVideo("U1234/serve", writeTemp(t, "video"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	date, err := time.ParseInLocation("2006-01-02-15-04", video.Name, taipei)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since := time.Since(date); since < 0 || since > 2*time.Minute {
+		t.Errorf("name %s is %v from now in %v", video.Name, since, taipei)
+	}
+}
+
+func TestServeSignedMedia(t *testing.T) {
+	handler := newTestStorage(t)
+	video, err := handler.UploadVideo("U1234/serve", writeTemp(t, "video"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := handler.VideoURL(video.Id)
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"signed", signed, http.StatusOK},
+		{"unsigned", handler.BaseURL + LocalMediaPath + video.Id, http.StatusForbidden},
+		{"other file", strings.Replace(signed, video.Id, "U5678/serve/"+filepath.Base(video.Id), 1), http.StatusForbidden},
+		{"tampered signature", strings.Replace(signed, "sig=", "sig=0", 1), http.StatusForbidden},
+		{"expired", handler.mediaURL(video.Id, time.Now().Add(-2*mediaURLTTL)), http.StatusForbidden},
+		{"folder", strings.Replace(signed, video.Id, "U1234/serve/", 1), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusOK {
+				if body, _ := io.ReadAll(rec.Body); string(body) != "video" {
+					t.Errorf("body %q, want the video", body)
+				}
+			}
+		})
+	}
+}
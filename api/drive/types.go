@@ -1,19 +1,70 @@
 package drive
 
 import (
+	"time"
+
 	"google.golang.org/api/drive/v3"
 )
 
+// URLBuilder turns the ids returned by a Storage into links that LINE can
+// fetch. FolderURL returns an empty string when the backend has no browsable
+// folders.
+type URLBuilder interface {
+	VideoURL(id string) string
+	ThumbnailURL(id string) string
+	FolderURL(id string) string
+}
+
+// Storage is where analyzed videos and their thumbnails are kept. Files are
+// streamed from disk so a long video is never held in memory.
+type Storage interface {
+	URLBuilder
+	CreateUserFolders(userId string, userName string, skills []string) (*UserFolders, error)
+	CreateSkillFolder(rootFolderId string, skill string) (string, error)
+	UploadVideo(folderId string, videoPath string) (*UploadedFile, error)
+	UploadThumbnail(video *UploadedFile, thumbnailPath string) (*UploadedFile, error)
+	// UploadOriginal keeps the video as the student uploaded it, named after
+	// its analyzed video, so it can be analyzed again.
+	UploadOriginal(folderId string, video *UploadedFile, videoPath string) (*UploadedFile, error)
+	// DownloadVideo writes the stored video id to dstPath.
+	DownloadVideo(id string, dstPath string) error
+	// DeleteFile removes a video or thumbnail that is no longer linked.
+	DeleteFile(id string) error
+}
+
+var (
+	_ Storage = (*GoogleDriveHandler)(nil)
+	_ Storage = (*LocalStorageHandler)(nil)
+)
+
 type GoogleDriveHandler struct {
-	srv          *drive.Service
-	RootFolderID string
+	srv               *drive.Service
+	RootFolderID      string
+	ThumbnailFolderID string
+	// Location is the zone upload names are stamped in
+	Location *time.Location
+}
+
+type LocalStorageHandler struct {
+	RootDir    string
+	BaseURL    string
+	SigningKey string
+	// Location is the zone upload names are stamped in
+	Location *time.Location
 }
 
 type UserFolders struct {
-	UserId        string
-	UserName      string
-	RootFolderId  string
-	ServeFolderId string
-	SmashFolderId string
-	ClearFolderId string
+	UserId       string
+	UserName     string
+	RootFolderId string
+	// SkillFolderIds maps a skill id to its folder
+	SkillFolderIds map[string]string
+}
+
+// UploadedFile identifies a stored file independently of the backend. Name is
+// the upload time in the handler's Location formatted as 2006-01-02-15-04 and doubles as the portfolio
+// date key.
+type UploadedFile struct {
+	Id   string
+	Name string
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/HeavenAQ/api/db"
@@ -45,13 +46,143 @@ func (handler *LineBotHandler) gePortfolioRating(work db.Work) *linebot.BoxCompo
 	}
 }
 
-func (handler *LineBotHandler) getCarouselItem(work db.Work, userState db.UserState) *linebot.BubbleContainer {
+// getBubbleSection is a titled block of text in a portfolio bubble.
+func getBubbleSection(title string, text string) *linebot.BoxComponent {
+	return &linebot.BoxComponent{
+		Type:    "box",
+		Layout:  "vertical",
+		Margin:  "lg",
+		Spacing: "sm",
+		Contents: []linebot.FlexComponent{
+			&linebot.BoxComponent{
+				Type:    "box",
+				Layout:  "vertical",
+				Spacing: "sm",
+				Contents: []linebot.FlexComponent{
+					&linebot.TextComponent{
+						Type:   "text",
+						Text:   title,
+						Color:  "#000000",
+						Size:   "md",
+						Flex:   linebot.IntPtr(1),
+						Weight: "bold",
+					},
+					&linebot.TextComponent{
+						Type:  "text",
+						Text:  text,
+						Wrap:  true,
+						Color: "#666666",
+						Size:  "sm",
+						Flex:  linebot.IntPtr(5),
+					},
+				},
+			},
+		},
+	}
+}
+
+// the structured analysis sections are left out when the server sent nothing
+// for them
+func (handler *LineBotHandler) getPhaseSection(analysis *db.WorkAnalysis) []linebot.FlexComponent {
+	if len(analysis.Phases) == 0 {
+		return nil
+	}
+	lines := []string{}
+	for _, phase := range analysis.Phases {
+		lines = append(lines, handler.T("portfolio.phase", phase.Phase, phase.Score))
+	}
+	return []linebot.FlexComponent{getBubbleSection(handler.T("portfolio.phases"), strings.Join(lines, "\n"))}
+}
+
+func (handler *LineBotHandler) getFaultSection(analysis *db.WorkAnalysis) []linebot.FlexComponent {
+	if len(analysis.Faults) == 0 {
+		return nil
+	}
+	icons := map[db.FaultSeverity]string{db.Major: "🔴", db.Moderate: "🟠", db.Minor: "🟡"}
+	lines := []string{}
+	for _, fault := range analysis.Faults {
+		description := fault.Description
+		if description == "" {
+			description = fault.Code
+		}
+		lines = append(lines, handler.T(
+			"portfolio.fault",
+			icons[fault.Severity],
+			handler.severityLabel(fault.Severity),
+			description,
+			fault.Timestamp,
+		))
+	}
+	return []linebot.FlexComponent{getBubbleSection(handler.T("portfolio.faults"), strings.Join(lines, "\n"))}
+}
+
+// severityLabel treats unknown severities as moderate, like FaultSeverity.Rank.
+func (handler *LineBotHandler) severityLabel(severity db.FaultSeverity) string {
+	switch severity {
+	case db.Major, db.Minor:
+		return handler.T("severity." + string(severity))
+	default:
+		return handler.T("severity." + string(db.Moderate))
+	}
+}
+
+// getJointAngleSection only lists the angles outside the expected range.
+func (handler *LineBotHandler) getJointAngleSection(analysis *db.WorkAnalysis) []linebot.FlexComponent {
+	lines := []string{}
+	for _, angle := range analysis.JointAngles {
+		if angle.InRange() {
+			continue
+		}
+		lines = append(lines, handler.T(
+			"portfolio.joint_angle",
+			angle.Joint,
+			angle.Phase,
+			angle.Angle,
+			angle.Min,
+			angle.Max,
+		))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []linebot.FlexComponent{getBubbleSection(handler.T("portfolio.joint_angles"), strings.Join(lines, "\n"))}
+}
+
+func (handler *LineBotHandler) getKeyframeSection(analysis *db.WorkAnalysis) []linebot.FlexComponent {
+	if len(analysis.Keyframes) == 0 {
+		return nil
+	}
+	frames := []string{}
+	for _, keyframe := range analysis.Keyframes {
+		frames = append(frames, handler.T("portfolio.keyframe", keyframe.Phase, keyframe.Timestamp))
+	}
+	return []linebot.FlexComponent{getBubbleSection(handler.T("portfolio.keyframes"), strings.Join(frames, handler.T("portfolio.keyframe_separator")))}
+}
+
+// getNote shows the placeholder of a note that was not written yet in the
+// handler's language; works store the placeholder in Chinese.
+func (handler *LineBotHandler) getNote(note string, placeholder string, key string) string {
+	if note == "" || note == placeholder {
+		return handler.T(key)
+	}
+	return note
+}
+
+func (handler *LineBotHandler) getCarouselItem(work db.Work, userState db.UserState) (*linebot.BubbleContainer, error) {
 	rating := handler.gePortfolioRating(work)
 	var btnAction linebot.TemplateAction
 	if userState == db.WritingPreviewNote {
-		btnAction = linebot.NewPostbackAction("新增課前動作檢測要點", "type=add_preview_note&date="+work.DateTime, "", "", "openKeyboard", "")
+		data := mustEncodePostback(&DateSelectionPostback{AddPreviewNote, work.DateTime})
+		btnAction = linebot.NewPostbackAction(handler.T("portfolio.add_preview_note"), data, "", "", "openKeyboard", "")
 	} else if userState == db.WritingReflection {
-		btnAction = linebot.NewPostbackAction("新增學習反思", "type=add_reflection&date="+work.DateTime, "", "", "openKeyboard", "")
+		data := mustEncodePostback(&DateSelectionPostback{AddReflection, work.DateTime})
+		btnAction = linebot.NewPostbackAction(handler.T("portfolio.add_reflection"), data, "", "", "openKeyboard", "")
+	}
+
+	// video ids come from the storage backend and may be arbitrarily long
+	videoData, err := EncodePostback(&VideoViewPostback{work.SkeletonVideo, work.Thumbnail})
+	if err != nil {
+		return nil, err
 	}
 
 	footerContents := []linebot.FlexComponent{
@@ -60,8 +191,8 @@ func (handler *LineBotHandler) getCarouselItem(work db.Work, userState db.UserSt
 			Style:  "link",
 			Height: "sm",
 			Action: linebot.NewPostbackAction(
-				"查看影片",
-				"video={\"video_id\": \""+work.SkeletonVideo+"\", \"thumbnail_id\": \""+work.Thumbnail+"\"}",
+				handler.T("portfolio.view_video"),
+				videoData,
 				"",
 				"",
 				"",
@@ -79,120 +210,42 @@ func (handler *LineBotHandler) getCarouselItem(work db.Work, userState db.UserSt
 		})
 	}
 
+	bodyContents := []linebot.FlexComponent{
+		&linebot.TextComponent{
+			Type:   "text",
+			Text:   "🗓️ " + work.DateTime[:10],
+			Weight: "bold",
+			Size:   "xl",
+		},
+		rating,
+	}
+	if work.Analysis != nil {
+		bodyContents = append(bodyContents, handler.getPhaseSection(work.Analysis)...)
+		bodyContents = append(bodyContents, handler.getFaultSection(work.Analysis)...)
+	}
+	bodyContents = append(bodyContents, getBubbleSection(handler.T("portfolio.ai_note"), work.AINote))
+	if work.Analysis != nil {
+		bodyContents = append(bodyContents, handler.getJointAngleSection(work.Analysis)...)
+		bodyContents = append(bodyContents, handler.getKeyframeSection(work.Analysis)...)
+	}
+	bodyContents = append(bodyContents,
+		getBubbleSection(handler.T("portfolio.preview_note"), handler.getNote(work.PreviewNote, db.DefaultPreviewNote, "portfolio.no_preview_note")),
+		getBubbleSection(handler.T("portfolio.reflection"), handler.getNote(work.Reflection, db.DefaultReflection, "portfolio.no_reflection")),
+	)
+
 	return &linebot.BubbleContainer{
 		Type: "bubble",
 		Hero: &linebot.ImageComponent{
 			Type:        "image",
-			URL:         "https://drive.usercontent.google.com/download?id=" + work.Thumbnail,
+			URL:         handler.urls.ThumbnailURL(work.Thumbnail),
 			Size:        "full",
 			AspectRatio: "20:13",
 			AspectMode:  "cover",
 		},
 		Body: &linebot.BoxComponent{
-			Type:   "box",
-			Layout: "vertical",
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{
-					Type:   "text",
-					Text:   "🗓️ " + work.DateTime[:10],
-					Weight: "bold",
-					Size:   "xl",
-				},
-				rating,
-				&linebot.BoxComponent{
-					Type:    "box",
-					Layout:  "vertical",
-					Margin:  "lg",
-					Spacing: "sm",
-					Contents: []linebot.FlexComponent{
-						&linebot.BoxComponent{
-							Type:    "box",
-							Layout:  "vertical",
-							Spacing: "sm",
-							Contents: []linebot.FlexComponent{
-								&linebot.TextComponent{
-									Type:   "text",
-									Text:   "需調整細節：",
-									Color:  "#000000",
-									Size:   "md",
-									Flex:   linebot.IntPtr(1),
-									Weight: "bold",
-								},
-								&linebot.TextComponent{
-									Type:  "text",
-									Text:  work.AINote,
-									Wrap:  true,
-									Color: "#666666",
-									Size:  "sm",
-									Flex:  linebot.IntPtr(5),
-								},
-							},
-						},
-					},
-				},
-				&linebot.BoxComponent{
-					Type:    "box",
-					Layout:  "vertical",
-					Margin:  "lg",
-					Spacing: "sm",
-					Contents: []linebot.FlexComponent{
-						&linebot.BoxComponent{
-							Type:    "box",
-							Layout:  "vertical",
-							Spacing: "sm",
-							Contents: []linebot.FlexComponent{
-								&linebot.TextComponent{
-									Type:   "text",
-									Text:   "課前動作檢測要點：",
-									Color:  "#000000",
-									Size:   "md",
-									Flex:   linebot.IntPtr(1),
-									Weight: "bold",
-								},
-								&linebot.TextComponent{
-									Type:  "text",
-									Text:  work.PreviewNote,
-									Wrap:  true,
-									Color: "#666666",
-									Size:  "sm",
-									Flex:  linebot.IntPtr(5),
-								},
-							},
-						},
-					},
-				},
-				&linebot.BoxComponent{
-					Type:    "box",
-					Layout:  "vertical",
-					Margin:  "lg",
-					Spacing: "sm",
-					Contents: []linebot.FlexComponent{
-						&linebot.BoxComponent{
-							Type:    "box",
-							Layout:  "vertical",
-							Spacing: "sm",
-							Contents: []linebot.FlexComponent{
-								&linebot.TextComponent{
-									Type:   "text",
-									Text:   "學習反思：",
-									Color:  "#000000",
-									Size:   "md",
-									Flex:   linebot.IntPtr(1),
-									Weight: "bold",
-								},
-								&linebot.TextComponent{
-									Type:  "text",
-									Text:  work.Reflection,
-									Wrap:  true,
-									Color: "#666666",
-									Size:  "sm",
-									Flex:  linebot.IntPtr(5),
-								},
-							},
-						},
-					},
-				},
-			},
+			Type:     "box",
+			Layout:   "vertical",
+			Contents: bodyContents,
 		},
 		Footer: &linebot.BoxComponent{
 			Type:     "box",
@@ -200,7 +253,7 @@ func (handler *LineBotHandler) getCarouselItem(work db.Work, userState db.UserSt
 			Spacing:  "sm",
 			Contents: footerContents,
 		},
-	}
+	}, nil
 }
 
 func (handler *LineBotHandler) insertCarousel(carouselItems []*linebot.FlexMessage, items []*linebot.BubbleContainer) []*linebot.FlexMessage {
@@ -234,7 +287,11 @@ func (handler *LineBotHandler) getCarousels(works map[string]db.Work, userState
 	carouselItems := []*linebot.FlexMessage{}
 	sortedWorks := handler.sortWorks(works)
 	for _, work := range sortedWorks {
-		items = append(items, handler.getCarouselItem(work, userState))
+		item, err := handler.getCarouselItem(work, userState)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
 
 		// since the carousel can only contain 10 items, we need to split the works into multiple carousels in order to display all of them
 		if len(items) == 10 {
@@ -261,41 +318,8 @@ func (handler *LineBotHandler) replyViewPortfolioError(event *linebot.Event, msg
 	return nil
 }
 
-func (handler *LineBotHandler) getActionUrls(hand db.Handedness, skill Skill) []string {
-	actionUrls := map[db.Handedness]map[Skill][]string{
-		db.Right: {
-			Serve: []string{
-				"https://youtu.be/uE-EHVX1LrA",
-			},
-			Smash: []string{
-				"https://youtube.com/shorts/Qn3OU7opV5o?feature=share",
-				"https://youtube.com/shorts/_PPfeSJiMgQ?feature=share",
-			},
-			Clear: []string{
-				"https://youtu.be/K7EEhEF2vMo",
-			},
-		},
-		db.Left: {
-			Serve: []string{
-				"https://youtu.be/7i0KvbJ4rEE",
-				"https://youtu.be/LiQWE6i3bbI",
-			},
-			Smash: []string{
-				"https://youtu.be/2vTLZkNyIng",
-				"https://youtu.be/zn58JKpXy34",
-			},
-			Clear: []string{
-				"https://youtu.be/yyjC-xXOsdg",
-				"https://youtu.be/AzF44kouBBQ",
-			},
-		},
-	}
-	return actionUrls[hand][skill]
-}
-
-func (handler *LineBotHandler) GetVideoContent(event *linebot.Event) (*linebot.MessageContentResponse, error) {
-	msg := event.Message.(*linebot.VideoMessage)
-	content, err := handler.bot.GetMessageContent(msg.ID).Do()
+func (handler *LineBotHandler) GetMessageContent(messageId string) (*linebot.MessageContentResponse, error) {
+	content, err := handler.bot.GetMessageContent(messageId).Do()
 	if err != nil {
 		return nil, err
 	}
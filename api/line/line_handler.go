@@ -1,25 +1,57 @@
 package line
 
 import (
+	"context"
 	"net/http"
-	"os"
 
+	"github.com/HeavenAQ/api/drive"
+	"github.com/HeavenAQ/i18n"
+	"github.com/HeavenAQ/skill"
 	"github.com/line/line-bot-sdk-go/v7/linebot"
 )
 
-func NewLineBotHandler() (*LineBotHandler, error) {
-	bot, err := linebot.New(
-		os.Getenv("CHANNEL_SECRET"),
-		os.Getenv("CHANNEL_TOKEN"),
-	)
+// NewLineBotHandler creates the LINE client. urls builds the video and
+// thumbnail links sent to users and must match the storage backend in use,
+// skills provides the strokes offered in quick replies and messages the text
+// of the replies, sent in the default language until In is used. options are
+// passed on to the client, e.g. to point it at a stand-in LINE API.
+func NewLineBotHandler(channelSecret string, channelToken string, urls drive.URLBuilder, skills *skill.Registry, messages *i18n.Catalog, options ...linebot.ClientOption) (*LineBotHandler, error) {
+	bot, err := linebot.New(channelSecret, channelToken, options...)
 	if err != nil {
 		return nil, err
 	}
 	return &LineBotHandler{
 		bot,
+		urls,
+		skills,
+		messages,
+		i18n.DefaultLanguage,
 	}, nil
 }
 
+// Ping fetches the bot info, which fails when the channel token is invalid.
+func (handler *LineBotHandler) Ping(ctx context.Context) error {
+	_, err := handler.bot.GetBotInfo().WithContext(ctx).Do()
+	return err
+}
+
+// In returns a handler sending its replies in lang. The LINE client is
+// shared with the original handler.
+func (handler *LineBotHandler) In(lang string) *LineBotHandler {
+	localized := *handler
+	localized.lang = lang
+	return &localized
+}
+
+func (handler *LineBotHandler) Lang() string {
+	return handler.lang
+}
+
+// T formats a message of the catalog in the handler's language.
+func (handler *LineBotHandler) T(key string, args ...any) string {
+	return handler.messages.T(handler.lang, key, args...)
+}
+
 func (handler *LineBotHandler) RetrieveCbEvent(w http.ResponseWriter, req *http.Request) ([]*linebot.Event, error) {
 	cb, err := handler.bot.ParseRequest(req)
 	if err != nil {
@@ -30,9 +62,15 @@ func (handler *LineBotHandler) RetrieveCbEvent(w http.ResponseWriter, req *http.
 }
 
 func (handler *LineBotHandler) GetUserName(userId string) (string, error) {
-	profile, err := handler.bot.GetProfile(userId).Do()
+	profile, err := handler.GetUserProfile(userId)
 	if err != nil {
 		return "", err
 	}
 	return profile.DisplayName, nil
 }
+
+// GetUserProfile returns the LINE profile of a user, whose Language is the
+// language of the user's LINE app.
+func (handler *LineBotHandler) GetUserProfile(userId string) (*linebot.UserProfileResponse, error) {
+	return handler.bot.GetProfile(userId).Do()
+}
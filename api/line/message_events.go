@@ -1,6 +1,15 @@
 package line
 
 import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/video"
+	"github.com/HeavenAQ/resilience"
+	"github.com/HeavenAQ/skill"
 	"github.com/line/line-bot-sdk-go/v7/linebot"
 )
 
@@ -8,12 +17,84 @@ func (handler *LineBotHandler) SendReply(replyToken string, msg string) (*linebo
 	return handler.bot.ReplyMessage(replyToken, linebot.NewTextMessage(msg)).Do()
 }
 
+func (handler *LineBotHandler) SendPush(userId string, msg string) (*linebot.BasicResponse, error) {
+	return handler.push(userId, linebot.NewTextMessage(msg))
+}
+
+// push marks rate limited pushes as retryable. Other failures are not
+// retried since LINE may have delivered the message already.
+func (handler *LineBotHandler) push(userId string, msgs ...linebot.SendingMessage) (*linebot.BasicResponse, error) {
+	resp, err := handler.bot.PushMessage(userId, msgs...).Do()
+	var apiErr *linebot.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+		return nil, resilience.Retryable(err, 0)
+	}
+	return resp, err
+}
+
+func (handler *LineBotHandler) SendDefaultErrorPush(userId string) (*linebot.BasicResponse, error) {
+	return handler.SendPush(userId, handler.T("analysis.failed"))
+}
+
+// SendAnalysisDelayedPush tells the student the AI server is busy and the
+// upload will be analyzed again later on its own.
+func (handler *LineBotHandler) SendAnalysisDelayedPush(userId string) (*linebot.BasicResponse, error) {
+	return handler.SendPush(userId, handler.T("analysis.delayed"))
+}
+
+func (handler *LineBotHandler) SendAnalysisUnavailablePush(userId string) (*linebot.BasicResponse, error) {
+	return handler.SendPush(userId, handler.T("analysis.unavailable"))
+}
+
+// SendAnalysisInterruptedPush asks the student to upload again a video whose
+// analysis was cut short by a shutdown.
+func (handler *LineBotHandler) SendAnalysisInterruptedPush(userId string) (*linebot.BasicResponse, error) {
+	return handler.SendPush(userId, handler.T("analysis.interrupted"))
+}
+
+// SendVideoRejectedPush tells the student why an upload was not analyzed.
+func (handler *LineBotHandler) SendVideoRejectedPush(userId string, rejected *video.RejectedError) (*linebot.BasicResponse, error) {
+	var reason string
+	switch rejected.Reason {
+	case video.TooShort, video.TooLong, video.TooLarge, video.LowFrameRate:
+		reason = handler.T("rejected."+rejected.Reason.String(), rejected.Actual, rejected.Limit)
+	case video.UnsupportedCodec:
+		reason = handler.T("rejected."+rejected.Reason.String(), rejected.Actual)
+	default:
+		reason = handler.T("rejected." + video.NoVideoStream.String())
+	}
+	return handler.SendPush(userId, handler.T("rejected", reason))
+}
+
+// SendWeeklyReminderPush lists what the student still has to do this week.
+func (handler *LineBotHandler) SendWeeklyReminderPush(user *db.UserData, progress db.WeeklyProgress, now time.Time) (*linebot.BasicResponse, error) {
+	missing := []string{}
+	if progress.Videos == 0 {
+		missing = append(missing, handler.T("reminder.video"))
+	}
+	if progress.Reflections == 0 {
+		missing = append(missing, handler.T("reminder.reflection"))
+	}
+	if progress.PreviewNotes == 0 {
+		missing = append(missing, handler.T("reminder.preview_note"))
+	}
+
+	msg := handler.T(
+		"reminder",
+		user.Name,
+		db.WeekStart(now).Format("01/02"),
+		strings.Join(missing, "\n"),
+		handler.messages.Command(handler.lang, "reminder_off"),
+	)
+	return handler.SendPush(user.Id, msg)
+}
+
 func (handler *LineBotHandler) SendDefaultReply(replyToken string) (*linebot.BasicResponse, error) {
-	return handler.SendReply(replyToken, "請點選選單的項目")
+	return handler.SendReply(replyToken, handler.T("default_reply"))
 }
 
 func (handler *LineBotHandler) SendDefaultErrorReply(replyToken string) (*linebot.BasicResponse, error) {
-	return handler.SendReply(replyToken, "發生錯誤，請重新操作")
+	return handler.SendReply(replyToken, handler.T("error_reply"))
 }
 
 func (handler *LineBotHandler) SendWelcomeReply(event *linebot.Event) (*linebot.BasicResponse, error) {
@@ -21,38 +102,40 @@ func (handler *LineBotHandler) SendWelcomeReply(event *linebot.Event) (*linebot.
 	if err != nil {
 		return nil, err
 	}
-	welcomMsg := "Hi " + username + "! 歡迎加入羽球教室🏸\n" + "已建立您的使用者資料🎉🎊 請於輸入前側編號（2碼）後開始使用"
-	return handler.SendReply(event.ReplyToken, welcomMsg)
+	return handler.SendReply(event.ReplyToken, handler.T("welcome", username))
 }
 
-func (handler *LineBotHandler) SendVideoUploadedReply(replyToken string, skill string, videoFolder string) (*linebot.BasicResponse, error) {
-	s := SkillStrToEnum(skill)
-	skillFolder := "https://drive.google.com/drive/u/0/folders/" + videoFolder
-	return handler.bot.ReplyMessage(
-		replyToken,
-		linebot.NewTextMessage("已成功上傳影片!"),
-		linebot.NewTextMessage("以下為【"+s.ChnString()+"】的影片資料夾：\n"+skillFolder),
-	).Do()
+func (handler *LineBotHandler) SendVideoProcessingReply(replyToken string) (*linebot.BasicResponse, error) {
+	return handler.SendReply(replyToken, handler.T("upload.processing"))
+}
+
+// SendVideoDelayedReply acknowledges an upload while the AI server is known
+// to be down.
+func (handler *LineBotHandler) SendVideoDelayedReply(replyToken string) (*linebot.BasicResponse, error) {
+	return handler.SendReply(replyToken, handler.T("upload.delayed"))
+}
+
+// SendVideoUploadedPush is sent once a queued analysis finishes, long after
+// the reply token of the upload has expired.
+func (handler *LineBotHandler) SendVideoUploadedPush(userId string, s skill.Skill, videoFolder string) (*linebot.BasicResponse, error) {
+	msgs := []linebot.SendingMessage{linebot.NewTextMessage(handler.T("upload.done"))}
+
+	// not every storage backend has a folder the user can browse
+	skillFolder := handler.urls.FolderURL(videoFolder)
+	if skillFolder != "" {
+		msgs = append(msgs, linebot.NewTextMessage(handler.T("upload.folder", s.Label(handler.lang), skillFolder)))
+	}
+	return handler.push(userId, msgs...)
 }
 
 func (handler *LineBotHandler) SendInstruction(replyToken string) (*linebot.BasicResponse, error) {
-	const welcome = "歡迎加入羽球教室🏸，以下為選單的使用說明:\n\n"
-	const instruction = "➡️ 使用說明：呼叫選單各個項目的解說\n\n"
-	const portfolio = "➡️ 學習歷程：查看個人每周的學習歷程記錄\n\n"
-	const expertVideo = "➡️ 專家影片：觀看專家示範影片\n\n"
-	const addPreviewNote = "➡️ 課前動作檢測：課前預習上週動作，並記錄需進步的要點\n\n"
-	const analyzeRecording = "➡️ 分析影片：上傳個人動作錄影，系統將自動產生分析結果\n\n"
-	const addReflection = "➡️ 本週學習反思：新增每周各動作的學習反思\n\n"
-	const note1 = "✅ 如需查看課程大綱，請輸入「課程大綱」\n\n"
-	const note2 = "⚠️ 每周的學習歷程都需有【影片】才能建檔"
-	const msg = welcome + instruction + portfolio + expertVideo + addPreviewNote + analyzeRecording + addReflection + note1 + note2
-	return handler.bot.ReplyMessage(replyToken, linebot.NewTextMessage(msg)).Do()
+	return handler.SendReply(replyToken, handler.T("instruction"))
 }
 
+const syllabusURL = "https://drive.google.com/open?id=1PeWkePHtq30ArcGqZwzWP64olL9F7Tqw&usp=drive_fs"
+
 func (handler *LineBotHandler) SendSyllabus(replyToken string) (*linebot.BasicResponse, error) {
-	const syllabus = "課程大綱：\n"
-	const msg = syllabus + "https://drive.google.com/open?id=1PeWkePHtq30ArcGqZwzWP64olL9F7Tqw&usp=drive_fs"
-	return handler.bot.ReplyMessage(replyToken, linebot.NewTextMessage(msg)).Do()
+	return handler.SendReply(replyToken, handler.T("syllabus", syllabusURL))
 }
 
 func (handler *LineBotHandler) PromptSkillSelection(replyToken string, action Action, prompt string) (*linebot.BasicResponse, error) {
@@ -63,15 +146,32 @@ func (handler *LineBotHandler) PromptSkillSelection(replyToken string, action Ac
 }
 
 func (handler *LineBotHandler) PromptHandednessSelection(replyToken string) (*linebot.BasicResponse, error) {
-	msg := linebot.NewTextMessage("請選擇左手或右手").WithQuickReplies(
+	msg := linebot.NewTextMessage(handler.T("prompt.handedness")).WithQuickReplies(
 		handler.getHandednessQuickReplyItems(),
 	)
 	return handler.bot.ReplyMessage(replyToken, msg).Do()
 }
 
-func (handler *LineBotHandler) SendVideoMessage(replyToken string, video VideoInfo) (*linebot.BasicResponse, error) {
-	videoLink := "https://drive.google.com/uc?export=download&id=" + video.VideoId
-	thumbnailLink := "https://drive.usercontent.google.com/download?id=" + video.ThumbnailId
+// PromptLanguageSelection offers the languages of the catalog as quick
+// replies sending the language command.
+func (handler *LineBotHandler) PromptLanguageSelection(replyToken string) (*linebot.BasicResponse, error) {
+	command := handler.messages.Command(handler.lang, "language")
+	items := []*linebot.QuickReplyButton{}
+	for _, lang := range handler.messages.Languages() {
+		items = append(items, linebot.NewQuickReplyButton(
+			"",
+			linebot.NewMessageAction(handler.messages.Name(lang), command+" "+lang),
+		))
+	}
+	msg := linebot.NewTextMessage(
+		handler.T("language.prompt", handler.messages.Name(handler.lang)),
+	).WithQuickReplies(linebot.NewQuickReplyItems(items...))
+	return handler.bot.ReplyMessage(replyToken, msg).Do()
+}
+
+func (handler *LineBotHandler) SendVideoMessage(replyToken string, video *VideoViewPostback) (*linebot.BasicResponse, error) {
+	videoLink := handler.urls.VideoURL(video.VideoId)
+	thumbnailLink := handler.urls.ThumbnailURL(video.ThumbnailId)
 	return handler.bot.ReplyMessage(
 		replyToken,
 		linebot.NewVideoMessage(videoLink, thumbnailLink),
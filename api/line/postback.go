@@ -0,0 +1,292 @@
+package line
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+)
+
+// PostbackVersion is written into every postback as "v". Bump it when a
+// payload changes incompatibly and keep decoding the older versions for as
+// long as messages carrying them can still be tapped.
+const PostbackVersion = 1
+
+// maxPostbackLength is the limit LINE puts on postback data.
+const maxPostbackLength = 300
+
+var validSkillId = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+var (
+	ErrMalformedPostback          = errors.New("malformed postback data")
+	ErrUnsupportedPostbackVersion = errors.New("unsupported postback version")
+	ErrUnknownPostbackKind        = errors.New("unknown postback kind")
+	ErrInvalidPostbackField       = errors.New("invalid postback field")
+	ErrPostbackTooLong            = errors.New("postback data too long")
+)
+
+// PostbackError wraps one of the errors above together with the offending
+// data, so callers can use errors.Is and still log what was received.
+type PostbackError struct {
+	Data string
+	Err  error
+}
+
+func (e *PostbackError) Error() string {
+	return fmt.Sprintf("%v: %q", e.Err, e.Data)
+}
+
+func (e *PostbackError) Unwrap() error {
+	return e.Err
+}
+
+// Postback is a typed payload carried in the data of a postback action. New
+// payloads register themselves in newPostback.
+type Postback interface {
+	Kind() string
+	encode(values url.Values)
+	decode(values url.Values) error
+}
+
+func newPostback(kind string) (Postback, bool) {
+	switch kind {
+	case "action":
+		return &UserActionPostback{}, true
+	case "date":
+		return &DateSelectionPostback{}, true
+	case "handedness":
+		return &HandednessPostback{}, true
+	case "video":
+		return &VideoViewPostback{}, true
+	case "student":
+		return &StudentPortfolioPostback{}, true
+	default:
+		return nil, false
+	}
+}
+
+func EncodePostback(postback Postback) (string, error) {
+	values := url.Values{}
+	values.Set("v", strconv.Itoa(PostbackVersion))
+	values.Set("k", postback.Kind())
+	postback.encode(values)
+
+	data := values.Encode()
+	if len(data) > maxPostbackLength {
+		return "", &PostbackError{data, ErrPostbackTooLong}
+	}
+	return data, nil
+}
+
+// mustEncodePostback is used for payloads built from fixed values which can
+// never exceed the length limit.
+func mustEncodePostback(postback Postback) string {
+	data, err := EncodePostback(postback)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func DecodePostback(data string) (Postback, error) {
+	// messages sent before versioning was introduced
+	if strings.HasPrefix(data, "video=") {
+		return decodeLegacyVideo(data)
+	}
+
+	values, err := url.ParseQuery(data)
+	if err != nil {
+		return nil, &PostbackError{data, ErrMalformedPostback}
+	}
+
+	var postback Postback
+	switch values.Get("v") {
+	case "":
+		postback, err = decodeLegacy(values)
+	case strconv.Itoa(PostbackVersion):
+		var ok bool
+		postback, ok = newPostback(values.Get("k"))
+		if !ok {
+			return nil, &PostbackError{data, ErrUnknownPostbackKind}
+		}
+		err = postback.decode(values)
+	default:
+		return nil, &PostbackError{data, ErrUnsupportedPostbackVersion}
+	}
+
+	if err != nil {
+		return nil, &PostbackError{data, err}
+	}
+	return postback, nil
+}
+
+// decodeLegacy reads the unversioned "handedness=", "type=&skill=" and
+// "type=&date=" payloads.
+func decodeLegacy(values url.Values) (Postback, error) {
+	var postback Postback
+	switch {
+	case values.Has("handedness"):
+		postback = &HandednessPostback{}
+	case values.Has("date"):
+		postback = &DateSelectionPostback{}
+	case values.Has("skill"):
+		postback = &UserActionPostback{}
+	default:
+		return nil, ErrUnknownPostbackKind
+	}
+	return postback, postback.decode(values)
+}
+
+func decodeLegacyVideo(data string) (Postback, error) {
+	var postback VideoViewPostback
+	err := json.Unmarshal([]byte(strings.TrimPrefix(data, "video=")), &postback)
+	if err != nil {
+		return nil, &PostbackError{data, ErrMalformedPostback}
+	}
+	if postback.VideoId == "" || postback.ThumbnailId == "" {
+		return nil, &PostbackError{data, ErrInvalidPostbackField}
+	}
+	return &postback, nil
+}
+
+func invalidField(name string, value string) error {
+	return fmt.Errorf("%w %s=%q", ErrInvalidPostbackField, name, value)
+}
+
+// UserActionPostback is sent by the skill quick replies. Skill is a skill id
+// which the receiver checks against its skill registry.
+type UserActionPostback struct {
+	Type  Action `json:"type"`
+	Skill string `json:"skill"`
+}
+
+func (p *UserActionPostback) Kind() string {
+	return "action"
+}
+
+func (p *UserActionPostback) encode(values url.Values) {
+	values.Set("type", p.Type.String())
+	values.Set("skill", p.Skill)
+}
+
+func (p *UserActionPostback) decode(values url.Values) error {
+	p.Type = ActionStrToEnum(values.Get("type"))
+	if p.Type == -1 {
+		return invalidField("type", values.Get("type"))
+	}
+	p.Skill = values.Get("skill")
+	if !validSkillId.MatchString(p.Skill) {
+		return invalidField("skill", p.Skill)
+	}
+	return nil
+}
+
+// DateSelectionPostback picks the portfolio entry a reflection or preview
+// note is written for.
+type DateSelectionPostback struct {
+	Type Action `json:"type"`
+	Date string `json:"date"`
+}
+
+func (p *DateSelectionPostback) Kind() string {
+	return "date"
+}
+
+func (p *DateSelectionPostback) encode(values url.Values) {
+	values.Set("type", p.Type.String())
+	values.Set("date", p.Date)
+}
+
+func (p *DateSelectionPostback) decode(values url.Values) error {
+	p.Type = ActionStrToEnum(values.Get("type"))
+	if p.Type != AddReflection && p.Type != AddPreviewNote {
+		return invalidField("type", values.Get("type"))
+	}
+	p.Date = values.Get("date")
+	if _, err := time.Parse("2006-01-02-15-04", p.Date); err != nil {
+		return invalidField("date", p.Date)
+	}
+	return nil
+}
+
+type HandednessPostback struct {
+	Handedness db.Handedness `json:"handedness"`
+}
+
+func (p *HandednessPostback) Kind() string {
+	return "handedness"
+}
+
+func (p *HandednessPostback) encode(values url.Values) {
+	values.Set("handedness", p.Handedness.String())
+}
+
+func (p *HandednessPostback) decode(values url.Values) error {
+	handedness, err := db.HandednessStrToEnum(values.Get("handedness"))
+	if err != nil {
+		return invalidField("handedness", values.Get("handedness"))
+	}
+	p.Handedness = handedness
+	return nil
+}
+
+// VideoViewPostback asks for a stored video to be sent as a video message.
+type VideoViewPostback struct {
+	VideoId     string `json:"video_id"`
+	ThumbnailId string `json:"thumbnail_id"`
+}
+
+func (p *VideoViewPostback) Kind() string {
+	return "video"
+}
+
+func (p *VideoViewPostback) encode(values url.Values) {
+	values.Set("video_id", p.VideoId)
+	values.Set("thumbnail_id", p.ThumbnailId)
+}
+
+func (p *VideoViewPostback) decode(values url.Values) error {
+	p.VideoId = values.Get("video_id")
+	if p.VideoId == "" {
+		return invalidField("video_id", p.VideoId)
+	}
+	p.ThumbnailId = values.Get("thumbnail_id")
+	if p.ThumbnailId == "" {
+		return invalidField("thumbnail_id", p.ThumbnailId)
+	}
+	return nil
+}
+
+// StudentPortfolioPostback lets a teacher open the portfolio of one of the
+// students in their class.
+type StudentPortfolioPostback struct {
+	StudentId string `json:"student_id"`
+	Skill     string `json:"skill"`
+}
+
+func (p *StudentPortfolioPostback) Kind() string {
+	return "student"
+}
+
+func (p *StudentPortfolioPostback) encode(values url.Values) {
+	values.Set("student_id", p.StudentId)
+	values.Set("skill", p.Skill)
+}
+
+func (p *StudentPortfolioPostback) decode(values url.Values) error {
+	p.StudentId = values.Get("student_id")
+	if p.StudentId == "" || len(p.StudentId) > 64 {
+		return invalidField("student_id", p.StudentId)
+	}
+	p.Skill = values.Get("skill")
+	if !validSkillId.MatchString(p.Skill) {
+		return invalidField("skill", p.Skill)
+	}
+	return nil
+}
@@ -2,9 +2,9 @@ package line
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/skill"
 	"github.com/line/line-bot-sdk-go/v7/linebot"
 )
 
@@ -13,25 +13,26 @@ func (handler *LineBotHandler) getSkillQuickReplyItems(actionType Action) *lineb
 	userAction := UserActionPostback{Type: actionType}
 	replyAction := handler.getQuickReplyAction()
 
-	for _, skill := range []Skill{Serve, Smash, Clear} {
-		userAction.Skill = skill
+	for _, skill := range handler.skills.All() {
+		userAction.Skill = skill.Id
 		items = append(items, linebot.NewQuickReplyButton(
 			"",
-			replyAction(userAction),
+			replyAction(userAction, skill),
 		))
 	}
 	return linebot.NewQuickReplyItems(items...)
 }
 
-type ReplyAction func(userAction UserActionPostback) linebot.QuickReplyAction
+type ReplyAction func(userAction UserActionPostback, skill skill.Skill) linebot.QuickReplyAction
 
 func (handler *LineBotHandler) getQuickReplyAction() ReplyAction {
-	return func(userAction UserActionPostback) linebot.QuickReplyAction {
+	return func(userAction UserActionPostback, skill skill.Skill) linebot.QuickReplyAction {
+		label := skill.Label(handler.lang)
 		return linebot.NewPostbackAction(
-			userAction.Skill.ChnString(),
-			userAction.String(),
+			label,
+			mustEncodePostback(&userAction),
 			"",
-			userAction.Skill.ChnString(),
+			label,
 			linebot.InputOption(""),
 			"",
 		)
@@ -41,13 +42,14 @@ func (handler *LineBotHandler) getQuickReplyAction() ReplyAction {
 func (handler *LineBotHandler) getHandednessQuickReplyItems() *linebot.QuickReplyItems {
 	items := []*linebot.QuickReplyButton{}
 	for _, handedness := range []db.Handedness{db.Left, db.Right} {
+		label := handler.T("handedness." + handedness.String())
 		items = append(items, linebot.NewQuickReplyButton(
 			"",
 			linebot.NewPostbackAction(
-				handedness.ChnString(),
-				"handedness="+handedness.String(),
+				label,
+				mustEncodePostback(&HandednessPostback{handedness}),
 				"",
-				handedness.ChnString(),
+				label,
 				"",
 				"",
 			),
@@ -56,24 +58,24 @@ func (handler *LineBotHandler) getHandednessQuickReplyItems() *linebot.QuickRepl
 	return linebot.NewQuickReplyItems(items...)
 }
 
-func (handler *LineBotHandler) ResolveViewExpertVideo(event *linebot.Event, user *db.UserData, skill Skill) error {
-	urlIDs := handler.getActionUrls(user.Handedness, skill)
+func (handler *LineBotHandler) ResolveViewExpertVideo(event *linebot.Event, user *db.UserData, skill skill.Skill) error {
+	urlIDs := skill.ExpertVideos[user.Handedness.String()]
 	if len(urlIDs) == 0 {
-		handler.bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("請輸入正確的羽球動作")).Do()
+		handler.bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage(handler.T("expert.invalid_skill"))).Do()
 		return nil
 	}
 
 	msgs := []linebot.SendingMessage{
 		linebot.NewTextMessage(
-			fmt.Sprintf(
-				"以下為【%v】-【%v】示範影片：",
-				user.Handedness.ChnString(),
-				skill.ChnString(),
+			handler.T(
+				"expert.title",
+				handler.T("handedness."+user.Handedness.String()),
+				skill.Label(handler.lang),
 			)),
 	}
 
 	for i, url := range urlIDs {
-		msg := fmt.Sprintf("專家影片%v：\n%v", i+1, url)
+		msg := handler.T("expert.video", i+1, url)
 		msgs = append(msgs, linebot.NewTextMessage(msg))
 	}
 
@@ -85,19 +87,15 @@ func (handler *LineBotHandler) ResolveViewExpertVideo(event *linebot.Event, user
 	return nil
 }
 
-func (handler *LineBotHandler) ResolveViewPortfolio(event *linebot.Event, user *db.UserData, skill Skill, userState db.UserState) error {
+func (handler *LineBotHandler) ResolveViewPortfolio(event *linebot.Event, user *db.UserData, skill skill.Skill, userState db.UserState) error {
 	// get works from user portfolio
 	works := user.Portfolio.GetSkillPortfolio(skill.String())
 	if len(works) == 0 {
-		var msg string
-		if works == nil {
-			msg = "請輸入正確的羽球動作"
-		} else {
-			msg = fmt.Sprintf("尚未上傳【%v】的學習反思及影片", skill.ChnString())
-		}
+		// skills added to the registry later have no portfolio yet
+		msg := handler.T("portfolio.empty", skill.Label(handler.lang))
 
 		// reply user with error messages
-		handler.replyViewPortfolioError(event, msg)
+		return handler.replyViewPortfolioError(event, msg)
 	}
 
 	// generate carousels from works
@@ -121,18 +119,18 @@ func (handler *LineBotHandler) ResolveViewPortfolio(event *linebot.Event, user *
 	return nil
 }
 
-func (handler *LineBotHandler) PromptUploadVideo(event *linebot.Event, user *db.UserData, skill Skill) error {
+func (handler *LineBotHandler) PromptUploadVideo(event *linebot.Event, user *db.UserData, skill skill.Skill) error {
 	_, err := handler.bot.ReplyMessage(
 		event.ReplyToken,
-		linebot.NewTextMessage("請上傳影片").WithQuickReplies(
+		linebot.NewTextMessage(handler.T("prompt.upload_video")).WithQuickReplies(
 			linebot.NewQuickReplyItems(
 				linebot.NewQuickReplyButton(
 					"",
-					linebot.NewCameraAction("拍攝影片"),
+					linebot.NewCameraAction(handler.T("button.camera")),
 				),
 				linebot.NewQuickReplyButton(
 					"",
-					linebot.NewCameraRollAction("從相簿選擇"),
+					linebot.NewCameraRollAction(handler.T("button.camera_roll")),
 				),
 			),
 		),
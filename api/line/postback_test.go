@@ -0,0 +1,125 @@
+package line
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/HeavenAQ/api/db"
+)
+
+func TestPostbackRoundTrip(t *testing.T) {
+	tests := []Postback{
+		&UserActionPostback{Type: AnalyzeVideo, Skill: "serve"},
+		&UserActionPostback{Type: ViewPortfolio, Skill: "net_kill"},
+		&DateSelectionPostback{Type: AddReflection, Date: "2024-03-01-10-00"},
+		&DateSelectionPostback{Type: AddPreviewNote, Date: "2024-03-01-10-00"},
+		&HandednessPostback{Handedness: db.Left},
+		&VideoViewPostback{VideoId: "U1234/serve/2024-03-01-10-00_1a2b3c4d.mp4", ThumbnailId: "thumb&id=1"},
+		&StudentPortfolioPostback{StudentId: "U5678", Skill: "clear"},
+	}
+
+	for _, want := range tests {
+		t.Run(want.Kind(), func(t *testing.T) {
+			data, err := EncodePostback(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(data, "v=1") || !strings.Contains(data, "k="+want.Kind()) {
+				t.Errorf("data %q lacks the version or kind", data)
+			}
+			got, err := DecodePostback(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decoded %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyPostback(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Postback
+	}{
+		{
+			name: "handedness",
+			data: "handedness=right",
+			want: &HandednessPostback{Handedness: db.Right},
+		},
+		{
+			name: "user action",
+			data: "type=add_reflection&skill=serve",
+			want: &UserActionPostback{Type: AddReflection, Skill: "serve"},
+		},
+		{
+			name: "date selection",
+			data: "type=add_preview_note&date=2024-03-01-10-00",
+			want: &DateSelectionPostback{Type: AddPreviewNote, Date: "2024-03-01-10-00"},
+		},
+		{
+			name: "video as json",
+			data: `video={"video_id":"abc","thumbnail_id":"def"}`,
+			want: &VideoViewPostback{VideoId: "abc", ThumbnailId: "def"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePostback(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decoded %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePostbackErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"malformed query", "v=1&k=action&skill=%zz", ErrMalformedPostback},
+		{"future version", "v=2&k=action&type=analyze_video&skill=serve", ErrUnsupportedPostbackVersion},
+		{"unknown kind", "v=1&k=coupon", ErrUnknownPostbackKind},
+		{"unknown legacy payload", "coupon=1", ErrUnknownPostbackKind},
+		{"unknown action", "v=1&k=action&type=dance&skill=serve", ErrInvalidPostbackField},
+		{"skill id with spaces", "v=1&k=action&type=analyze_video&skill=Serve%20Ball", ErrInvalidPostbackField},
+		{"date of a viewing action", "v=1&k=date&type=view_portfolio&date=2024-03-01-10-00", ErrInvalidPostbackField},
+		{"date not a work date", "v=1&k=date&type=add_reflection&date=2024-03-01", ErrInvalidPostbackField},
+		{"handedness", "v=1&k=handedness&handedness=both", ErrInvalidPostbackField},
+		{"video without thumbnail", "v=1&k=video&video_id=abc", ErrInvalidPostbackField},
+		{"student id too long", "v=1&k=student&skill=serve&student_id=" + strings.Repeat("U", 65), ErrInvalidPostbackField},
+		{"legacy video not json", "video=abc", ErrMalformedPostback},
+		{"legacy video without thumbnail", `video={"video_id":"abc"}`, ErrInvalidPostbackField},
+		{"legacy invalid skill", "type=analyze_video&skill=", ErrInvalidPostbackField},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodePostback(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			var postbackErr *PostbackError
+			if !errors.As(err, &postbackErr) || postbackErr.Data != tt.data {
+				t.Errorf("err = %#v, want a *PostbackError with the data", err)
+			}
+		})
+	}
+}
+
+func TestEncodePostbackTooLong(t *testing.T) {
+	_, err := EncodePostback(&VideoViewPostback{VideoId: strings.Repeat("a", maxPostbackLength), ThumbnailId: "b"})
+	if !errors.Is(err, ErrPostbackTooLong) {
+		t.Errorf("err = %v, want %v", err, ErrPostbackTooLong)
+	}
+}
@@ -0,0 +1,117 @@
+package line
+
+import (
+	"fmt"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/skill"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// SkillTrend is the rating trend of one skill. ChartURL may be empty when
+// charts are not served.
+type SkillTrend struct {
+	Skill    skill.Skill
+	Stats    db.TrendStats
+	ChartURL string
+}
+
+func getTrendStatRow(label string, value string, color string) *linebot.BoxComponent {
+	return &linebot.BoxComponent{
+		Type:   "box",
+		Layout: "baseline",
+		Contents: []linebot.FlexComponent{
+			&linebot.TextComponent{
+				Type:  "text",
+				Text:  label,
+				Size:  "sm",
+				Color: "#aaaaaa",
+				Flex:  linebot.IntPtr(2),
+			},
+			&linebot.TextComponent{
+				Type:  "text",
+				Text:  value,
+				Size:  "sm",
+				Color: color,
+				Flex:  linebot.IntPtr(3),
+			},
+		},
+	}
+}
+
+func (handler *LineBotHandler) getWeekChange(stats db.TrendStats) (string, string) {
+	switch {
+	case !stats.HasWeekChange:
+		return handler.T("trend.not_enough"), "#666666"
+	case stats.WeekChange > 0:
+		return fmt.Sprintf("▲ %.2f", stats.WeekChange), "#06c755"
+	case stats.WeekChange < 0:
+		return fmt.Sprintf("▼ %.2f", -stats.WeekChange), "#e03e3e"
+	default:
+		return handler.T("trend.flat"), "#666666"
+	}
+}
+
+func (handler *LineBotHandler) getTrendBubble(trend SkillTrend) *linebot.BubbleContainer {
+	stats := trend.Stats
+	weekChange, weekChangeColor := handler.getWeekChange(stats)
+	bubble := &linebot.BubbleContainer{
+		Type: "bubble",
+		Body: &linebot.BoxComponent{
+			Type:    "box",
+			Layout:  "vertical",
+			Spacing: "sm",
+			Contents: []linebot.FlexComponent{
+				&linebot.TextComponent{
+					Type:   "text",
+					Text:   "📈 " + trend.Skill.Label(handler.lang),
+					Weight: "bold",
+					Size:   "xl",
+				},
+				&linebot.TextComponent{
+					Type:   "text",
+					Text:   handler.T("trend.count", stats.Count),
+					Size:   "sm",
+					Color:  "#8c8c8c",
+					Margin: "md",
+				},
+				getTrendStatRow(handler.T("trend.best"), fmt.Sprintf("%.2f", stats.Best), "#666666"),
+				getTrendStatRow(handler.T("trend.latest"), fmt.Sprintf("%.2f", stats.Latest), "#666666"),
+				getTrendStatRow(handler.T("trend.average"), fmt.Sprintf("%.2f", stats.Average), "#666666"),
+				getTrendStatRow(handler.T("trend.week_change"), weekChange, weekChangeColor),
+			},
+		},
+	}
+	if trend.ChartURL != "" {
+		bubble.Hero = &linebot.ImageComponent{
+			Type:        "image",
+			URL:         trend.ChartURL,
+			Size:        "full",
+			AspectRatio: "20:13",
+			AspectMode:  "fit",
+		}
+	}
+	return bubble
+}
+
+// SendProgressTrend replies with one bubble per skill showing how the AI
+// rating changed over time.
+func (handler *LineBotHandler) SendProgressTrend(replyToken string, trends []SkillTrend) (*linebot.BasicResponse, error) {
+	if len(trends) == 0 {
+		return handler.SendReply(replyToken, handler.T("trend.none"))
+	}
+
+	// a carousel holds at most 10 bubbles
+	items := []*linebot.BubbleContainer{}
+	for i, trend := range trends {
+		if i == 10 {
+			break
+		}
+		items = append(items, handler.getTrendBubble(trend))
+	}
+	msg := linebot.NewFlexMessage(handler.T("trend.title"), &linebot.CarouselContainer{
+		Type:     "carousel",
+		Contents: items,
+	})
+	return handler.bot.ReplyMessage(replyToken, msg).Do()
+}
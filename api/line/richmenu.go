@@ -0,0 +1,130 @@
+package line
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// maxBulkLink is the number of users LINE links in one request.
+const maxBulkLink = 500
+
+// CreateRichMenu validates and creates a rich menu and uploads its image,
+// returning its id. The menu is deleted again when the image is refused, so
+// no menu without an image is left behind.
+func (handler *LineBotHandler) CreateRichMenu(menu linebot.RichMenu, imagePath string) (string, error) {
+	if _, err := handler.bot.ValidateRichMenuObject(menu).Do(); err != nil {
+		return "", err
+	}
+	created, err := handler.bot.CreateRichMenu(menu).Do()
+	if err != nil {
+		return "", err
+	}
+	if _, err := handler.bot.UploadRichMenuImage(created.RichMenuID, imagePath).Do(); err != nil {
+		handler.bot.DeleteRichMenu(created.RichMenuID).Do()
+		return "", err
+	}
+	return created.RichMenuID, nil
+}
+
+// SetRichMenuAlias points an alias at a rich menu, creating the alias the
+// first time.
+func (handler *LineBotHandler) SetRichMenuAlias(alias string, richMenuId string) error {
+	_, err := handler.bot.UpdateRichMenuAlias(alias, richMenuId).Do()
+	var apiErr *linebot.APIError
+	if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusBadRequest) {
+		_, err = handler.bot.CreateRichMenuAlias(alias, richMenuId).Do()
+	}
+	return err
+}
+
+// GetRichMenuId returns the rich menu an alias points at.
+func (handler *LineBotHandler) GetRichMenuId(alias string) (string, error) {
+	res, err := handler.bot.GetRichMenuAlias(alias).Do()
+	if err != nil {
+		return "", err
+	}
+	return res.RichMenuID, nil
+}
+
+func (handler *LineBotHandler) SetDefaultRichMenu(richMenuId string) error {
+	_, err := handler.bot.SetDefaultRichMenu(richMenuId).Do()
+	return err
+}
+
+func (handler *LineBotHandler) ListRichMenus() ([]*linebot.RichMenuResponse, error) {
+	return handler.bot.GetRichMenuList().Do()
+}
+
+// DeleteRichMenus deletes the rich menus named name except keep, which are
+// the earlier versions of a menu that was deployed again.
+func (handler *LineBotHandler) DeleteRichMenus(name string, keep string) ([]string, error) {
+	menus, err := handler.bot.GetRichMenuList().Do()
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	for _, menu := range menus {
+		if menu.Name != name || menu.RichMenuID == keep {
+			continue
+		}
+		if _, err := handler.bot.DeleteRichMenu(menu.RichMenuID).Do(); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, menu.RichMenuID)
+	}
+	return deleted, nil
+}
+
+// LinkRichMenu shows the rich menu an alias points at to the users instead
+// of the default one.
+func (handler *LineBotHandler) LinkRichMenu(alias string, userIds ...string) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	richMenuId, err := handler.GetRichMenuId(alias)
+	if err != nil {
+		return err
+	}
+	if len(userIds) == 1 {
+		_, err := handler.bot.LinkUserRichMenu(userIds[0], richMenuId).Do()
+		return err
+	}
+	for start := 0; start < len(userIds); start += maxBulkLink {
+		end := min(start+maxBulkLink, len(userIds))
+		if _, err := handler.bot.BulkLinkRichMenu(richMenuId, userIds[start:end]...).Do(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnlinkRichMenu puts the users back on the default rich menu.
+func (handler *LineBotHandler) UnlinkRichMenu(userIds ...string) error {
+	if len(userIds) == 1 {
+		_, err := handler.bot.UnlinkUserRichMenu(userIds[0]).Do()
+		return err
+	}
+	for start := 0; start < len(userIds); start += maxBulkLink {
+		end := min(start+maxBulkLink, len(userIds))
+		if _, err := handler.bot.BulkUnlinkRichMenu(userIds[start:end]...).Do(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDefaultRichMenu returns the id of the menu shown to users not linked
+// to another one, empty when there is none.
+func (handler *LineBotHandler) GetDefaultRichMenu() (string, error) {
+	res, err := handler.bot.GetDefaultRichMenu().Do()
+	var apiErr *linebot.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.RichMenuID, nil
+}
@@ -0,0 +1,136 @@
+package line
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"golang.org/x/exp/maps"
+)
+
+func (handler *LineBotHandler) studentLabel(student *db.UserData) string {
+	return handler.T("student.label", student.Name, student.TestNumber)
+}
+
+func (handler *LineBotHandler) SendStudentList(replyToken string, classCode string, students []*db.UserData) (*linebot.BasicResponse, error) {
+	if len(students) == 0 {
+		return handler.SendReply(replyToken, handler.T("students.empty", classCode))
+	}
+
+	lines := []string{handler.T("students.title", classCode, len(students))}
+	for _, student := range students {
+		lines = append(lines, "・"+handler.studentLabel(student))
+	}
+	return handler.SendReply(replyToken, strings.Join(lines, "\n"))
+}
+
+// SendWeeklyProgressReport lists the students of a class who have not
+// uploaded a video or written a reflection in the week of now.
+func (handler *LineBotHandler) SendWeeklyProgressReport(replyToken string, classCode string, students []*db.UserData, now time.Time) (*linebot.BasicResponse, error) {
+	missingVideo := []string{}
+	missingReflection := []string{}
+	for _, student := range students {
+		progress := student.WeeklyProgress(now)
+		if progress.Videos == 0 {
+			missingVideo = append(missingVideo, "・"+handler.studentLabel(student))
+		}
+		if progress.Reflections == 0 {
+			missingReflection = append(missingReflection, "・"+handler.studentLabel(student))
+		}
+	}
+
+	section := func(title string, names []string) string {
+		if len(names) == 0 {
+			return handler.T("weekly.all_done", title)
+		}
+		return handler.T("weekly.section", title, len(names), strings.Join(names, "\n"))
+	}
+
+	msg := handler.T(
+		"weekly.title",
+		classCode,
+		db.WeekStart(now).Format("2006-01-02"),
+		section(handler.T("weekly.missing_video"), missingVideo),
+		section(handler.T("weekly.missing_reflection"), missingReflection),
+	)
+	return handler.SendReply(replyToken, msg)
+}
+
+func (handler *LineBotHandler) PromptStudentSkillSelection(replyToken string, student *db.UserData) (*linebot.BasicResponse, error) {
+	items := []*linebot.QuickReplyButton{}
+	for _, skill := range handler.skills.All() {
+		postback := StudentPortfolioPostback{student.Id, skill.Id}
+		label := skill.Label(handler.lang)
+		items = append(items, linebot.NewQuickReplyButton(
+			"",
+			linebot.NewPostbackAction(
+				label,
+				mustEncodePostback(&postback),
+				"",
+				label,
+				"",
+				"",
+			),
+		))
+	}
+
+	msg := linebot.NewTextMessage(
+		handler.T("student.select_skill", handler.studentLabel(student)),
+	).WithQuickReplies(linebot.NewQuickReplyItems(items...))
+	return handler.bot.ReplyMessage(replyToken, msg).Do()
+}
+
+// maxFaultReportLines keeps the report below the size limit of a text message
+const maxFaultReportLines = 30
+
+// SendFaultCodes lists the fault codes found in a class so the teacher can
+// pick one to search for.
+func (handler *LineBotHandler) SendFaultCodes(replyToken string, classCode string, counts map[string]int) (*linebot.BasicResponse, error) {
+	if len(counts) == 0 {
+		return handler.SendReply(replyToken, handler.T("faults.none", classCode))
+	}
+
+	codes := maps.Keys(counts)
+	sort.Slice(codes, func(i, j int) bool {
+		if counts[codes[i]] != counts[codes[j]] {
+			return counts[codes[i]] > counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+
+	lines := []string{handler.T("faults.title", classCode)}
+	for _, code := range codes {
+		lines = append(lines, handler.T("faults.count", code, counts[code]))
+	}
+	lines = append(lines, handler.T("faults.usage", handler.messages.Command(handler.lang, "faults")))
+	return handler.SendReply(replyToken, strings.Join(lines, "\n"))
+}
+
+func (handler *LineBotHandler) SendFaultReport(replyToken string, classCode string, code string, matches []db.FaultMatch) (*linebot.BasicResponse, error) {
+	if len(matches) == 0 {
+		return handler.SendReply(replyToken, handler.T("faults.no_match", classCode, code))
+	}
+
+	lines := []string{handler.T("faults.match_title", classCode, code, len(matches))}
+	for i, match := range matches {
+		if i == maxFaultReportLines {
+			lines = append(lines, handler.T("faults.more", len(matches)-i))
+			break
+		}
+		skillName := match.Skill
+		if skill, ok := handler.skills.Get(match.Skill); ok {
+			skillName = skill.Label(handler.lang)
+		}
+		lines = append(lines, fmt.Sprintf(
+			"・%v｜%v｜%v｜%v",
+			handler.studentLabel(match.User),
+			skillName,
+			match.Work.DateTime[:10],
+			handler.severityLabel(match.Fault.Severity),
+		))
+	}
+	return handler.SendReply(replyToken, strings.Join(lines, "\n"))
+}
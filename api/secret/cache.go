@@ -0,0 +1,113 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Cached keeps secrets for a while instead of fetching them on every call.
+// A secret is fetched again once it is older than the ttl. When the provider
+// cannot be reached, the last value is used until it is back; a secret that
+// was deleted or that access was revoked to is not served anymore.
+type Cached struct {
+	provider Provider
+	ttl      time.Duration
+	now      func() time.Time
+	logger   *log.Logger
+
+	mu      sync.Mutex
+	entries map[string]cachedSecret
+	// ids being fetched, so concurrent callers wait for one fetch
+	fetching map[string]chan struct{}
+}
+
+type cachedSecret struct {
+	value     []byte
+	fetchedAt time.Time
+}
+
+func NewCached(provider Provider, ttl time.Duration, logger *log.Logger) *Cached {
+	return &Cached{
+		provider: provider,
+		ttl:      ttl,
+		now:      time.Now,
+		logger:   logger,
+		entries:  map[string]cachedSecret{},
+		fetching: map[string]chan struct{}{},
+	}
+}
+
+func (c *Cached) Get(ctx context.Context, id string) ([]byte, error) {
+	for {
+		c.mu.Lock()
+		entry, ok := c.entries[id]
+		if ok && c.now().Sub(entry.fetchedAt) < c.ttl {
+			c.mu.Unlock()
+			return entry.value, nil
+		}
+		wait, busy := c.fetching[id]
+		if !busy {
+			done := make(chan struct{})
+			c.fetching[id] = done
+			c.mu.Unlock()
+			return c.fetch(ctx, id, done)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Cached) fetch(ctx context.Context, id string, done chan struct{}) ([]byte, error) {
+	value, err := c.provider.Get(ctx, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fetching, id)
+	close(done)
+	if err != nil {
+		entry, ok := c.entries[id]
+		if !ok || !transient(err) {
+			delete(c.entries, id)
+			return nil, err
+		}
+		c.logger.Println("\n\tServing secret", id, "fetched at", entry.fetchedAt.Format(time.RFC3339), "after error:", err)
+		return entry.value, nil
+	}
+	c.entries[id] = cachedSecret{value, c.now()}
+	return value, nil
+}
+
+// transient reports whether a failed fetch may succeed later: timeouts,
+// network errors and Secret Manager being unavailable or overloaded.
+func transient(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, fs.ErrPermission) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if grpcStatus, ok := status.FromError(err); ok {
+		switch grpcStatus.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+			return true
+		}
+	}
+	return false
+}
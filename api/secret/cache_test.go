@@ -0,0 +1,168 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubProvider answers with its results in order, repeating the last one.
+type stubProvider struct {
+	mu      sync.Mutex
+	results []stubResult
+	calls   int
+}
+
+type stubResult struct {
+	value string
+	err   error
+}
+
+func (p *stubProvider) Get(ctx context.Context, id string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := p.results[min(p.calls, len(p.results)-1)]
+	p.calls++
+	if result.err != nil {
+		return nil, result.err
+	}
+	return []byte(result.value), nil
+}
+
+var unavailableErr = status.Error(codes.Unavailable, "connection refused")
+
+func TestCachedGet(t *testing.T) {
+	denied := fmt.Errorf("failed to access secret sa: %w", status.Error(codes.PermissionDenied, "denied"))
+
+	tests := []struct {
+		name    string
+		results []stubResult
+		// gets made, the cache expiring before each one but the first
+		gets  int
+		want  string
+		err   error
+		calls int
+	}{
+		{
+			name:    "cached within the ttl",
+			results: []stubResult{{value: "v1"}, {value: "v2"}},
+			gets:    1,
+			want:    "v1",
+			calls:   1,
+		},
+		{
+			name:    "latest version after the ttl",
+			results: []stubResult{{value: "v1"}, {value: "v2"}},
+			gets:    2,
+			want:    "v2",
+			calls:   2,
+		},
+		{
+			name:    "stale value while unavailable",
+			results: []stubResult{{value: "v1"}, {err: unavailableErr}},
+			gets:    3,
+			want:    "v1",
+			calls:   3,
+		},
+		{
+			name:    "stale value after a timeout",
+			results: []stubResult{{value: "v1"}, {err: context.DeadlineExceeded}},
+			gets:    2,
+			want:    "v1",
+			calls:   2,
+		},
+		{
+			name:    "deleted secret is not served",
+			results: []stubResult{{value: "v1"}, {err: fmt.Errorf("%w: sa", ErrNotFound)}},
+			gets:    2,
+			err:     ErrNotFound,
+			calls:   2,
+		},
+		{
+			name:    "revoked access is not served",
+			results: []stubResult{{value: "v1"}, {err: denied}},
+			gets:    2,
+			err:     denied,
+			calls:   2,
+		},
+		{
+			name:    "nothing to fall back on",
+			results: []stubResult{{err: unavailableErr}},
+			gets:    1,
+			err:     unavailableErr,
+			calls:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &stubProvider{results: tt.results}
+			cache := NewCached(provider, time.Minute, log.New(io.Discard, "", 0))
+			now := time.Now()
+			cache.now = func() time.Time { return now }
+
+			var value []byte
+			var err error
+			for i := 0; i < tt.gets; i++ {
+				if i > 0 {
+					now = now.Add(2 * time.Minute)
+				}
+				value, err = cache.Get(context.Background(), "sa")
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if string(value) != tt.want {
+				t.Errorf("value %q, want %q", value, tt.want)
+			}
+			if provider.calls != tt.calls {
+				t.Errorf("%d fetches, want %d", provider.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestCachedGetAfterRevocation(t *testing.T) {
+	provider := &stubProvider{results: []stubResult{{value: "v1"}, {err: ErrNotFound}, {err: unavailableErr}}}
+	cache := NewCached(provider, time.Minute, log.New(io.Discard, "", 0))
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Get(context.Background(), "sa")
+	now = now.Add(2 * time.Minute)
+	cache.Get(context.Background(), "sa")
+
+	// the revoked value is forgotten, so an outage afterwards cannot bring
+	// it back
+	if _, err := cache.Get(context.Background(), "sa"); !errors.Is(err, unavailableErr) {
+		t.Errorf("err = %v, want %v", err, unavailableErr)
+	}
+}
+
+func TestCachedConcurrentGets(t *testing.T) {
+	provider := &stubProvider{results: []stubResult{{value: "v1"}}}
+	cache := NewCached(provider, time.Minute, log.New(io.Discard, "", 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := cache.Get(context.Background(), "sa"); err != nil || string(value) != "v1" {
+				t.Errorf("got %q, %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if provider.calls != 1 {
+		t.Errorf("%d fetches, want 1", provider.calls)
+	}
+}
@@ -0,0 +1,54 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Env reads secrets from environment variables named SECRET_ and the id in
+// upper case with other characters than letters and digits replaced by an
+// underscore, e.g. SECRET_FIREBASE_SA for "firebase-sa".
+type Env struct{}
+
+func (Env) Get(ctx context.Context, id string) ([]byte, error) {
+	key := EnvKey(id)
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("%w: %v is not set", ErrNotFound, key)
+	}
+	return []byte(value), nil
+}
+
+// EnvKey returns the variable Env reads a secret from.
+func EnvKey(id string) string {
+	return "SECRET_" + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, id)
+}
+
+// Files reads secrets from files named after their id in a directory, as
+// mounted by Cloud Run or Kubernetes. Files are read on every call.
+type Files struct {
+	Dir string
+}
+
+func (f Files) Get(ctx context.Context, id string) ([]byte, error) {
+	// ids are names, not paths out of the directory
+	if id == "" || filepath.Base(id) != id || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid secret id %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(f.Dir, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, filepath.Join(f.Dir, id))
+	}
+	return data, err
+}
@@ -2,37 +2,85 @@ package secret
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sync"
 
 	"cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func AccessSecretVersion(name string) ([]byte, error) {
-	// create secret manager client
-	ctx := context.Background()
-	client, err := secretmanager.NewClient(ctx)
+// ErrNotFound is returned by the providers for unknown secrets.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider returns the latest version of a secret by its id, e.g. the id of
+// a Secret Manager secret.
+type Provider interface {
+	Get(ctx context.Context, id string) ([]byte, error)
+}
+
+// SecretManager reads secrets from Google Secret Manager. Its client is
+// created on first use and shared by every call, so running without GCP
+// credentials only fails when a secret is actually needed.
+type SecretManager struct {
+	projectID string
+	mu        sync.Mutex
+	client    *secretmanager.Client
+}
+
+func NewSecretManager(projectID string) *SecretManager {
+	return &SecretManager{projectID: projectID}
+}
+
+func (sm *SecretManager) Get(ctx context.Context, id string) ([]byte, error) {
+	client, err := sm.getClient(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create secretmanager client: %v", err)
+		return nil, err
 	}
-	defer client.Close()
 
 	// access secret version
 	req := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: name,
+		Name: GetSecretNameString(sm.projectID, id),
 	}
-
 	result, err := client.AccessSecretVersion(ctx, req)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, id)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to access secret version: %v", err)
+		return nil, fmt.Errorf("failed to access secret %v: %w", id, err)
 	}
 
 	// return secret data
 	return result.Payload.Data, nil
 }
 
-func GetSecretNameString(secretID string) string {
-	projectID := os.Getenv("GCP_PROJECT_ID")
+func (sm *SecretManager) getClient(ctx context.Context) (*secretmanager.Client, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.client == nil {
+		// the client outlives the request that created it
+		client, err := secretmanager.NewClient(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create secretmanager client: %v", err)
+		}
+		sm.client = client
+	}
+	return sm.client, nil
+}
+
+func (sm *SecretManager) Close() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.client == nil {
+		return nil
+	}
+	err := sm.client.Close()
+	sm.client = nil
+	return err
+}
+
+func GetSecretNameString(projectID string, secretID string) string {
 	return fmt.Sprintf("projects/%s/secrets/%s/versions/latest", projectID, secretID)
 }
@@ -0,0 +1,126 @@
+package video
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+// probeTimeout keeps a corrupt upload from hanging a worker
+const probeTimeout = 30 * time.Second
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		Size     string `json:"size"`
+	} `json:"format"`
+}
+
+// Probe runs ffprobe on the file at path.
+func Probe(path string) (*Info, error) {
+	out, err := ffmpeg_go.ProbeWithTimeout(path, probeTimeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseProbe([]byte(out))
+}
+
+func parseProbe(data []byte) (*Info, error) {
+	var out probeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+
+	info := &Info{}
+	info.Size, _ = strconv.ParseInt(out.Format.Size, 10, 64)
+	info.Duration = parseSeconds(out.Format.Duration)
+	for _, stream := range out.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		info.Codec = stream.CodecName
+		info.Width = stream.Width
+		info.Height = stream.Height
+		info.FPS = parseRate(stream.AvgFrameRate)
+		if info.FPS == 0 {
+			info.FPS = parseRate(stream.RFrameRate)
+		}
+		if info.Duration == 0 {
+			info.Duration = parseSeconds(stream.Duration)
+		}
+
+		// phones record portrait clips as landscape frames with a rotation,
+		// kept in a tag by older ffprobe versions and in side data by newer
+		if rotate, err := strconv.Atoi(stream.Tags["rotate"]); err == nil {
+			info.Rotation = rotate
+		}
+		for _, sideData := range stream.SideDataList {
+			if sideData.Rotation != 0 {
+				info.Rotation = int(math.Round(sideData.Rotation))
+			}
+		}
+		info.Rotation = ((info.Rotation % 360) + 360) % 360
+		break
+	}
+	return info, nil
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// parseRate reads frame rates such as "30000/1001".
+func parseRate(value string) float64 {
+	num, den, found := strings.Cut(value, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// HasVideo reports whether ffprobe found a video stream.
+func (info *Info) HasVideo() bool {
+	return info.Width > 0 && info.Height > 0
+}
+
+// DisplaySize is the frame size once rotation is applied.
+func (info *Info) DisplaySize() (int, int) {
+	if info.Rotation == 90 || info.Rotation == 270 {
+		return info.Height, info.Width
+	}
+	return info.Width, info.Height
+}
+
+func (info *Info) Landscape() bool {
+	width, height := info.DisplaySize()
+	return width > height
+}
@@ -0,0 +1,87 @@
+package video
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultLimits accept what a phone records of a single stroke.
+func DefaultLimits() Limits {
+	return Limits{
+		MinDuration: 1 * time.Second,
+		MaxDuration: 30 * time.Second,
+		TrimLong:    true,
+		MaxSize:     200 * 1024 * 1024,
+		MinFPS:      15,
+		MaxFPS:      60,
+		Codecs:      []string{"h264", "hevc", "mpeg4", "vp8", "vp9", "av1"},
+		Width:       1080,
+		Height:      1920,
+	}
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.1f", d.Seconds())
+}
+
+// Plan checks a video against limits and returns how to preprocess it, or a
+// *RejectedError.
+func Plan(info *Info, limits Limits) (*Profile, error) {
+	if !info.HasVideo() {
+		return nil, &RejectedError{Reason: NoVideoStream}
+	}
+	if len(limits.Codecs) > 0 && !slices.Contains(limits.Codecs, info.Codec) {
+		return nil, &RejectedError{UnsupportedCodec, info.Codec, strings.Join(limits.Codecs, ",")}
+	}
+	if limits.MaxSize > 0 && info.Size > limits.MaxSize {
+		return nil, &RejectedError{TooLarge, formatMegabytes(info.Size), formatMegabytes(limits.MaxSize)}
+	}
+	if limits.MinDuration > 0 && info.Duration < limits.MinDuration {
+		return nil, &RejectedError{TooShort, formatSeconds(info.Duration), formatSeconds(limits.MinDuration)}
+	}
+	if limits.MinFPS > 0 && info.FPS < limits.MinFPS {
+		return nil, &RejectedError{LowFrameRate, fmt.Sprintf("%.0f", info.FPS), fmt.Sprintf("%.0f", limits.MinFPS)}
+	}
+
+	profile := &Profile{Width: limits.Width, Height: limits.Height}
+	if limits.MaxDuration > 0 && info.Duration > limits.MaxDuration {
+		if !limits.TrimLong {
+			return nil, &RejectedError{TooLong, formatSeconds(info.Duration), formatSeconds(limits.MaxDuration)}
+		}
+		profile.Duration = limits.MaxDuration
+	}
+	if limits.MaxFPS > 0 && info.FPS > limits.MaxFPS {
+		profile.FPS = limits.MaxFPS
+	}
+
+	// compare aspect ratios by cross multiplying to stay in integers
+	width, height := info.DisplaySize()
+	profile.Pad = width*limits.Height != height*limits.Width
+	return profile, nil
+}
+
+func formatMegabytes(size int64) string {
+	return fmt.Sprintf("%.1f", float64(size)/1024/1024)
+}
+
+// Filter is the ffmpeg video filter of the profile. The video is scaled to
+// fit the frame and padded with black bars, so landscape clips keep their
+// proportions.
+func (profile *Profile) Filter() string {
+	filters := []string{}
+	if profile.FPS > 0 {
+		filters = append(filters, fmt.Sprintf("fps=%g", profile.FPS))
+	}
+	if profile.Pad {
+		filters = append(filters,
+			fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", profile.Width, profile.Height),
+			fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", profile.Width, profile.Height),
+		)
+	} else {
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", profile.Width, profile.Height))
+	}
+	// keep square pixels so players do not stretch the result again
+	return strings.Join(append(filters, "setsar=1"), ",")
+}
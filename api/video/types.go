@@ -0,0 +1,99 @@
+// Package video inspects uploads with ffprobe and decides how they are
+// preprocessed before analysis.
+package video
+
+import (
+	"fmt"
+	"time"
+)
+
+// Info is what ffprobe reports about the first video stream of a file.
+// Width and Height are the stored frame size; Rotation is in degrees and is
+// applied by ffmpeg when the video is transcoded.
+type Info struct {
+	Duration time.Duration
+	Size     int64
+	Codec    string
+	Width    int
+	Height   int
+	Rotation int
+	FPS      float64
+}
+
+// Limits are the videos accepted for analysis. Zero values disable a check.
+type Limits struct {
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// TrimLong cuts videos longer than MaxDuration instead of rejecting them
+	TrimLong bool
+	// MaxSize is in bytes
+	MaxSize int64
+	MinFPS  float64
+	// MaxFPS is the frame rate faster videos are reduced to
+	MaxFPS float64
+	// Codecs are the accepted ffprobe codec names
+	Codecs []string
+	// Width and Height is the frame sent to the AI server; videos are scaled
+	// to fit and padded, never stretched
+	Width  int
+	Height int
+}
+
+// Profile is how a video is preprocessed before analysis.
+type Profile struct {
+	// Duration is where the video is cut, 0 keeps all of it
+	Duration time.Duration
+	// FPS is the frame rate to convert to, 0 keeps the original
+	FPS    float64
+	Width  int
+	Height int
+	// Pad is set when the video's aspect ratio differs from the frame
+	Pad bool
+}
+
+type Reason int8
+
+const (
+	TooShort Reason = iota
+	TooLong
+	TooLarge
+	LowFrameRate
+	UnsupportedCodec
+	NoVideoStream
+)
+
+func (r Reason) String() string {
+	switch r {
+	case TooShort:
+		return "too_short"
+	case TooLong:
+		return "too_long"
+	case TooLarge:
+		return "too_large"
+	case LowFrameRate:
+		return "low_frame_rate"
+	case UnsupportedCodec:
+		return "unsupported_codec"
+	case NoVideoStream:
+		return "no_video_stream"
+	}
+	return "unknown"
+}
+
+// RejectedError is returned by Plan for videos outside the limits. Actual
+// and Limit are the offending value and the bound it broke.
+type RejectedError struct {
+	Reason Reason
+	Actual string
+	Limit  string
+}
+
+func (e *RejectedError) Error() string {
+	if e.Actual == "" {
+		return fmt.Sprintf("video rejected: %v", e.Reason)
+	}
+	if e.Limit == "" {
+		return fmt.Sprintf("video rejected: %v (%v)", e.Reason, e.Actual)
+	}
+	return fmt.Sprintf("video rejected: %v (%v, limit %v)", e.Reason, e.Actual, e.Limit)
+}
@@ -0,0 +1,433 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+)
+
+// adminPath is the prefix of the staff JSON API served next to /callback.
+const adminPath = "/admin/"
+
+var (
+	errAdminNotFound      = errors.New("not found")
+	errMethodNotAllowed   = errors.New("method not allowed")
+	errJobAlreadyQueued   = errors.New("job is already queued or running")
+	errInvalidRequestBody = errors.New("invalid request body")
+)
+
+type adminUser struct {
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	TestNumber     int    `json:"testNumber"`
+	Handedness     string `json:"handedness"`
+	Role           string `json:"role"`
+	ClassCode      string `json:"classCode"`
+	ReminderOptOut bool   `json:"reminderOptOut"`
+	Language       string `json:"language"`
+	Works          int    `json:"works"`
+}
+
+type adminWork struct {
+	db.Work
+	VideoURL     string `json:"videoUrl"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+}
+
+// adminWorkUpdate holds the Work fields staff may edit; fields left out of the
+// request body are kept.
+type adminWorkUpdate struct {
+	AINote     *string  `json:"aiNote"`
+	Reflection *string  `json:"reflection"`
+	Rating     *float32 `json:"rating"`
+}
+
+// AdminHandler serves the admin API. Every request must carry the
+// ADMIN_TOKEN as a bearer token; the API is disabled when no token is set.
+//
+//	GET   /admin/users
+//	GET   /admin/users/{id}
+//	GET   /admin/users/{id}/portfolio[?skill=]
+//	PATCH /admin/users/{id}/portfolio/{skill}/{date}
+//	POST  /admin/users/{id}/portfolio/{skill}/{date}/analyze
+//	POST  /admin/users/{id}/session/reset
+//	GET   /admin/faults?code=&severity=&skill=&class=
+//	GET   /admin/jobs?status=
+//	POST  /admin/jobs/{id}/retry
+//	POST  /admin/reminders
+func (app *App) AdminHandler() http.Handler {
+	token := app.Config.AdminToken
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token == "" {
+			writeAdminError(w, http.StatusNotFound, errAdminNotFound)
+			return
+		}
+		auth := req.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+
+		status, body, err := app.routeAdmin(req)
+		if err != nil {
+			if status == http.StatusInternalServerError {
+				app.ErrorLogger.Println("\n\tError handling admin request", req.Method, req.URL.Path, ":", err)
+			}
+			writeAdminError(w, status, err)
+			return
+		}
+		writeAdminJSON(w, status, body)
+	})
+}
+
+func (app *App) routeAdmin(req *http.Request) (int, any, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, adminPath), "/"), "/")
+	method := req.Method
+
+	switch {
+	case len(parts) == 1 && parts[0] == "users":
+		if method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminListUsers()
+	case len(parts) == 2 && parts[0] == "users":
+		if method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminGetUser(parts[1])
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "portfolio":
+		if method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminGetPortfolio(parts[1], req.URL.Query().Get("skill"))
+	case len(parts) == 5 && parts[0] == "users" && parts[2] == "portfolio":
+		if method != http.MethodPatch {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminUpdateWork(req, parts[1], parts[3], parts[4])
+	case len(parts) == 6 && parts[0] == "users" && parts[2] == "portfolio" && parts[5] == "analyze":
+		if method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminReanalyzeWork(parts[1], parts[3], parts[4])
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "session" && parts[3] == "reset":
+		if method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminResetSession(parts[1])
+	case len(parts) == 1 && parts[0] == "faults":
+		if method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminFindFaults(req.URL.Query())
+	case len(parts) == 1 && parts[0] == "jobs":
+		if method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminListJobs(req.URL.Query().Get("status"))
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "retry":
+		if method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminRetryJob(parts[1])
+	case len(parts) == 1 && parts[0] == "reminders":
+		if method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil, errMethodNotAllowed
+		}
+		return app.adminSendReminders()
+	default:
+		return http.StatusNotFound, nil, errAdminNotFound
+	}
+}
+
+// storeErrorStatus maps store errors to the HTTP status returned to staff.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, db.ErrUserNotFound), errors.Is(err, db.ErrSessionNotFound), errors.Is(err, db.ErrJobNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (app *App) adminListUsers() (int, any, error) {
+	users, err := app.Db.ListUsers()
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].TestNumber != users[j].TestNumber {
+			return users[i].TestNumber < users[j].TestNumber
+		}
+		return users[i].Id < users[j].Id
+	})
+
+	summaries := []adminUser{}
+	for _, user := range users {
+		works := 0
+		for _, skillWorks := range user.Portfolio.Skills {
+			works += len(skillWorks)
+		}
+		summaries = append(summaries, adminUser{
+			Id:             user.Id,
+			Name:           user.Name,
+			TestNumber:     user.TestNumber,
+			Handedness:     user.Handedness.String(),
+			Role:           user.Role.String(),
+			ClassCode:      user.ClassCode,
+			ReminderOptOut: user.ReminderOptOut,
+			Language:       user.Language,
+			Works:          works,
+		})
+	}
+	return http.StatusOK, summaries, nil
+}
+
+func (app *App) adminGetUser(userId string) (int, any, error) {
+	user, err := app.Db.GetUserData(userId)
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	return http.StatusOK, user, nil
+}
+
+func (app *App) adminGetPortfolio(userId string, skill string) (int, any, error) {
+	user, err := app.Db.GetUserData(userId)
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+
+	portfolio := map[string]map[string]adminWork{}
+	for skillId, works := range user.Portfolio.Skills {
+		if skill != "" && skillId != skill {
+			continue
+		}
+		portfolio[skillId] = map[string]adminWork{}
+		for date, work := range works {
+			portfolio[skillId][date] = adminWork{
+				Work:         work,
+				VideoURL:     app.Storage.VideoURL(work.SkeletonVideo),
+				ThumbnailURL: app.Storage.ThumbnailURL(work.Thumbnail),
+			}
+		}
+	}
+	if skill != "" && len(portfolio) == 0 {
+		return http.StatusNotFound, nil, errors.New("no works for skill " + skill)
+	}
+	return http.StatusOK, portfolio, nil
+}
+
+func (app *App) adminUpdateWork(req *http.Request, userId string, skill string, date string) (int, any, error) {
+	var update adminWorkUpdate
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, req.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		return http.StatusBadRequest, nil, errInvalidRequestBody
+	}
+	if update.Rating != nil && *update.Rating < 0 {
+		return http.StatusBadRequest, nil, errors.New("rating must not be negative")
+	}
+
+	user, err := app.Db.GetUserData(userId)
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	userPortfolio, ok := user.Portfolio.Skills[skill]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("no works for skill " + skill)
+	}
+	work, ok := userPortfolio[date]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("no work on " + date)
+	}
+
+	if update.AINote != nil {
+		work.AINote = *update.AINote
+	}
+	if update.Reflection != nil {
+		work.Reflection = *update.Reflection
+	}
+	if update.Rating != nil {
+		work.Rating = *update.Rating
+	}
+	if err := app.Db.UpdateUserPortfolioWork(user, &userPortfolio, skill, work); err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	app.InfoLogger.Println("\n\tAdmin updated work", date, "of user", userId)
+	return http.StatusOK, work, nil
+}
+
+func (app *App) adminResetSession(userId string) (int, any, error) {
+	if _, err := app.Db.GetUserData(userId); err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+
+	session := db.UserSession{UserState: db.None}
+	if err := app.Db.UpdateUserSession(userId, session); err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	app.InfoLogger.Println("\n\tAdmin reset session of user", userId)
+	return http.StatusOK, session, nil
+}
+
+type adminFaultMatch struct {
+	UserId     string   `json:"userId"`
+	Name       string   `json:"name"`
+	TestNumber int      `json:"testNumber"`
+	ClassCode  string   `json:"classCode"`
+	Skill      string   `json:"skill"`
+	Date       string   `json:"date"`
+	Fault      db.Fault `json:"fault"`
+}
+
+// adminFindFaults returns the works having a fault code, or how many works
+// have each code when no code is given.
+func (app *App) adminFindFaults(query url.Values) (int, any, error) {
+	severity := db.FaultSeverity(query.Get("severity"))
+	switch severity {
+	case "", db.Minor, db.Moderate, db.Major:
+	default:
+		return http.StatusBadRequest, nil, errors.New("unknown severity " + string(severity))
+	}
+
+	var users []*db.UserData
+	var err error
+	if classCode := query.Get("class"); classCode != "" {
+		users, err = app.Db.GetUsersByClass(classCode)
+	} else {
+		users, err = app.Db.ListUsers()
+	}
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return http.StatusOK, db.CountFaults(users), nil
+	}
+
+	matches := []adminFaultMatch{}
+	for _, match := range db.FindFaults(users, code, severity, query.Get("skill")) {
+		matches = append(matches, adminFaultMatch{
+			UserId:     match.User.Id,
+			Name:       match.User.Name,
+			TestNumber: match.User.TestNumber,
+			ClassCode:  match.User.ClassCode,
+			Skill:      match.Skill,
+			Date:       match.Work.DateTime,
+			Fault:      match.Fault,
+		})
+	}
+	return http.StatusOK, matches, nil
+}
+
+func (app *App) adminListJobs(status string) (int, any, error) {
+	if status == "" {
+		status = string(db.JobFailed)
+	}
+	switch db.JobStatus(status) {
+	case db.JobQueued, db.JobRunning, db.JobSucceeded, db.JobFailed:
+	default:
+		return http.StatusBadRequest, nil, errors.New("unknown job status " + status)
+	}
+
+	jobs, err := app.Db.GetJobsByStatus(db.JobStatus(status))
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	return http.StatusOK, jobs, nil
+}
+
+// adminRetryJob queues a finished job again. An upload's video is downloaded
+// from LINE by its message id, so this only works while LINE still keeps the
+// upload; adminReanalyzeWork works from storage instead.
+func (app *App) adminRetryJob(jobId string) (int, any, error) {
+	job, err := app.Db.GetJob(jobId)
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	return app.requeueJob(job)
+}
+
+// adminReanalyzeWork sends the stored upload of a work to the AI server
+// again, for works whose LINE upload is long gone. The result replaces the
+// work's video, thumbnail, rating and AI note, and the replaced files are
+// deleted; the student is not notified.
+func (app *App) adminReanalyzeWork(userId string, skill string, date string) (int, any, error) {
+	user, err := app.Db.GetUserData(userId)
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	work, ok := user.Portfolio.Skills[skill][date]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("no " + skill + " work on " + date)
+	}
+	if work.OriginalVideo == "" {
+		return http.StatusConflict, nil, errNoOriginalVideo
+	}
+
+	// one job per work, so a work is never analyzed twice at once
+	job := &db.Job{
+		Id:       "work_" + userId + "_" + skill + "_" + date,
+		UserId:   userId,
+		Skill:    skill,
+		WorkDate: date,
+	}
+	err = app.Db.CreateJob(job)
+	if errors.Is(err, db.ErrJobExists) {
+		if job, err = app.Db.GetJob(job.Id); err != nil {
+			return storeErrorStatus(err), nil, err
+		}
+		return app.requeueJob(job)
+	}
+	if err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	return app.queueAdminJob(job)
+}
+
+// requeueJob queues a finished job again.
+func (app *App) requeueJob(job *db.Job) (int, any, error) {
+	if job.Status == db.JobQueued || job.Status == db.JobRunning {
+		return http.StatusConflict, nil, errJobAlreadyQueued
+	}
+	if err := app.Db.UpdateJobStatus(job.Id, db.JobQueued, ""); err != nil {
+		return storeErrorStatus(err), nil, err
+	}
+	job.Status = db.JobQueued
+	job.Error = ""
+	return app.queueAdminJob(job)
+}
+
+func (app *App) queueAdminJob(job *db.Job) (int, any, error) {
+	if err := app.Jobs.Enqueue(job); err != nil {
+		app.Db.UpdateJobStatus(job.Id, db.JobFailed, err.Error())
+		return http.StatusServiceUnavailable, nil, err
+	}
+	app.InfoLogger.Println("\n\tAdmin queued job", job.Id)
+	return http.StatusAccepted, job, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// adminSendReminders sends the weekly reminders that are due and were not
+// sent yet, for a scheduler outside the bot since no instance may be running
+// when they are due. It answers how many students of each group got one.
+func (app *App) adminSendReminders() (int, any, error) {
+	return http.StatusOK, app.sendDueReminders(time.Now().In(app.Location)), nil
+}
@@ -2,325 +2,599 @@ package app
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/HeavenAQ/api/analysis"
 	"github.com/HeavenAQ/api/db"
-	"github.com/go-resty/resty/v2"
+	"github.com/HeavenAQ/api/drive"
+	"github.com/HeavenAQ/api/video"
+	"github.com/HeavenAQ/metrics"
+	"github.com/HeavenAQ/resilience"
+	"github.com/HeavenAQ/skill"
+	"github.com/HeavenAQ/workspace"
 	"github.com/line/line-bot-sdk-go/v7/linebot"
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
-	"google.golang.org/api/drive/v3"
 )
 
-type AnalyzedResult struct {
-	SkeletonVideo string   `json:"skeleton_video"`
-	Score         string   `json:"score"`
-	Suggestions   []string `json:"suggestions"`
+// videoFiles are the artifacts of one analysis, kept in the job's
+// workspace. processVideoJob removes the workspace once the job ends, however
+// it ends.
+type videoFiles struct {
+	// Original is the student's upload, from LINE or from storage for a
+	// re-analysis
+	Original string
+	// Resized is what is sent to the AI server
+	Resized string
+	// Skeleton is the analyzed video returned by the AI server
+	Skeleton  string
+	Thumbnail string
 }
 
-func downloadVideo(app App, event *linebot.Event) (io.Reader, error) {
-	app.InfoLogger.Println("\n\tDownloading video:")
-	blob, err := app.downloadVideo(event)
-	if err != nil {
-		return nil, err
+func newVideoFiles(ws *workspace.Workspace) *videoFiles {
+	return &videoFiles{
+		Original:  ws.Path("original.mp4"),
+		Resized:   ws.Path("resized.mp4"),
+		Skeleton:  ws.Path("skeleton.mp4"),
+		Thumbnail: ws.Path("thumbnail.jpeg"),
 	}
-	return blob, nil
 }
 
-func uploadVideoToDrive(app App, user *db.UserData, session *db.UserSession, skeletonVideo []byte, thumbnailPath string) (*drive.File, *drive.File, error) {
-	app.InfoLogger.Println("\n\tUploading video:")
-	folderId := app.getVideoFolder(user, session.Skill)
-	driveFile, thumbnailFile, err := app.Drive.UploadVideo(folderId, skeletonVideo, thumbnailPath)
-	if err != nil {
-		return nil, nil, err
+func removeWorkspace(app App, ws *workspace.Workspace) {
+	app.InfoLogger.Println("\n\tRemoving job workspace", ws.Dir(), "with artifacts", ws.Artifacts())
+	if err := ws.Remove(); err != nil {
+		app.WarnLogger.Println("\n\tFailed to remove job workspace:", err)
 	}
-	return driveFile, thumbnailFile, nil
 }
 
-func updateUserPortfolioVideo(app App, user *db.UserData, session *db.UserSession, driveFile *drive.File, thumbnailFile *drive.File, aiRating string, aiSuggestions []string) error {
-	app.InfoLogger.Println("\n\tUpdating user portfolio:")
-	userPortfolio := app.getUserPortfolio(user, session.Skill)
-	rating, err := strconv.ParseFloat(aiRating, 32)
-	if err != nil {
-		return err
-	}
+// errNoOriginalVideo is returned for works stored before uploads were kept;
+// their analyzed video is not sent back to the AI server.
+var errNoOriginalVideo = errors.New("work has no original video to analyze again")
 
-	for i, suggestion := range aiSuggestions {
-		aiSuggestions[i] = fmt.Sprintf("%d. %s", i+1, suggestion)
+// downloadVideo fetches the upload from LINE, or the work's original upload
+// from storage for a re-analysis.
+func downloadVideo(app App, user *db.UserData, job *db.Job, files *videoFiles) error {
+	if !job.Reanalysis() {
+		app.InfoLogger.Println("\n\tDownloading video:")
+		return app.downloadVideo(job.Id, files.Original)
 	}
 
-	// if no suggestions, add a default one
-	if len(aiSuggestions) == 0 {
-		aiSuggestions = []string{"動作標準，無須調整"}
+	work, ok := user.Portfolio.Skills[job.Skill][job.WorkDate]
+	if !ok {
+		return fmt.Errorf("no %v work on %v", job.Skill, job.WorkDate)
 	}
-
-	return app.Db.CreateUserPortfolioVideo(
-		user,
-		userPortfolio,
-		session,
-		driveFile,
-		thumbnailFile,
-		float32(rating),
-		strings.Join(aiSuggestions, "\n"),
-	)
+	if work.OriginalVideo == "" {
+		return errNoOriginalVideo
+	}
+	app.InfoLogger.Println("\n\tDownloading stored video", work.OriginalVideo)
+	return app.withRetry(storageEndpoint, func() error {
+		return app.Storage.DownloadVideo(work.OriginalVideo, files.Original)
+	})
 }
 
-func sendVideoUploadedReply(app App, event *linebot.Event, session *db.UserSession, user *db.UserData) error {
-	app.InfoLogger.Println("\n\tVideo uploaded successfully.")
-	_, err := app.Bot.SendVideoUploadedReply(
-		event.ReplyToken,
-		session.Skill,
-		app.getVideoFolder(user, session.Skill),
-	)
-
-	return err
+// uploadedFiles are the stored files of one analysis. Original is nil for a
+// re-analysis, whose work keeps its upload.
+type uploadedFiles struct {
+	Video     *drive.UploadedFile
+	Thumbnail *drive.UploadedFile
+	Original  *drive.UploadedFile
 }
 
-func createTmpVideoFile(app App, blob io.Reader, user *db.UserData) (string, error) {
-	filename := "/tmp/" + user.Id + ".mp4"
-	file, err := os.Create(filename)
+func uploadVideoToStorage(app App, user *db.UserData, job *db.Job, files *videoFiles) (*uploadedFiles, error) {
+	app.InfoLogger.Println("\n\tUploading video:")
+	folderId, err := app.getVideoFolder(user, job.Skill)
 	if err != nil {
-		return "", errors.New("failed to create tmp file for resizing")
+		return nil, err
 	}
-	defer file.Close()
-
-	// Stream the video directly to disk to avoid memory duplication
-	app.InfoLogger.Println("\n\tCopying video blob to disk")
-	if _, err := io.Copy(file, blob); err != nil {
-		return "", errors.New("failed to write video blob to disk")
+	uploaded := &uploadedFiles{}
+	err = app.withRetry(storageEndpoint, func() (err error) {
+		uploaded.Video, err = app.Storage.UploadVideo(folderId, files.Skeleton)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return filename, nil
+	err = app.withRetry(storageEndpoint, func() (err error) {
+		uploaded.Thumbnail, err = app.Storage.UploadThumbnail(uploaded.Video, files.Thumbnail)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if job.Reanalysis() {
+		return uploaded, nil
+	}
+	err = app.withRetry(storageEndpoint, func() (err error) {
+		uploaded.Original, err = app.Storage.UploadOriginal(folderId, uploaded.Video, files.Original)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploaded, nil
 }
 
-func rmTmpVideoFile(app App, filename string) {
-	app.InfoLogger.Println("\n\tRemoving tmp video file")
-	if err := os.Remove(filename); err != nil {
-		app.WarnLogger.Println("Failed to remove tmp video file:", err)
+// deleteReplacedFiles removes the files of a work that were replaced by a new
+// analysis. Failures only leave an unlinked file behind, so they are logged.
+func deleteReplacedFiles(app App, old db.Work, work db.Work) {
+	kept := map[string]bool{work.SkeletonVideo: true, work.Thumbnail: true, work.OriginalVideo: true}
+	for _, id := range []string{old.SkeletonVideo, old.Thumbnail, old.OriginalVideo} {
+		if id == "" || kept[id] {
+			continue
+		}
+		if err := app.Storage.DeleteFile(id); err != nil {
+			app.WarnLogger.Println("\n\tError deleting replaced file", id, ":", err)
+		}
 	}
 }
 
-func resizeVideo(app App, user *db.UserData, videoPath string) (string, error) {
-	// Use ffmpeg-go to resize the video
-	app.InfoLogger.Println("\n\tStart Resizing video:")
-	app.InfoLogger.Println("\n\tResizing video")
-	outputFilename := "/tmp/resized_" + user.Id + ".mp4"
-	err := ffmpeg_go.Input(videoPath).
-		Filter("scale", ffmpeg_go.Args{"1080:1920"}).
-		Output(outputFilename, ffmpeg_go.KwArgs{
-			"vsync":   "0",  // avoid audio sync issues
-			"threads": "1",  // use 1 thread to avoid memory issues
-			"b:v":     "1M", // set video bitrate to 1 Mbps
-			"an":      "",   // remove audio
-		}).
-		Run()
-	if err != nil {
-		return "", errors.New("failed to resize video")
+// aiNote numbers the suggestions of the AI server, one per line.
+func aiNote(app App, user *db.UserData, result *analysis.Result) string {
+	aiSuggestions := append([]string{}, result.Suggestions...)
+	for i, suggestion := range aiSuggestions {
+		aiSuggestions[i] = fmt.Sprintf("%d. %s", i+1, suggestion)
 	}
 
-	app.InfoLogger.Println("\n\tVideo resized successfully.")
-	return outputFilename, nil
+	// if no suggestions, add a default one
+	if len(aiSuggestions) == 0 {
+		aiSuggestions = []string{app.botFor(user).T("analysis.no_suggestion")}
+	}
+	return strings.Join(aiSuggestions, "\n")
 }
 
-func analyzeVideo(app App, resizedVideo string, user *db.UserData, session *db.UserSession) (*AnalyzedResult, error) {
-	app.InfoLogger.Println("\n\tAnalyzing video:")
-	// resize video to 1080 x 1920
-	resizedBlob, err := os.ReadFile(resizedVideo)
+// updateUserPortfolioVideo stores the result of a job and then deletes the
+// files of the work it replaced, if any.
+func updateUserPortfolioVideo(app App, user *db.UserData, job *db.Job, uploaded *uploadedFiles, result *analysis.Result) error {
+	app.InfoLogger.Println("\n\tUpdating user portfolio:")
+	userPortfolio := app.getUserPortfolio(user, job.Skill)
+	rating, err := strconv.ParseFloat(result.Score, 32)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	os.Remove(resizedVideo)
-
-	// set up request body with video data
-	app.InfoLogger.Println("\n\tSending video to AI server: " + os.Getenv("GENAI_URL"))
-	date := time.Now().Format("2006-01-02-15-04")
-	filename := user.Id + "_" + session.Skill + "_" + date + ".mp4"
-	baseURL := os.Getenv("GENAI_URL") + "/analyze"
-	client := resty.New()
-	client.SetTimeout(1 * time.Minute)
-
-	maxRetries := 6
-	delay := 10 * time.Second
-	var resp *resty.Response
-	for i := 0; i < maxRetries; i++ {
-		// send video to AI server
-		resp, err = client.R().
-			SetBasicAuth(os.Getenv("GENAI_USER"), os.Getenv("GENAI_PASSWORD")).
-			SetQueryParam("handedness", user.Handedness.String()).
-			SetQueryParam("skill", session.Skill).
-			SetFileReader("file", filename, bytes.NewReader(resizedBlob)).
-			Post(baseURL)
-
-		// if no error and status code is not 502, break the loop
-		if err == nil && resp.StatusCode() != 502 {
-			break
-			// if status code is 502 or 500, retry after 10 seconds
-		} else if resp != nil && (resp.StatusCode() == 502 || resp.StatusCode() == 500) {
-			app.WarnLogger.Println("AI Server is busy, retrying in 5 seconds")
-			time.Sleep(delay)
-			// if error is not nil, return error
-		} else if err != nil {
-			return nil, err
+	// a re-analysis replaces the result of the work, keeping its upload and
+	// what the student wrote about it
+	if job.Reanalysis() {
+		old, ok := (*userPortfolio)[job.WorkDate]
+		if !ok {
+			return fmt.Errorf("no %v work on %v", job.Skill, job.WorkDate)
+		}
+		work := old
+		work.SkeletonVideo = uploaded.Video.Id
+		work.Thumbnail = uploaded.Thumbnail.Id
+		work.Rating = float32(rating)
+		work.AINote = aiNote(app, user, result)
+		work.Analysis = result.WorkAnalysis()
+		if err := app.Db.UpdateUserPortfolioWork(user, userPortfolio, job.Skill, work); err != nil {
+			return err
 		}
+		deleteReplacedFiles(app, old, work)
+		return nil
 	}
 
-	// Check if we have a valid response
-	if err != nil {
-		return nil, err
+	// a retried upload replaces the work of its first analysis
+	var old *db.Work
+	for _, work := range *userPortfolio {
+		if work.SourceMessageId == job.Id {
+			old = &work
+			break
+		}
 	}
-	if resp == nil {
-		return nil, fmt.Errorf("failed to get response from AI server after %d retries", maxRetries)
+	err = app.Db.CreateUserPortfolioVideo(
+		user,
+		userPortfolio,
+		job.Skill,
+		job.Id,
+		uploaded.Video,
+		uploaded.Thumbnail,
+		uploaded.Original,
+		float32(rating),
+		aiNote(app, user, result),
+		result.WorkAnalysis(),
+	)
+	if err != nil {
+		return err
 	}
-	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("unexpected status code %d from AI server", resp.StatusCode())
+	if old != nil {
+		deleteReplacedFiles(app, *old, (*userPortfolio)[old.DateTime])
 	}
+	return nil
+}
 
-	// parse response to json
-	var result AnalyzedResult
-	err = json.Unmarshal(resp.Body(), &result)
+func sendVideoUploadedPush(app App, skill skill.Skill, user *db.UserData) error {
+	app.InfoLogger.Println("\n\tVideo uploaded successfully.")
+	return app.withRetry(lineEndpoint, func() error {
+		_, err := app.botFor(user).SendVideoUploadedPush(
+			user.Id,
+			skill,
+			user.FolderIds.Skills[skill.Id],
+		)
+		return err
+	})
+}
+
+// inspectVideo probes the download and plans its preprocessing. Videos
+// outside app.VideoLimits come back as a *video.RejectedError.
+func inspectVideo(app App, files *videoFiles) (*video.Profile, error) {
+	app.InfoLogger.Println("\n\tInspecting video:")
+	info, err := video.Probe(files.Original)
 	if err != nil {
-		app.ErrorLogger.Println("AI Server Response:\n" + string(resp.Body()))
 		return nil, err
 	}
-	return &result, nil
+	app.InfoLogger.Println(
+		"\n\tVideo info:",
+		"\n\t\t- Duration:", info.Duration,
+		"\n\t\t- Size:", info.Size,
+		"\n\t\t- Codec:", info.Codec,
+		"\n\t\t- Frame:", info.Width, "x", info.Height, "rotated", info.Rotation,
+		"\n\t\t- FPS:", info.FPS,
+	)
+	return video.Plan(info, app.VideoLimits)
 }
 
-func (app *App) createVideoThumbnail(event *linebot.Event, user *db.UserData, blob []byte) (string, error) {
-	// create a tmp file to store video blob
-	app.InfoLogger.Println("\n\tCreating a tmp file to store video blob ...")
-	replyToken := event.ReplyToken
-	filename := "/tmp/" + user.Id + ".mp4"
-	file, err := os.Create(filename)
+func resizeVideo(app App, files *videoFiles, profile *video.Profile) error {
+	// Use ffmpeg-go to resize the video
+	app.InfoLogger.Println("\n\tStart Resizing video:")
+	app.InfoLogger.Println("\n\tResizing video with filter", profile.Filter())
+	args := ffmpeg_go.KwArgs{
+		"vf":      profile.Filter(), // fit and pad instead of stretching
+		"vsync":   "0",              // avoid audio sync issues
+		"threads": "1",              // use 1 thread to avoid memory issues
+		"b:v":     "1M",             // set video bitrate to 1 Mbps
+		"an":      "",               // remove audio
+	}
+	if profile.Duration > 0 {
+		args["t"] = fmt.Sprintf("%.3f", profile.Duration.Seconds())
+	}
+	err := ffmpeg_go.Input(files.Original).
+		Output(files.Resized, args).
+		OverWriteOutput().
+		Run()
 	if err != nil {
-		app.ErrorLogger.Println("\n\tError creating tmp file for video:", err)
-		app.Bot.SendDefaultErrorReply(replyToken)
-		return "", err
+		return errors.New("failed to resize video")
 	}
-	defer file.Close()
 
-	// write video blob to the tmp file
-	app.InfoLogger.Println("\n\tWriting video blob to tmp file")
-	if _, err := io.Copy(file, bytes.NewReader(blob)); err != nil {
-		app.ErrorLogger.Println("\n\tError writing video blob to tmp file:", err)
-		app.Bot.SendDefaultErrorReply(replyToken)
-		return "", err
+	app.InfoLogger.Println("\n\tVideo resized successfully.")
+	return nil
+}
+
+func analyzeVideo(ctx context.Context, app App, files *videoFiles, user *db.UserData, skill skill.Skill) (*analysis.Result, error) {
+	app.InfoLogger.Println("\n\tAnalyzing video:")
+
+	// the model defaults to the one named after the skill
+	modelId := skill.ModelId
+	if modelId == "" {
+		modelId = skill.Id
 	}
 
+	date := time.Now().In(app.Location).Format("2006-01-02-15-04")
+	return app.Analyzer.Analyze(ctx, analysis.Request{
+		VideoPath:    files.Resized,
+		SkeletonPath: files.Skeleton,
+		Filename:     user.Id + "_" + skill.Id + "_" + date + ".mp4",
+		Handedness:   user.Handedness.String(),
+		Skill:        skill.Id,
+		Model:        modelId,
+	})
+}
+
+func createVideoThumbnail(app App, files *videoFiles) error {
 	// Using ffmpeg to create video thumbnail
 	app.InfoLogger.Println("Extracting thumbnail from the video")
-	outFileName := "/tmp/" + user.Id + ".jpeg"
-
 	var stderr bytes.Buffer
-	err = ffmpeg_go.Input(filename, ffmpeg_go.KwArgs{
+	err := ffmpeg_go.Input(files.Skeleton, ffmpeg_go.KwArgs{
 		"ss": "00:00:01", // place ss before input file to avoid seeking issues
 	}).
-		Output(outFileName, ffmpeg_go.KwArgs{
+		Output(files.Thumbnail, ffmpeg_go.KwArgs{
 			"vframes": 1,              // extract exactly 1 frame
 			"vcodec":  "mjpeg",        // make it a jpeg file
 			"vf":      "scale=320:-1", // scale the image to 320px width, keep aspect ratio
 		}).
+		OverWriteOutput().
 		WithErrorOutput(&stderr). // Capture stderr for debugging
 		Run()
 	if err != nil {
 		app.ErrorLogger.Println("\n\tError extracting thumbnail from video:", err)
 		app.ErrorLogger.Println("\n\tffmpeg stderr:", stderr.String())
-		return "", err
+		return err
 	}
+	return nil
+}
 
-	// Asynchronously remove the original file
-	go func() {
-		if err := os.Remove(filename); err != nil {
-			app.InfoLogger.Println("Failed to remove temp file:", err)
-		}
-	}()
-	return outFileName, nil
+// rejectJob ends a job whose video is outside the limits; the student is told
+// why instead of getting the default error.
+func rejectJob(app App, job *db.Job, rejected *video.RejectedError) {
+	app.WarnLogger.Println("\n\tRejecting job", job.Id, ":", rejected)
+	app.Jobs.Done(job.Id)
+	if err := app.Db.UpdateJobStatus(job.Id, db.JobFailed, rejected.Error()); err != nil {
+		app.ErrorLogger.Println("\n\tError updating job status:", err)
+	}
+	if job.Reanalysis() {
+		return
+	}
+	if _, err := app.botForId(job.UserId).SendVideoRejectedPush(job.UserId, rejected); err != nil {
+		app.WarnLogger.Println("\n\tError sending video rejected push:", err)
+	}
 }
 
-func uploadError(app App, event *linebot.Event, err error, message string) {
+func jobError(ctx context.Context, app App, job *db.Job, err error, message string) {
+	// the job was taken over by another instance or interrupted by shutdown,
+	// which report it
+	if ctx.Err() != nil {
+		app.WarnLogger.Println("\n\tJob", job.Id, "stopped:", err)
+		return
+	}
 	app.ErrorLogger.Println(message, err)
-	app.Bot.SendDefaultErrorReply(event.ReplyToken)
+	app.Jobs.Done(job.Id)
+	if err := app.Db.UpdateJobStatus(job.Id, db.JobFailed, err.Error()); err != nil {
+		app.ErrorLogger.Println("\n\tError updating job status:", err)
+	}
+	if !job.Reanalysis() {
+		app.botForId(job.UserId).SendDefaultErrorPush(job.UserId)
+	}
 }
 
+// resolveUploadVideo queues the upload for analysis and acknowledges it right
+// away; the result is pushed to the user by processVideoJob.
 func (app *App) resolveUploadVideo(event *linebot.Event, user *db.UserData, session *db.UserSession) {
-	blob, err := downloadVideo(*app, event)
+	job := &db.Job{
+		Id:     event.Message.(*linebot.VideoMessage).ID,
+		UserId: user.Id,
+		Skill:  session.Skill,
+	}
+
+	bot := app.botFor(user)
+	err := app.Db.CreateJob(job)
+	if errors.Is(err, db.ErrJobExists) {
+		app.WarnLogger.Println("\n\tVideo already queued for analysis:", job.Id)
+		return
+	}
+	if err != nil {
+		app.ErrorLogger.Println("\n\tError creating analysis job:", err)
+		app.replyError(user, event.ReplyToken, "upload")
+		return
+	}
+
+	// the job is stored as queued, so resumeJobs picks it up when the queue
+	// is full
+	if err := app.Jobs.Enqueue(job); err != nil {
+		app.WarnLogger.Println("\n\tCould not queue job", job.Id, ", leaving it to resumeJobs:", err)
+		if _, err := bot.SendReply(event.ReplyToken, bot.T("upload.queued")); err != nil {
+			app.WarnLogger.Println("\n\tError sending video queued reply:", err)
+		}
+		return
+	}
+
+	// let the student know right away when the AI server is down
+	if state, _ := app.Breakers.Get(genaiEndpoint).State(); state == resilience.Open {
+		if _, err := bot.SendVideoDelayedReply(event.ReplyToken); err != nil {
+			app.WarnLogger.Println("\n\tError sending video delayed reply:", err)
+		}
+		return
+	}
+	if _, err := bot.SendVideoProcessingReply(event.ReplyToken); err != nil {
+		app.WarnLogger.Println("\n\tError sending video processing reply:", err)
+	}
+}
+
+// sweepWorkspaces deletes the workspaces of jobs that were running when the
+// process last stopped; resumed jobs start over in a new one.
+func (app *App) sweepWorkspaces() {
+	removed, err := app.Workspaces.Sweep()
+	if err != nil {
+		app.WarnLogger.Println("\n\tError sweeping job workspaces:", err)
+	}
+	if len(removed) > 0 {
+		app.InfoLogger.Println("\n\tRemoved", len(removed), "orphaned job workspaces from", app.Workspaces.Dir())
+	}
+}
+
+// resumeJobs queues the jobs no instance is working on: queued ones and
+// running ones whose instance stopped renewing their lease. It runs at start
+// and then every minute, so jobs that found the queue full are retried.
+// Several instances may queue the same job, ClaimJob lets only one run it.
+func (app *App) resumeJobs() {
+	now := time.Now()
+	for _, status := range []db.JobStatus{db.JobRunning, db.JobQueued} {
+		jobs, err := app.Db.GetJobsByStatus(status)
+		if err != nil {
+			app.ErrorLogger.Println("\n\tError loading unfinished jobs:", err)
+			continue
+		}
+		for _, job := range jobs {
+			if job.Status == db.JobRunning && job.LeaseUntil.After(now) {
+				continue
+			}
+			err := app.Jobs.Enqueue(job)
+			if errors.Is(err, ErrJobPending) {
+				continue
+			}
+			if err != nil {
+				app.WarnLogger.Println("\n\tCould not resume job", job.Id, ", retrying in a minute:", err)
+				continue
+			}
+			app.InfoLogger.Println("\n\tResumed job", job.Id)
+		}
+	}
+}
+
+// renewJobLease keeps the lease of a running job until ctx is done, and
+// cancels the job once the lease is lost to another instance.
+func (app *App) renewJobLease(ctx context.Context, cancel context.CancelFunc, job *db.Job) {
+	ticker := time.NewTicker(app.Config.Jobs.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := app.Db.RenewJobLease(job.Id, app.InstanceId, app.Config.Jobs.Lease)
+		if errors.Is(err, db.ErrJobLost) {
+			app.WarnLogger.Println("\n\tLost the lease of job", job.Id)
+			cancel()
+			return
+		}
+		if err != nil {
+			app.WarnLogger.Println("\n\tError renewing job lease:", err)
+		}
+	}
+}
+
+// delayJob queues a job again later when the AI server is down. The student
+// is told once that the result will be late, and asked to upload again when
+// the server stays down for all JOB_MAX_DELAYS attempts.
+func (app *App) delayJob(job *db.Job, cause error) {
+	delay := app.Config.Jobs.RetryDelay
+	delays, err := app.Jobs.Delay(job, delay, app.Config.Jobs.MaxDelays)
+	if err != nil {
+		app.ErrorLogger.Println("\n\tAI server unavailable, giving up on job", job.Id, ":", cause)
+		if err := app.Db.UpdateJobStatus(job.Id, db.JobFailed, cause.Error()); err != nil {
+			app.ErrorLogger.Println("\n\tError updating job status:", err)
+		}
+		if !job.Reanalysis() {
+			app.botForId(job.UserId).SendAnalysisUnavailablePush(job.UserId)
+		}
+		return
+	}
+
+	app.WarnLogger.Println("\n\tAI server unavailable, retrying job", job.Id, "in", delay, ":", cause)
+	if err := app.Db.UpdateJobStatus(job.Id, db.JobRunning, cause.Error()); err != nil {
+		app.WarnLogger.Println("\n\tError updating job status:", err)
+	}
+	// hold the job through the delay so no other instance resumes it early
+	if err := app.Db.RenewJobLease(job.Id, app.InstanceId, delay+app.Config.Jobs.Lease); err != nil {
+		app.WarnLogger.Println("\n\tError renewing job lease:", err)
+	}
+	if delays == 1 && !job.Reanalysis() {
+		app.botForId(job.UserId).SendAnalysisDelayedPush(job.UserId)
+	}
+}
+
+func (app *App) processVideoJob(ctx context.Context, job *db.Job) {
+	// another instance may have resumed the job first
+	claimed, err := app.Db.ClaimJob(job.Id, app.InstanceId, app.Config.Jobs.Lease)
+	if err != nil {
+		app.ErrorLogger.Println("\n\tError claiming job", job.Id, ":", err)
+		return
+	}
+	if !claimed {
+		app.InfoLogger.Println("\n\tJob", job.Id, "is running on another instance")
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go app.renewJobLease(ctx, cancel, job)
+
+	app.InfoLogger.Println("\n\tProcessing job", job.Id, "for user", job.UserId)
+
+	user, err := app.Db.GetUserData(job.UserId)
 	if err != nil {
-		uploadError(*app, event, err, "\n\tError downloading video:")
-		app.resetUserSession(user.Id)
+		jobError(ctx, *app, job, err, "\n\tError getting user data:")
+		return
+	}
+
+	skill, ok := app.Skills.Get(job.Skill)
+	if !ok {
+		jobError(ctx, *app, job, errors.New("unknown skill "+job.Skill), "\n\tError getting skill:")
 		return
 	}
 
-	// create tmp video file
-	videoPath, err := createTmpVideoFile(*app, blob, user)
+	ws, err := app.Workspaces.New(job.Id)
 	if err != nil {
-		uploadError(*app, event, err, "\n\tError creating tmp video file:")
-		app.resetUserSession(user.Id)
+		jobError(ctx, *app, job, err, "\n\tError creating job workspace:")
 		return
 	}
+	defer removeWorkspace(*app, ws)
+	files := newVideoFiles(ws)
 
-	resizedVideoPath, err := resizeVideo(*app, user, videoPath)
+	// each stage is timed and its failures counted in metrics
+	err = metrics.Stage("download", func() error { return downloadVideo(*app, user, job, files) })
 	if err != nil {
-		uploadError(*app, event, err, "\n\tError resizing video:")
-		app.resetUserSession(user.Id)
+		jobError(ctx, *app, job, err, "\n\tError downloading video:")
 		return
 	}
 
-	// analyze video
-	result, err := analyzeVideo(*app, resizedVideoPath, user, session)
+	// check the video before anything is sent to the AI server
+	profile, err := inspectVideo(*app, files)
+	var rejected *video.RejectedError
+	if errors.As(err, &rejected) {
+		rejectJob(*app, job, rejected)
+		return
+	}
 	if err != nil {
-		uploadError(*app, event, err, "\n\tError analyzing video:")
-		app.resetUserSession(user.Id)
+		jobError(ctx, *app, job, err, "\n\tError inspecting video:")
 		return
 	}
 
-	// decode skeleton video
-	decodedVideo, err := base64.StdEncoding.DecodeString(result.SkeletonVideo)
+	err = metrics.Stage("resize", func() error { return resizeVideo(*app, files, profile) })
 	if err != nil {
-		uploadError(*app, event, err, "\n\tError decoding video:")
-		app.resetUserSession(user.Id)
+		jobError(ctx, *app, job, err, "\n\tError resizing video:")
+		return
+	}
+
+	// analyze video; the skeleton video is written to files.Skeleton
+	var result *analysis.Result
+	err = metrics.Stage("analyze", func() (err error) {
+		result, err = analyzeVideo(ctx, *app, files, user, skill)
+		return err
+	})
+	if resilience.IsUnavailable(err) && ctx.Err() == nil {
+		app.delayJob(job, err)
+		return
+	}
+	if err != nil {
+		jobError(ctx, *app, job, err, "\n\tError analyzing video:")
 		return
 	}
 
 	// create video thumbnail
-	thumbnailPath, err := app.createVideoThumbnail(event, user, decodedVideo)
+	err = metrics.Stage("thumbnail", func() error { return createVideoThumbnail(*app, files) })
 	if err != nil {
-		uploadError(*app, event, err, "\n\tError creating video thumbnail:")
-		app.resetUserSession(user.Id)
+		jobError(ctx, *app, job, err, "\n\tError creating video thumbnail:")
 		return
 	}
 
-	// upload video to google drive
-	driveFile, thumbnailFile, err := uploadVideoToDrive(*app, user, session, decodedVideo, thumbnailPath)
+	// upload video to storage
+	var uploaded *uploadedFiles
+	err = metrics.Stage("upload", func() (err error) {
+		uploaded, err = uploadVideoToStorage(*app, user, job, files)
+		return err
+	})
 	if err != nil {
-		uploadError(*app, event, err, "\n\tError uploading video:")
-		app.resetUserSession(user.Id)
+		jobError(ctx, *app, job, err, "\n\tError uploading video:")
 		return
 	}
 
-	// update user portfolio
-	if err := updateUserPortfolioVideo(*app, user, session, driveFile, thumbnailFile, result.Score, result.Suggestions); err != nil {
-		uploadError(*app, event, err, "\n\tError updating user portfolio:")
-		app.resetUserSession(user.Id)
+	// the instance that took the job over stores its own result, and an
+	// interrupted job was already reported by Shutdown
+	if err := ctx.Err(); err != nil || !app.Jobs.Commit(job.Id) {
+		app.WarnLogger.Println("\n\tJob", job.Id, "stopped before storing its result")
 		return
 	}
 
-	// send video uploaded reply
-	if err := sendVideoUploadedReply(*app, event, session, user); err != nil {
-		uploadError(*app, event, err, "\n\tError sending video uploaded reply:")
-		app.resetUserSession(user.Id)
+	// update user portfolio
+	err = metrics.Stage("persist", func() error {
+		return updateUserPortfolioVideo(*app, user, job, uploaded, result)
+	})
+	if err != nil {
+		jobError(ctx, *app, job, err, "\n\tError updating user portfolio:")
 		return
 	}
 
-	// reset user session
-	rmTmpVideoFile(*app, videoPath)
-	rmTmpVideoFile(*app, resizedVideoPath)
-	rmTmpVideoFile(*app, thumbnailPath)
-	app.resetUserSession(user.Id)
+	// send video uploaded push message
+	if !job.Reanalysis() {
+		if err := sendVideoUploadedPush(*app, skill, user); err != nil {
+			app.WarnLogger.Println("\n\tError sending video uploaded push:", err)
+		}
+	}
+
+	app.Jobs.Done(job.Id)
+	if err := app.Db.UpdateJobStatus(job.Id, db.JobSucceeded, ""); err != nil {
+		app.WarnLogger.Println("\n\tError updating job status:", err)
+	}
 }
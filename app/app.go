@@ -1,62 +1,305 @@
 package app
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 
+	"github.com/HeavenAQ/api/analysis"
 	"github.com/HeavenAQ/api/db"
 	"github.com/HeavenAQ/api/drive"
 	"github.com/HeavenAQ/api/line"
+	"github.com/HeavenAQ/api/secret"
+	"github.com/HeavenAQ/api/video"
+	"github.com/HeavenAQ/config"
+	"github.com/HeavenAQ/fsm"
+	"github.com/HeavenAQ/health"
+	"github.com/HeavenAQ/i18n"
+	"github.com/HeavenAQ/metrics"
+	"github.com/HeavenAQ/resilience"
+	"github.com/HeavenAQ/scheduler"
+	"github.com/HeavenAQ/skill"
+	"github.com/HeavenAQ/workspace"
 	"github.com/alexedwards/scs/v2"
 	"github.com/line/line-bot-sdk-go/v7/linebot"
 )
 
 type App struct {
-	Bot         *line.LineBotHandler
-	Drive       *drive.GoogleDriveHandler
-	Db          *db.FirebaseHandler
-	Session     *scs.SessionManager
+	Config       *config.Config
+	Secrets      *secret.Cached
+	Bot          *line.LineBotHandler
+	Storage      drive.Storage
+	Db           db.Store
+	Session      *scs.SessionManager
+	Jobs         *JobQueue
+	Conversation *fsm.Machine
+	Skills       *skill.Registry
+	Messages     *i18n.Catalog
+	Analyzer     analysis.Client
+	Breakers     *resilience.Breakers
+	Health       *health.Checker
+	Workspaces   *workspace.Root
+	Scheduler    *scheduler.Scheduler
+	VideoLimits  video.Limits
+	// InstanceId names this process in the leases of the jobs it runs
+	InstanceId string
+	// Location is TIME_ZONE, in which work dates are stamped and read
+	Location    *time.Location
+	reminders   *reminderSchedule
 	InfoLogger  *log.Logger
 	ErrorLogger *log.Logger
 	WarnLogger  *log.Logger
-	RootFolder  string
 }
 
-func NewApp() *App {
-	rootFolder := os.Getenv("GOOGLE_ROOT_FOLDER_ID")
+// NewApp connects to every service the bot depends on and fails when one of
+// them cannot be reached, so the bot never runs half configured. cfg must
+// have been validated.
+func NewApp(cfg *config.Config) (*App, error) {
 	infoLogger := log.New(log.Writer(), "[INFO] ", log.LstdFlags|log.Lshortfile)
 	errorLogger := log.New(log.Writer(), "[ERROR] ", log.LstdFlags|log.Lshortfile)
 	warnLogger := log.New(log.Writer(), "[WARN] ", log.LstdFlags|log.Lshortfile)
 
-	db, err := db.NewFirebaseHandler()
+	secrets, err := NewSecrets(cfg, warnLogger)
 	if err != nil {
-		errorLogger.Println("\n\tError initializing firebase database client:", err)
+		return nil, fmt.Errorf("failed to initialize secret provider: %v", err)
 	}
 
-	bot, err := line.NewLineBotHandler()
+	db, err := NewStore(cfg, secrets)
 	if err != nil {
-		errorLogger.Println("\n\tError initializing line bot client:", err)
+		return nil, fmt.Errorf("failed to initialize database client: %v", err)
 	}
 
-	drive, err := drive.NewGoogleDriveHandler()
+	skills, err := skill.Load(cfg.SkillsConfig)
 	if err != nil {
-		errorLogger.Println("\n\tError initializing google drive client:", err)
+		return nil, fmt.Errorf("failed to load skills config: %v", err)
+	}
+
+	loc, err := time.LoadLocation(cfg.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load time zone: %v", err)
+	}
+
+	storage, err := newVideoStorage(cfg, secrets, loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize video storage: %v", err)
+	}
+
+	messages, err := i18n.Load(cfg.LocalesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load locales: %v", err)
+	}
+
+	bot, err := line.NewLineBotHandler(cfg.Line.ChannelSecret, cfg.Line.ChannelToken, storage, skills, messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize line bot client: %v", err)
+	}
+
+	app := &App{
+		Config:       cfg,
+		Secrets:      secrets,
+		Bot:          bot,
+		Storage:      storage,
+		Db:           db,
+		InfoLogger:   infoLogger,
+		ErrorLogger:  errorLogger,
+		WarnLogger:   warnLogger,
+		Conversation: fsm.NewConversation(infoLogger),
+		Skills:       skills,
+		Messages:     messages,
+		Breakers:     newBreakers(cfg.Breaker, warnLogger),
+		VideoLimits:  cfg.VideoLimits(),
+		InstanceId:   newInstanceId(),
+		Location:     loc,
+	}
+	app.Analyzer = newAnalyzer(
+		cfg.Analysis,
+		genaiPolicy(cfg.Analysis, warnLogger),
+		app.Breakers.Get(genaiEndpoint),
+		infoLogger,
+	)
+
+	app.Health = newHealthChecker(cfg, app)
+	metrics.RegisterSessions(app.countSessions)
+
+	// clear what a crashed run left behind before any job starts
+	app.Workspaces, err = workspace.NewRoot(cfg.Jobs.WorkspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize job workspaces: %v", err)
+	}
+	app.sweepWorkspaces()
+
+	if err := app.startReminders(); err != nil {
+		return nil, fmt.Errorf("failed to schedule reminders: %v", err)
+	}
+
+	// start the video analysis workers and pick up unfinished jobs
+	app.Jobs = NewJobQueue(cfg.Jobs.Workers, cfg.Jobs.QueueSize, app.processVideoJob)
+	app.Jobs.Start()
+	app.resumeJobs()
+	// every instance rescans the jobs each minute while it runs, ClaimJob
+	// keeps a job from running twice. With no instance running, queued jobs
+	// wait for the next one to start.
+	err = app.Scheduler.Add("resume jobs", "* * * * *", func(now time.Time) {
+		app.resumeJobs()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule job resumption: %v", err)
 	}
 
 	infoLogger.Println("\n\tApp initialized successfully.")
-	return &App{
-		Bot:         bot,
-		Drive:       drive,
-		Db:          db,
-		RootFolder:  rootFolder,
-		InfoLogger:  infoLogger,
-		ErrorLogger: errorLogger,
-		WarnLogger:  warnLogger,
+	return app, nil
+}
+
+// newInstanceId tells apart the instances sharing the jobs, and the runs of
+// one instance.
+func newInstanceId() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// NewSecrets selects where credentials are read from, as set by
+// SECRETS_BACKEND, and caches them for SECRETS_CACHE_TTL. Stale secrets
+// served while the provider is down are logged to logger.
+func NewSecrets(cfg *config.Config, logger *log.Logger) (*secret.Cached, error) {
+	var provider secret.Provider
+	switch cfg.Secrets.Backend {
+	case "gcp":
+		provider = secret.NewSecretManager(cfg.GCPProjectID)
+	case "env":
+		provider = secret.Env{}
+	case "file":
+		provider = secret.Files{Dir: cfg.Secrets.Dir}
+	default:
+		return nil, errors.New("unknown secrets backend: " + cfg.Secrets.Backend)
+	}
+	return secret.NewCached(provider, cfg.Secrets.CacheTTL, logger), nil
+}
+
+// Shutdown stops the reminders and the analysis workers, waiting for the
+// running analyses until ctx is done. Jobs that were not started are left
+// queued for resumeJobs. Analyses still running are cancelled, marked failed
+// and their students are asked to upload again.
+func (app *App) Shutdown(ctx context.Context) {
+	app.Scheduler.Stop()
+	notStarted, interrupted := app.Jobs.Stop(ctx)
+	for _, job := range notStarted {
+		// delayed jobs are still held by this instance's lease
+		if err := app.Db.UpdateJobStatus(job.Id, db.JobQueued, ""); err != nil {
+			app.ErrorLogger.Println("\n\tError updating job status:", err)
+		}
+	}
+	for _, job := range interrupted {
+		app.WarnLogger.Println("\n\tAnalysis interrupted by shutdown:", job.Id)
+		if err := app.Db.UpdateJobStatus(job.Id, db.JobFailed, "interrupted by shutdown"); err != nil {
+			app.ErrorLogger.Println("\n\tError updating job status:", err)
+		}
+		if job.Reanalysis() {
+			continue
+		}
+		if _, err := app.botForId(job.UserId).SendAnalysisInterruptedPush(job.UserId); err != nil {
+			app.ErrorLogger.Println("\n\tError sending analysis interrupted push:", err)
+		}
+	}
+	app.InfoLogger.Println("\n\tApp stopped,", len(notStarted), "analyses left queued,", len(interrupted), "interrupted.")
+}
+
+// newHealthChecker probes the services in use for /readyz. The in-process
+// store and the fake analyzer have nothing to probe.
+func newHealthChecker(cfg *config.Config, app *App) *health.Checker {
+	checker := health.NewChecker(cfg.Server.ProbeTimeout)
+	checker.Add("line", app.Bot)
+	if pinger, ok := app.Db.(health.Pinger); ok {
+		checker.Add("database", pinger)
+	}
+	if pinger, ok := app.Storage.(health.Pinger); ok {
+		checker.Add("storage", pinger)
+	}
+	if pinger, ok := app.Analyzer.(health.Pinger); ok {
+		checker.Add("analysis", pinger)
+	}
+	return checker
+}
+
+// countSessions counts the sessions in each state, states without sessions
+// included so they are exported as 0.
+func (app *App) countSessions() (map[string]int, error) {
+	counts, err := app.Db.CountSessionsByState()
+	if err != nil {
+		return nil, err
+	}
+	named := map[string]int{}
+	for state := db.WritingReflection; state <= db.SelectingExpertSkill; state++ {
+		named[state.String()] = counts[state]
+	}
+	return named, nil
+}
+
+// NewStore selects the persistence backend set by DB_BACKEND.
+func NewStore(cfg *config.Config, secrets secret.Provider) (db.Store, error) {
+	switch cfg.Database.Backend {
+	case "firebase":
+		return db.NewFirebaseHandler(
+			cfg.Firebase.ProjectID,
+			secrets,
+			cfg.Firebase.Credentials,
+			db.FirebaseCollections{
+				Users:         cfg.Firebase.Users,
+				Sessions:      cfg.Firebase.Sessions,
+				Jobs:          cfg.Firebase.Jobs,
+				WebhookEvents: cfg.Firebase.WebhookEvents,
+			},
+		)
+	case "sqlite", "postgres":
+		return db.NewSQLHandler(cfg.Database.Backend, cfg.Database.DSN)
+	case "memory":
+		return db.NewMemoryHandler(), nil
+	default:
+		return nil, errors.New("unknown database backend: " + cfg.Database.Backend)
+	}
+}
+
+// newAnalyzer selects the AI server client. ANALYSIS_BACKEND=fake answers
+// every upload locally for offline runs.
+func newAnalyzer(cfg config.Analysis, policy resilience.Policy, breaker *resilience.Breaker, logger *log.Logger) analysis.Client {
+	if cfg.Backend == "fake" {
+		logger.Println("\n\tUsing fake video analysis")
+		return analysis.NewFakeClient()
+	}
+	return analysis.NewHTTPClient(
+		cfg.URL,
+		cfg.User,
+		cfg.Password,
+		policy,
+		breaker,
+		logger,
+	)
+}
+
+// newVideoStorage selects where analyzed videos are kept, as set by
+// STORAGE_BACKEND.
+func newVideoStorage(cfg *config.Config, secrets secret.Provider, loc *time.Location) (drive.Storage, error) {
+	switch cfg.Storage.Backend {
+	case "drive":
+		return drive.NewGoogleDriveHandler(
+			secrets,
+			cfg.Drive.Credentials,
+			cfg.Drive.RootFolderID,
+			cfg.Drive.ThumbnailFolderID,
+			loc,
+		)
+	case "local":
+		return drive.NewLocalStorageHandler(cfg.Storage.LocalDir, cfg.PublicBaseURL, cfg.Storage.SigningKey, loc)
+	default:
+		return nil, errors.New("unknown storage backend: " + cfg.Storage.Backend)
 	}
 }
 
@@ -65,50 +308,156 @@ func (app *App) HandleCallback(w http.ResponseWriter, req *http.Request) {
 	events, err := app.Bot.RetrieveCbEvent(w, req)
 	if err != nil {
 		app.ErrorLogger.Println("\n\tError retrieving callback event:", err)
+		metrics.HandlerErrors.WithLabelValues("callback").Inc()
 		return
 	}
 
 	// handle events
 	for _, event := range events {
-		// get user
-		user := app.createUserIfNotExist(event.Source.UserID)
-		session := app.createUserSessionIfNotExist(event.Source.UserID)
-		app.InfoLogger.Println(
-			"\n\tIncoming event:", event.Type,
-			"\n\t\t- User (", user.Id, ")",
-			"\n\t\t- Session: ", session,
-		)
+		metrics.WebhookEvents.WithLabelValues(string(event.Type)).Inc()
+
+		// skip events that were already handled, or are being handled, before
+		// LINE redelivered them
+		if !app.claimEvent(event) {
+			continue
+		}
+		app.handleEvent(event)
+		app.completeEvent(event)
+	}
+}
+
+func (app *App) handleEvent(event *linebot.Event) {
+	// get user
+	user, err := app.createUserIfNotExist(event.Source.UserID)
+	if err != nil {
+		app.ErrorLogger.Println("\n\tError loading user:", err)
+		app.replyError(nil, event.ReplyToken, "user")
+		return
+	}
+	session := app.createUserSessionIfNotExist(event.Source.UserID)
+	if session == nil {
+		app.replyError(user, event.ReplyToken, "session")
+		return
+	}
+	app.InfoLogger.Println(
+		"\n\tIncoming event:", event.Type,
+		"\n\t\t- User (", user.Id, ")",
+		"\n\t\t- Session: ", session,
+	)
 
-		// handler event
-		switch event.Type {
-		case linebot.EventTypeFollow:
-			app.Bot.SendWelcomeReply(event)
-		case linebot.EventTypeMessage:
-			app.handleMessageEvent(event, user, session)
-		case linebot.EventTypePostback:
-			app.handlePostbackEvent(event, user, session)
-		default:
-			app.WarnLogger.Println("\n\tUnknown event type: ", event.Type)
-			app.Bot.SendDefaultReply(event.ReplyToken)
+	// handler event
+	bot := app.botFor(user)
+	switch event.Type {
+	case linebot.EventTypeFollow:
+		bot.SendWelcomeReply(event)
+	case linebot.EventTypeMessage:
+		app.handleMessageEvent(event, user, session)
+	case linebot.EventTypePostback:
+		app.handlePostbackEvent(event, user, session)
+	default:
+		app.WarnLogger.Println("\n\tUnknown event type: ", event.Type)
+		bot.SendDefaultReply(event.ReplyToken)
+	}
+}
 
+// eventKey identifies an event across redeliveries, empty when it cannot be
+// told apart.
+func eventKey(event *linebot.Event) string {
+	if event.WebhookEventID != "" {
+		return event.WebhookEventID
+	}
+	if event.Message != nil {
+		if id := messageId(event.Message); id != "" {
+			return "message-" + id
 		}
 	}
+	return ""
+}
+
+// claimEvent records the event in the store for WEBHOOK_CLAIM_LEASE and
+// reports whether it is neither handled nor being handled. An instance that
+// dies while handling it lets the claim expire, so LINE's redelivery is
+// handled. Events are processed anyway when the store is down so that an
+// outage does not drop messages.
+func (app *App) claimEvent(event *linebot.Event) bool {
+	eventId := eventKey(event)
+	if eventId == "" {
+		return true
+	}
+
+	claimed, err := app.Db.ClaimWebhookEvent(eventId, app.Config.WebhookClaimLease)
+	if err != nil {
+		app.WarnLogger.Println("\n\tError recording webhook event", eventId, ":", err)
+		return true
+	}
+	if !claimed {
+		app.WarnLogger.Println(
+			"\n\tSkipping duplicate webhook event", eventId,
+			"\n\t\t- Redelivery:", event.DeliveryContext.IsRedelivery,
+		)
+	}
+	return claimed
+}
+
+// completeEvent keeps the record of a handled event for WEBHOOK_DEDUP_TTL.
+func (app *App) completeEvent(event *linebot.Event) {
+	eventId := eventKey(event)
+	if eventId == "" {
+		return
+	}
+	if err := app.Db.CompleteWebhookEvent(eventId, app.Config.WebhookDedupTTL); err != nil {
+		app.WarnLogger.Println("\n\tError recording handled webhook event", eventId, ":", err)
+	}
+}
+
+func messageId(message linebot.Message) string {
+	switch msg := message.(type) {
+	case *linebot.TextMessage:
+		return msg.ID
+	case *linebot.VideoMessage:
+		return msg.ID
+	case *linebot.ImageMessage:
+		return msg.ID
+	case *linebot.AudioMessage:
+		return msg.ID
+	default:
+		return ""
+	}
 }
 
 func (app *App) handleMessageEvent(event *linebot.Event, user *db.UserData, session *db.UserSession) {
+	bot := app.botFor(user)
+
+	// teachers may verify themselves and anyone may change the language
+	// before entering a test number
+	if msg, ok := event.Message.(*linebot.TextMessage); ok && !writingText(session) {
+		switch command, arg := app.Messages.ParseCommand(msg.Text); command {
+		case verifyTeacherCommand:
+			app.handleClassCommand(event, user, session, command, arg)
+			return
+		case languageCommand:
+			app.handleLanguageCommand(event, user, session, command, arg)
+			return
+		}
+	}
+
 	if user.TestNumber == -1 {
 		// Convert the message containing users's test number to an integer
-		msg := event.Message.(*linebot.TextMessage).Text
-		number, err := strconv.Atoi(msg)
+		msg, _ := event.Message.(*linebot.TextMessage)
+		if msg == nil {
+			bot.SendReply(event.ReplyToken, bot.T("test_number.prompt"))
+			return
+		}
+		number, err := strconv.Atoi(msg.Text)
 		if err != nil {
 			app.WarnLogger.Println("\n\tInvalid test number")
-			app.Bot.SendReply(event.ReplyToken, "請於輸入測試編號（2碼）後開始使用！")
+			bot.SendReply(event.ReplyToken, bot.T("test_number.prompt"))
 			return
 		}
 
 		// Update the user's test number
 		app.Db.UpdateUserTestNumber(user, number)
-		app.Bot.SendReply(event.ReplyToken, "測試編號已設定為"+strconv.Itoa(number))
+		bot.SendReply(event.ReplyToken, bot.T("test_number.set", number))
 		return
 	}
 
@@ -116,191 +465,263 @@ func (app *App) handleMessageEvent(event *linebot.Event, user *db.UserData, sess
 	case *linebot.TextMessage:
 		app.handleTextMessage(event, user, session)
 	case *linebot.VideoMessage:
-		if session.UserState == db.UploadingVideo {
+		if _, ok := app.transition(event.ReplyToken, user, session, fsm.Input{Event: fsm.EventVideo}); ok {
 			app.resolveUploadVideo(event, user, session)
-		} else {
-			app.WarnLogger.Println("\n\tUnknown message type: ", event.Message.Type())
-			app.Bot.SendDefaultErrorReply(event.ReplyToken)
 		}
 	default:
 		app.WarnLogger.Println("\n\tUnknown message type: ", event.Message.Type())
-		app.Bot.SendDefaultReply(event.ReplyToken)
+		bot.SendReply(event.ReplyToken, bot.T(app.Conversation.Hint(session.UserState)))
+	}
+}
+
+// transition moves the session through the conversation state machine and
+// persists the result. Inputs that the current step does not accept are
+// answered with a hint and ok is false. The session passed in is left as it
+// was so callers can still read the previous step.
+func (app *App) transition(replyToken string, user *db.UserData, session *db.UserSession, input fsm.Input) (*db.UserSession, bool) {
+	next, err := app.Conversation.Fire(user.Id, *session, input)
+	var invalid *fsm.InvalidInputError
+	if errors.As(err, &invalid) {
+		bot := app.botFor(user)
+		bot.SendReply(replyToken, bot.T(invalid.Hint))
+		return nil, false
 	}
+
+	if next != *session {
+		if err := app.Db.UpdateUserSession(user.Id, next); err != nil {
+			app.ErrorLogger.Println("\n\tError updating user session:", err)
+			app.replyError(user, replyToken, "session")
+			return nil, false
+		}
+	}
+	return &next, true
+}
+
+// rich menu commands, matched in every language by Messages.ParseCommand
+const (
+	instructionCommand   = "instruction"
+	portfolioCommand     = "portfolio"
+	syllabusCommand      = "syllabus"
+	expertVideoCommand   = "expert_video"
+	analyzeVideoCommand  = "analyze_video"
+	reflectionCommand    = "reflection"
+	previewNoteCommand   = "preview_note"
+	progressTrendCommand = "progress_trend"
+)
+
+// menuCommands maps the rich menu items to the events they fire.
+var menuCommands = map[string]fsm.Event{
+	instructionCommand:   fsm.EventMenu,
+	portfolioCommand:     fsm.EventMenu,
+	syllabusCommand:      fsm.EventMenu,
+	expertVideoCommand:   fsm.EventExpertCommand,
+	analyzeVideoCommand:  fsm.EventAnalyzeCommand,
+	reflectionCommand:    fsm.EventReflectionCommand,
+	previewNoteCommand:   fsm.EventPreviewCommand,
+	progressTrendCommand: fsm.EventMenu,
+}
+
+// writingText reports whether the session expects a reflection or preview
+// note. Only the menu commands, which the rich menu sends without an
+// argument, are taken as commands then; any other text is what the student
+// wrote, even when it starts with a command such as "Language".
+func writingText(session *db.UserSession) bool {
+	return session.UserState == db.WritingReflection || session.UserState == db.WritingPreviewNote
 }
 
 func (app *App) handleTextMessage(event *linebot.Event, user *db.UserData, session *db.UserSession) {
 	replyToken := event.ReplyToken
-	switch event.Message.(*linebot.TextMessage).Text {
-	case "使用說明":
-		app.resetUserSession(user.Id)
-		res, err := app.Bot.SendInstruction(replyToken)
+	command, arg := app.Messages.ParseCommand(event.Message.(*linebot.TextMessage).Text)
+
+	// menu items take no argument, so a reflection starting with one of
+	// them is still saved as text
+	menuEvent, isMenu := menuCommands[command]
+	if writingText(session) && (!isMenu || arg != "") {
+		app.resolveText(event, user, session)
+		return
+	}
+	if !isMenu || arg != "" {
+		if !app.handleClassCommand(event, user, session, command, arg) &&
+			!app.handleReminderCommand(event, user, session, command, arg) &&
+			!app.handleLanguageCommand(event, user, session, command, arg) {
+			app.resolveText(event, user, session)
+		}
+		return
+	}
+	if _, ok := app.transition(replyToken, user, session, fsm.Input{Event: menuEvent}); !ok {
+		return
+	}
+
+	bot := app.botFor(user)
+	switch command {
+	case instructionCommand:
+		res, err := bot.SendInstruction(replyToken)
 		if err != nil {
 			app.WarnLogger.Println("\n\tError sending instruction: ", err)
 		}
 		app.InfoLogger.Println("\n\tInstruction sent. Response from line: ", res)
-	case "學習歷程":
-		app.resetUserSession(user.Id)
-		app.Bot.PromptSkillSelection(replyToken, line.ViewPortfolio, "請選擇要查看的學習歷程")
-	case "專家影片":
-		app.resetUserSession(user.Id)
-		_, err := app.Bot.PromptHandednessSelection(replyToken)
-		if err != nil {
-			app.ErrorLogger.Println("\n\tError prompting handedness selection: ", err)
-		}
-	case "分析影片":
-		app.resetUserSession(user.Id)
-		_, err := app.Bot.PromptHandednessSelection(replyToken)
+	case portfolioCommand:
+		bot.PromptSkillSelection(replyToken, line.ViewPortfolio, bot.T("prompt.portfolio_skill"))
+	case expertVideoCommand, analyzeVideoCommand:
+		_, err := bot.PromptHandednessSelection(replyToken)
 		if err != nil {
 			app.ErrorLogger.Println("\n\tError prompting handedness selection: ", err)
 		}
-		app.updateUserState(user.Id, db.UploadingVideo)
-	case "本週學習反思":
-		app.resetUserSession(user.Id)
-		go app.Bot.PromptSkillSelection(replyToken, line.AddReflection, "請選擇要新增學習反思的動作")
-		app.updateUserState(user.Id, db.WritingReflection)
-	case "課前動作檢測":
-		app.resetUserSession(user.Id)
-		go app.Bot.PromptSkillSelection(replyToken, line.AddPreviewNote, "請選擇要新增課前檢視要點的動作")
-		app.updateUserState(user.Id, db.WritingPreviewNote)
-	case "課程大綱":
-		app.resetUserSession(user.Id)
-		res, err := app.Bot.SendSyllabus(replyToken)
+	case reflectionCommand:
+		bot.PromptSkillSelection(replyToken, line.AddReflection, bot.T("prompt.reflection_skill"))
+	case previewNoteCommand:
+		bot.PromptSkillSelection(replyToken, line.AddPreviewNote, bot.T("prompt.preview_note_skill"))
+	case progressTrendCommand:
+		app.resolveProgressTrend(replyToken, user)
+	case syllabusCommand:
+		res, err := bot.SendSyllabus(replyToken)
 		if err != nil {
 			app.WarnLogger.Println("\n\tError sending syllabus: ", err)
 		}
 		app.InfoLogger.Println("\n\tSyllabus sent. Response from line: ", res)
-	default:
-		isWritingReflection := session.UserState == db.WritingReflection
-		isWritingPreviewNote := session.UserState == db.WritingPreviewNote
-		if isWritingReflection {
-			app.resolveWritingReflection(event, user, session)
-		} else if isWritingPreviewNote {
-			app.resolveWritingPreviewNote(event, user, session)
-		} else {
-			app.Bot.SendDefaultReply(replyToken)
-		}
+	}
+}
+
+// resolveText handles free text, which is only expected while writing a
+// reflection or a preview note.
+func (app *App) resolveText(event *linebot.Event, user *db.UserData, session *db.UserSession) {
+	if _, ok := app.transition(event.ReplyToken, user, session, fsm.Input{Event: fsm.EventText}); !ok {
+		return
+	}
+
+	var err error
+	switch session.UserState {
+	case db.WritingReflection:
+		err = app.resolveWritingReflection(event, user, session)
+	case db.WritingPreviewNote:
+		err = app.resolveWritingPreviewNote(event, user, session)
+	}
+	if err != nil {
+		app.ErrorLogger.Println("\n\tError saving text:", err)
+		app.replyError(user, event.ReplyToken, "text")
 	}
 }
 
 func (app *App) handlePostbackEvent(event *linebot.Event, user *db.UserData, session *db.UserSession) {
 	app.InfoLogger.Println("\n\tPostback event:", event.Postback.Data)
 	replyToken := event.ReplyToken
-	tmp := strings.Split(event.Postback.Data, "&")
-	var data [2][2]string
-	for i, t := range tmp {
-		data[i] = [2]string(strings.SplitN(t, "=", 2))
-	}
-
-	if len(data) == 0 {
-		app.WarnLogger.Println("\n\tEmpty postback data")
-	} else if data[0][0] == "video" {
-		var video line.VideoInfo
-		json.Unmarshal([]byte(data[0][1]), &video)
-		app.Bot.SendVideoMessage(replyToken, video)
-	} else if data[0][0] == "handedness" {
-		app.handleHandednessReply(replyToken, user, data[0][1], session)
-	} else if data[1][0] == "date" {
-		app.handleDateReply(data[1][1], replyToken, user, session)
-	} else {
-		app.handleUserAction(event, user, data)
+	bot := app.botFor(user)
+	postback, err := line.DecodePostback(event.Postback.Data)
+	if err != nil {
+		app.WarnLogger.Println("\n\tInvalid postback data:", err)
+		app.replyError(user, replyToken, "postback")
+		return
+	}
+
+	switch data := postback.(type) {
+	case *line.VideoViewPostback:
+		if _, ok := app.transition(replyToken, user, session, fsm.Input{Event: fsm.EventViewVideo}); !ok {
+			return
+		}
+		bot.SendVideoMessage(replyToken, data)
+	case *line.HandednessPostback:
+		app.handleHandednessReply(replyToken, user, data.Handedness, session)
+	case *line.DateSelectionPostback:
+		app.handleDateReply(data.Type, data.Date, replyToken, user, session)
+	case *line.UserActionPostback:
+		app.handleUserAction(event, user, session, *data)
+	case *line.StudentPortfolioPostback:
+		if err := app.resolveViewStudentPortfolio(event, user, session, data); err != nil {
+			app.ErrorLogger.Println("\n\tError resolving student portfolio:", err)
+			app.replyError(user, replyToken, "portfolio")
+		}
+	default:
+		app.WarnLogger.Println("\n\tUnhandled postback kind:", postback.Kind())
+		app.replyError(user, replyToken, "postback")
 	}
 }
 
-func (app *App) handleDateReply(date string, replyToken string, user *db.UserData, session *db.UserSession) {
-	app.Db.UpdateUserSession(user.Id, db.UserSession{
-		UserState:    session.UserState,
-		UpdatingDate: date,
-		Skill:        session.Skill,
-	})
+func (app *App) handleDateReply(action line.Action, date string, replyToken string, user *db.UserData, session *db.UserSession) {
+	next, ok := app.transition(replyToken, user, session, fsm.Input{Event: fsm.EventDate, Action: action, Date: date})
+	if !ok {
+		return
+	}
 
-	msg := "請輸入【" + date + "】的【" + line.SkillStrToEnum(session.Skill).ChnString() + "】的"
-	if session.UserState == db.WritingPreviewNote {
-		msg += "課前檢視要點"
-	} else {
-		msg += "學習反思"
+	bot := app.botFor(user)
+	skill, _ := app.Skills.Get(next.Skill)
+	key := "prompt.reflection_date"
+	if next.UserState == db.WritingPreviewNote {
+		key = "prompt.preview_note_date"
 	}
-	app.Bot.SendReply(replyToken, msg)
+	bot.SendReply(replyToken, bot.T(key, date, skill.Label(bot.Lang())))
 }
 
-func (app *App) handleHandednessReply(replyToken string, user *db.UserData, data string, session *db.UserSession) {
-	handedness, err := db.HandednessStrToEnum(data)
-	if err != nil {
-		app.WarnLogger.Println("\n\tInvalid handedness data")
-		app.Bot.SendReply(replyToken, "請選擇左手或右手")
+func (app *App) handleHandednessReply(replyToken string, user *db.UserData, handedness db.Handedness, session *db.UserSession) {
+	next, ok := app.transition(replyToken, user, session, fsm.Input{Event: fsm.EventHandedness})
+	if !ok {
 		return
 	}
 
+	bot := app.botFor(user)
 	if user.Handedness != handedness {
-		err = app.Db.UpdateUserHandedness(user, handedness)
+		err := app.Db.UpdateUserHandedness(user, handedness)
 		if err != nil {
 			app.WarnLogger.Println("\n\tError updating user handedness:", err)
-			app.Bot.SendDefaultErrorReply(replyToken)
+			app.replyError(user, replyToken, "handedness")
 			return
 		}
 	}
 
 	// check line action
-	if session.UserState == db.UploadingVideo {
-		app.Bot.PromptSkillSelection(replyToken, line.AnalyzeVideo, "請選擇要分析的動作")
+	if next.UserState == db.SelectingAnalyzeSkill {
+		bot.PromptSkillSelection(replyToken, line.AnalyzeVideo, bot.T("prompt.analyze_skill"))
 	} else {
-		app.Bot.PromptSkillSelection(replyToken, line.ViewExpertVideo, "請選擇要觀看的動作")
+		bot.PromptSkillSelection(replyToken, line.ViewExpertVideo, bot.T("prompt.expert_skill"))
 	}
 }
 
-func (app *App) handleUserAction(event *linebot.Event, user *db.UserData, data [2][2]string) {
+func (app *App) handleUserAction(event *linebot.Event, user *db.UserData, session *db.UserSession, userAction line.UserActionPostback) {
 	replyToken := event.ReplyToken
-	var userAction line.UserActionPostback
-	err := userAction.FromArray(data)
-	if err != nil {
-		app.WarnLogger.Println("\n\tInvalid postback data")
-		app.Bot.SendDefaultErrorReply(replyToken)
-		return
-	}
-	err = app.ResolveUserAction(event, user, userAction)
+	err := app.ResolveUserAction(event, user, session, userAction)
 	if err != nil {
 		app.ErrorLogger.Println("\n\tError resolving user action:", err)
-		app.Bot.SendDefaultErrorReply(replyToken)
+		app.replyError(user, replyToken, "user_action")
 		return
 	}
 }
 
-func (app *App) ResolveUserAction(event *linebot.Event, user *db.UserData, action line.UserActionPostback) error {
+func (app *App) ResolveUserAction(event *linebot.Event, user *db.UserData, session *db.UserSession, action line.UserActionPostback) error {
+	skill, ok := app.Skills.Get(action.Skill)
+	if !ok {
+		return errors.New("\n\tUnknown skill: " + action.Skill)
+	}
+
+	input := fsm.Input{Event: fsm.EventSkill, Action: action.Type, Skill: skill.Id}
+	if _, ok := app.transition(event.ReplyToken, user, session, input); !ok {
+		return nil
+	}
+
+	bot := app.botFor(user)
 	switch action.Type {
 	case line.AddReflection, line.AddPreviewNote:
-		// update user session
 		var userState db.UserState
 		if action.Type == line.AddReflection {
 			userState = db.WritingReflection
 		} else {
 			userState = db.WritingPreviewNote
 		}
-		go app.Db.UpdateUserSession(user.Id, db.UserSession{
-			UserState: userState,
-			Skill:     action.Skill.String(),
-		})
 
-		err := app.Bot.ResolveViewPortfolio(event, user, action.Skill, userState)
+		err := bot.ResolveViewPortfolio(event, user, skill, userState)
 		if err != nil {
 			return errors.New("\n\tError resolving view portfolio: " + err.Error())
 		}
 	case line.ViewPortfolio:
-		err := app.Bot.ResolveViewPortfolio(event, user, action.Skill, db.None)
+		err := bot.ResolveViewPortfolio(event, user, skill, db.None)
 		if err != nil {
 			return errors.New("\n\tError resolving view portfolio: " + err.Error())
 		}
 	case line.ViewExpertVideo:
-		err := app.Bot.ResolveViewExpertVideo(event, user, action.Skill)
+		err := bot.ResolveViewExpertVideo(event, user, skill)
 		if err != nil {
 			return errors.New("\n\tError resolving view expert video: " + err.Error())
 		}
 	case line.AnalyzeVideo:
-		// update user session
-		go app.Db.UpdateUserSession(user.Id, db.UserSession{
-			UserState: db.UploadingVideo,
-			Skill:     action.Skill.String(),
-		})
-
-		err := app.Bot.PromptUploadVideo(event, user, action.Skill)
+		err := bot.PromptUploadVideo(event, user, skill)
 		if err != nil {
 			return errors.New("\n\tError resolving upload: " + err.Error())
 		}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/line"
+	"github.com/HeavenAQ/chart"
+)
+
+const (
+	chartPath = "/charts/progress.png"
+	// LINE caches images by url, so links stay valid long enough to be
+	// reopened from the chat history
+	chartURLTTL = 30 * 24 * time.Hour
+)
+
+// chartSignature keeps the chart of one user from being fetched by guessing
+// user ids.
+func chartSignature(key string, userId string, skill string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(userId + "\n" + skill + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// progressChartURL links the rating chart of a skill. It is empty when
+// PUBLIC_BASE_URL or CHART_SIGNING_KEY is not set, and the trend is then sent
+// without a chart.
+func (app *App) progressChartURL(userId string, skill string, count int, now time.Time) string {
+	baseURL := strings.TrimSuffix(app.Config.PublicBaseURL, "/")
+	key := app.Config.ChartSigningKey
+	if baseURL == "" || key == "" {
+		return ""
+	}
+
+	// rounded to the day so repeated requests share the same url
+	expires := now.Add(chartURLTTL).Truncate(24 * time.Hour).Unix()
+	query := url.Values{}
+	query.Set("user", userId)
+	query.Set("skill", skill)
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	query.Set("sig", chartSignature(key, userId, skill, expires))
+	// the work count keeps LINE from showing a stale image after an upload
+	query.Set("n", strconv.Itoa(count))
+	return baseURL + chartPath + "?" + query.Encode()
+}
+
+// ChartHandler renders the rating history of a skill as a PNG for the hero
+// image of the progress trend bubble.
+func (app *App) ChartHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := app.Config.ChartSigningKey
+		if key == "" || req.Method != http.MethodGet {
+			http.NotFound(w, req)
+			return
+		}
+
+		query := req.URL.Query()
+		userId, skill := query.Get("user"), query.Get("skill")
+		expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+		if err != nil || time.Now().Unix() > expires {
+			http.Error(w, "link expired", http.StatusForbidden)
+			return
+		}
+		expected := chartSignature(key, userId, skill, expires)
+		if !hmac.Equal([]byte(expected), []byte(query.Get("sig"))) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+
+		user, err := app.Db.GetUserData(userId)
+		if errors.Is(err, db.ErrUserNotFound) {
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			app.ErrorLogger.Println("\n\tError getting user data for chart:", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		points := []chart.Point{}
+		for _, point := range user.RatingHistory(skill, app.Location) {
+			points = append(points, chart.Point{
+				Label: point.Date.Format("01/02"),
+				Value: float64(point.Rating),
+			})
+		}
+
+		buf := &bytes.Buffer{}
+		err = chart.DefaultLineChart().Render(buf, points)
+		if errors.Is(err, chart.ErrNoPoints) {
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			app.ErrorLogger.Println("\n\tError rendering chart:", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(buf.Bytes())
+	})
+}
+
+// resolveProgressTrend sends the rating trend of every skill the user has
+// uploaded videos for.
+func (app *App) resolveProgressTrend(replyToken string, user *db.UserData) {
+	now := time.Now().In(app.Location)
+	trends := []line.SkillTrend{}
+	for _, s := range app.Skills.All() {
+		history := user.RatingHistory(s.Id, now.Location())
+		if len(history) == 0 {
+			continue
+		}
+		trends = append(trends, line.SkillTrend{
+			Skill:    s,
+			Stats:    db.NewTrendStats(history, now),
+			ChartURL: app.progressChartURL(user.Id, s.Id, len(history), now),
+		})
+	}
+
+	if _, err := app.botFor(user).SendProgressTrend(replyToken, trends); err != nil {
+		app.ErrorLogger.Println("\n\tError sending progress trend:", err)
+	}
+}
@@ -0,0 +1,511 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavenAQ/api/analysis"
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/drive"
+	"github.com/HeavenAQ/api/line"
+	"github.com/HeavenAQ/config"
+	"github.com/HeavenAQ/fsm"
+	"github.com/HeavenAQ/i18n"
+	"github.com/HeavenAQ/skill"
+	"github.com/HeavenAQ/workspace"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// lineStub stands in for the LINE API, answering every call and keeping the
+// texts of the replies and the number of pushes.
+type lineStub struct {
+	mu      sync.Mutex
+	replies []string
+	pushes  int
+}
+
+func (s *lineStub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/v2/bot/profile/"):
+		json.NewEncoder(w).Encode(map[string]string{
+			"userId":      strings.TrimPrefix(req.URL.Path, "/v2/bot/profile/"),
+			"displayName": "Student",
+			"language":    "en",
+		})
+	case strings.HasSuffix(req.URL.Path, "/content"):
+		io.WriteString(w, "video")
+	case req.URL.Path == "/v2/bot/message/reply":
+		var body struct {
+			Messages []struct {
+				Text string `json:"text"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		texts := []string{}
+		for _, message := range body.Messages {
+			texts = append(texts, message.Text)
+		}
+		s.mu.Lock()
+		s.replies = append(s.replies, strings.Join(texts, "\n"))
+		s.mu.Unlock()
+		io.WriteString(w, "{}")
+	case req.URL.Path == "/v2/bot/message/push":
+		s.mu.Lock()
+		s.pushes++
+		s.mu.Unlock()
+		io.WriteString(w, "{}")
+	default:
+		io.WriteString(w, "{}")
+	}
+}
+
+// lastReply returns the text of the latest reply.
+func (s *lineStub) lastReply() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.replies) == 0 {
+		return ""
+	}
+	return s.replies[len(s.replies)-1]
+}
+
+func (s *lineStub) pushCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pushes
+}
+
+// testApp wires an App to the memory store, local storage, the fake
+// analysis client and a stand-in LINE API. Jobs are handed to jobs instead
+// of being processed.
+type testApp struct {
+	*App
+	line     *lineStub
+	analyzer *analysis.FakeClient
+	jobs     chan *db.Job
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	stub := &lineStub{}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	cfg := config.Default()
+	logger := log.New(io.Discard, "", 0)
+	skills, err := skill.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := i18n.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := drive.NewLocalStorageHandler(t.TempDir(), "http://localhost:"+cfg.Port, "media-key", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot, err := line.NewLineBotHandler("secret", "token", storage, skills, messages,
+		linebot.WithEndpointBase(server.URL),
+		linebot.WithEndpointBaseData(server.URL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspaces, err := workspace.NewRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analyzer := analysis.NewFakeClient()
+	app := &App{
+		Config:       cfg,
+		Bot:          bot,
+		Storage:      storage,
+		Db:           db.NewMemoryHandler(),
+		InfoLogger:   logger,
+		ErrorLogger:  logger,
+		WarnLogger:   logger,
+		Conversation: fsm.NewConversation(logger),
+		Skills:       skills,
+		Messages:     messages,
+		Analyzer:     analyzer,
+		Breakers:     newBreakers(cfg.Breaker, logger),
+		Workspaces:   workspaces,
+		VideoLimits:  cfg.VideoLimits(),
+		InstanceId:   "test",
+		Location:     time.UTC,
+	}
+
+	jobs := make(chan *db.Job, 10)
+	app.Jobs = NewJobQueue(1, 10, func(ctx context.Context, job *db.Job) {
+		jobs <- job
+	})
+	app.Jobs.Start()
+	t.Cleanup(func() { app.Jobs.Stop(context.Background()) })
+
+	return &testApp{app, stub, analyzer, jobs}
+}
+
+const testUserId = "U1234"
+
+func (app *testApp) send(event *linebot.Event) {
+	event.ReplyToken = "reply-token"
+	event.Source = &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: testUserId}
+	app.handleEvent(event)
+}
+
+func (app *testApp) sendText(text string) {
+	app.send(&linebot.Event{
+		Type:    linebot.EventTypeMessage,
+		Message: &linebot.TextMessage{ID: "text-" + text, Text: text},
+	})
+}
+
+func (app *testApp) sendCommand(command string) {
+	app.sendText(app.Messages.Command("en", command))
+}
+
+func (app *testApp) sendPostback(t *testing.T, postback line.Postback) {
+	t.Helper()
+	data, err := line.EncodePostback(postback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.send(&linebot.Event{
+		Type:     linebot.EventTypePostback,
+		Postback: &linebot.Postback{Data: data},
+	})
+}
+
+func (app *testApp) expectState(t *testing.T, state db.UserState) {
+	t.Helper()
+	session, err := app.Db.GetUserSession(testUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.UserState != state {
+		t.Fatalf("state %s, want %s", session.UserState, state)
+	}
+}
+
+func (app *testApp) expectReply(t *testing.T, key string, args ...any) {
+	t.Helper()
+	want := app.Bot.In("en").T(key, args...)
+	if got := app.line.lastReply(); got != want {
+		t.Fatalf("reply %q, want %q", got, want)
+	}
+}
+
+// TestAnalyzeAndReflectFlow walks a new student through uploading a video,
+// its analysis and a reflection on the analyzed work. ffmpeg is not needed:
+// the resize and thumbnail stages are skipped.
+func TestAnalyzeAndReflectFlow(t *testing.T) {
+	app := newTestApp(t)
+
+	app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+	app.sendText("12")
+	app.expectReply(t, "test_number.set", 12)
+
+	app.sendCommand(analyzeVideoCommand)
+	app.expectState(t, db.SelectingAnalyzeHandedness)
+	app.sendPostback(t, &line.HandednessPostback{Handedness: db.Left})
+	app.expectState(t, db.SelectingAnalyzeSkill)
+	app.sendPostback(t, &line.UserActionPostback{Type: line.AnalyzeVideo, Skill: "serve"})
+	app.expectState(t, db.UploadingVideo)
+	app.send(&linebot.Event{
+		Type:    linebot.EventTypeMessage,
+		Message: &linebot.VideoMessage{ID: "video-1"},
+	})
+	app.expectState(t, db.None)
+	app.expectReply(t, "upload.processing")
+
+	var job *db.Job
+	select {
+	case job = <-app.jobs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload was not queued")
+	}
+	if job.Id != "video-1" || job.UserId != testUserId || job.Skill != "serve" {
+		t.Fatalf("queued %+v", job)
+	}
+	stored, err := app.Db.GetJob(job.Id)
+	if err != nil || stored.Status != db.JobQueued {
+		t.Fatalf("stored job %+v: %v", stored, err)
+	}
+
+	// the stages of processVideoJob after ffmpeg resized the video
+	user, err := app.Db.GetUserData(testUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Handedness != db.Left || user.TestNumber != 12 {
+		t.Fatalf("user %+v", user)
+	}
+	ws, err := app.Workspaces.New(job.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := newVideoFiles(ws)
+	for _, path := range []string{files.Original, files.Resized} {
+		if err := app.downloadVideo(job.Id, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serve, _ := app.Skills.Get("serve")
+	result, err := analyzeVideo(context.Background(), *app.App, files, user, serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := app.analyzer.Requests[0]
+	if request.Handedness != "left" || request.Skill != "serve" || request.Model == "" {
+		t.Errorf("analysis request %+v", request)
+	}
+	if err := os.WriteFile(files.Thumbnail, []byte("jpeg"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := uploadVideoToStorage(*app.App, user, job, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateUserPortfolioVideo(*app.App, user, job, uploaded, result); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ = app.Db.GetUserData(testUserId)
+	works := user.Portfolio.Skills["serve"]
+	if len(works) != 1 {
+		t.Fatalf("%d serve works, want 1", len(works))
+	}
+	var work db.Work
+	for _, work = range works {
+		break
+	}
+	if work.Rating != 80 || work.SourceMessageId != job.Id || work.Analysis == nil || work.OriginalVideo == "" {
+		t.Errorf("work %+v", work)
+	}
+
+	app.sendCommand(reflectionCommand)
+	app.expectState(t, db.SelectingReflectionSkill)
+	app.sendPostback(t, &line.UserActionPostback{Type: line.AddReflection, Skill: "serve"})
+	app.expectState(t, db.SelectingReflectionDate)
+	app.sendPostback(t, &line.DateSelectionPostback{Type: line.AddReflection, Date: work.DateTime})
+	app.expectState(t, db.WritingReflection)
+	app.sendText("My toss was too low.")
+	app.expectState(t, db.None)
+	app.expectReply(t, "reflection.saved")
+
+	user, _ = app.Db.GetUserData(testUserId)
+	if got := user.Portfolio.Skills["serve"][work.DateTime].Reflection; got != "My toss was too low." {
+		t.Errorf("reflection %q", got)
+	}
+}
+
+func TestReanalysisUsesOriginalUpload(t *testing.T) {
+	app := newTestApp(t)
+	app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+	app.sendText("12")
+	user, _ := app.Db.GetUserData(testUserId)
+
+	// a work analyzed before, with its upload kept
+	dir := t.TempDir()
+	upload, skeleton, thumbnail := dir+"/upload.mp4", dir+"/skeleton.mp4", dir+"/thumbnail.jpeg"
+	for path, content := range map[string]string{upload: "student upload", skeleton: "old skeleton", thumbnail: "jpeg"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	folderId := user.FolderIds.Skills["serve"]
+	videoFile, err := app.Storage.UploadVideo(folderId, skeleton)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnailFile, err := app.Storage.UploadThumbnail(videoFile, thumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalFile, err := app.Storage.UploadOriginal(folderId, videoFile, upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.Db.CreateUserPortfolioVideo(user, app.getUserPortfolio(user, "serve"), "serve", "video-1", videoFile, thumbnailFile, originalFile, 70, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stages of processVideoJob for a re-analysis, ffmpeg aside
+	job := &db.Job{Id: "work_" + testUserId + "_serve_" + videoFile.Name, UserId: testUserId, Skill: "serve", WorkDate: videoFile.Name}
+	ws, err := app.Workspaces.New(job.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := newVideoFiles(ws)
+	if err := downloadVideo(*app.App, user, job, files); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(files.Original); string(got) != "student upload" {
+		t.Fatalf("re-analysis downloaded %q, want the student's upload", got)
+	}
+	for path, content := range map[string]string{files.Resized: "student upload", files.Thumbnail: "new jpeg"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serve, _ := app.Skills.Get("serve")
+	result, err := analyzeVideo(context.Background(), *app.App, files, user, serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := uploadVideoToStorage(*app.App, user, job, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateUserPortfolioVideo(*app.App, user, job, uploaded, result); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ = app.Db.GetUserData(testUserId)
+	work := user.Portfolio.Skills["serve"][videoFile.Name]
+	if work.OriginalVideo != originalFile.Id || work.SkeletonVideo != uploaded.Video.Id || work.Thumbnail != uploaded.Thumbnail.Id {
+		t.Errorf("work %+v, want the new analysis of %s", work, originalFile.Id)
+	}
+	for id, kept := range map[string]bool{videoFile.Id: false, thumbnailFile.Id: false, originalFile.Id: true, work.SkeletonVideo: true} {
+		err := app.Storage.DownloadVideo(id, dir+"/check")
+		if exists := err == nil; exists != kept {
+			t.Errorf("file %s kept = %v, want %v: %v", id, exists, kept, err)
+		}
+	}
+}
+
+func TestReanalysisWithoutOriginalUpload(t *testing.T) {
+	app := newTestApp(t)
+	app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+	app.sendText("12")
+
+	user, _ := app.Db.GetUserData(testUserId)
+	date := "2024-03-01-10-00"
+	video := &drive.UploadedFile{Id: "video", Name: date}
+	thumbnail := &drive.UploadedFile{Id: "thumbnail", Name: date}
+	err := app.Db.CreateUserPortfolioVideo(user, app.getUserPortfolio(user, "serve"), "serve", "video-1", video, thumbnail, &drive.UploadedFile{}, 80, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, _, err := app.adminReanalyzeWork(testUserId, "serve", date)
+	if status != http.StatusConflict || !errors.Is(err, errNoOriginalVideo) {
+		t.Errorf("status %d with %v, want %d", status, err, http.StatusConflict)
+	}
+}
+
+func TestUnexpectedInputGetsHint(t *testing.T) {
+	app := newTestApp(t)
+	app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+	app.sendText("12")
+
+	app.sendCommand(analyzeVideoCommand)
+	app.send(&linebot.Event{
+		Type:    linebot.EventTypeMessage,
+		Message: &linebot.VideoMessage{ID: "video-1"},
+	})
+	app.expectState(t, db.SelectingAnalyzeHandedness)
+	app.expectReply(t, "hint.select_handedness")
+
+	app.sendPostback(t, &line.HandednessPostback{Handedness: db.Right})
+	app.sendPostback(t, &line.UserActionPostback{Type: line.AddReflection, Skill: "serve"})
+	app.expectState(t, db.SelectingAnalyzeSkill)
+	app.expectReply(t, "hint.select_analyze_skill")
+
+	select {
+	case job := <-app.jobs:
+		t.Fatalf("queued %+v", job)
+	default:
+	}
+}
+
+func TestUploadWithFullQueueIsResumed(t *testing.T) {
+	app := newTestApp(t)
+	app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+	app.sendText("12")
+	app.sendCommand(analyzeVideoCommand)
+	app.sendPostback(t, &line.HandednessPostback{Handedness: db.Right})
+	app.sendPostback(t, &line.UserActionPostback{Type: line.AnalyzeVideo, Skill: "serve"})
+
+	// a queue without slots is always full
+	queue := app.Jobs
+	app.Jobs = NewJobQueue(1, 0, nil)
+	app.send(&linebot.Event{
+		Type:    linebot.EventTypeMessage,
+		Message: &linebot.VideoMessage{ID: "video-1"},
+	})
+	app.expectReply(t, "upload.queued")
+	stored, err := app.Db.GetJob("video-1")
+	if err != nil || stored.Status != db.JobQueued {
+		t.Fatalf("stored job %+v: %v", stored, err)
+	}
+
+	app.Jobs = queue
+	app.resumeJobs()
+	select {
+	case job := <-app.jobs:
+		if job.Id != "video-1" {
+			t.Errorf("resumed %s, want video-1", job.Id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload was not resumed")
+	}
+}
+
+func TestNoteStartingWithCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		action  line.Action
+		text    string
+		saved   string
+	}{
+		{reflectionCommand, line.AddReflection, "Language was the hard part of the drill", "reflection.saved"},
+		{reflectionCommand, line.AddReflection, "Faults in my footwork again", "reflection.saved"},
+		{previewNoteCommand, line.AddPreviewNote, "Join Class drills first", "preview_note.saved"},
+		{previewNoteCommand, line.AddPreviewNote, "Verify Teacher feedback on my grip", "preview_note.saved"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			app := newTestApp(t)
+			app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+			app.sendText("12")
+
+			user, _ := app.Db.GetUserData(testUserId)
+			date := "2024-03-01-10-00"
+			video := &drive.UploadedFile{Id: "video", Name: date}
+			thumbnail := &drive.UploadedFile{Id: "thumbnail", Name: date}
+			original := &drive.UploadedFile{Id: "original", Name: date}
+			err := app.Db.CreateUserPortfolioVideo(user, app.getUserPortfolio(user, "serve"), "serve", "video-1", video, thumbnail, original, 80, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app.sendCommand(tt.command)
+			app.sendPostback(t, &line.UserActionPostback{Type: tt.action, Skill: "serve"})
+			app.sendPostback(t, &line.DateSelectionPostback{Type: tt.action, Date: date})
+			app.sendText(tt.text)
+			app.expectState(t, db.None)
+			app.expectReply(t, tt.saved)
+
+			user, _ = app.Db.GetUserData(testUserId)
+			work := user.Portfolio.Skills["serve"][date]
+			if got := work.Reflection + work.PreviewNote; !strings.Contains(got, tt.text) {
+				t.Errorf("work %+v does not hold %q", work, tt.text)
+			}
+		})
+	}
+}
@@ -1,39 +1,59 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"io"
-	"strings"
+	"os"
 
 	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/metrics"
 	"github.com/line/line-bot-sdk-go/v7/linebot"
 )
 
-func (app *App) createUser(userId string) *db.UserData {
-	username, err := app.Bot.GetUserName(userId)
+func (app *App) createUser(userId string) (*db.UserData, error) {
+	var username, language string
+	profile, err := app.Bot.GetUserProfile(userId)
 	if err != nil {
 		app.ErrorLogger.Println("\n\tError getting new user's name:", err)
-
+	} else {
+		username, language = profile.DisplayName, profile.Language
 	}
-	userFolders, err := app.Drive.CreateUserFolders(userId, username)
+	userFolders, err := app.Storage.CreateUserFolders(userId, username, app.Skills.Ids())
 	if err != nil {
-		app.ErrorLogger.Println("\n\tError creating new user's folders:", err)
+		return nil, fmt.Errorf("failed to create new user's folders: %v", err)
 	}
 	userData, err := app.Db.CreateUserData(userFolders)
 	if err != nil {
-		app.ErrorLogger.Println("\n\tError creating new user's data:", err)
+		return nil, fmt.Errorf("failed to create new user's data: %v", err)
+	}
+	if profile != nil {
+		app.setUserLanguage(userData, language)
 	}
-	return userData
+	return userData, nil
 }
 
-func (app *App) createUserIfNotExist(userId string) (user *db.UserData) {
+// createUserIfNotExist loads the user, creating them on their first event.
+// Other errors are returned, so that a store outage never replaces an
+// existing user and their portfolio with a new one.
+func (app *App) createUserIfNotExist(userId string) (*db.UserData, error) {
 	user, err := app.Db.GetUserData(userId)
-	if err != nil {
+	if errors.Is(err, db.ErrUserNotFound) {
 		app.WarnLogger.Println("\n\tUser not found, creating new user...")
-		userData := app.createUser(userId)
-		user = userData
+		user, err = app.createUser(userId)
+		if err != nil {
+			return nil, err
+		}
 		app.InfoLogger.Println("\n\tNew user created successfully.")
+		return user, nil
 	}
-	return
+	if err != nil {
+		return nil, err
+	}
+	if user.Language == "" {
+		app.detectUserLanguage(user)
+	}
+	return user, nil
 }
 
 func (app *App) createUserSessionIfNotExist(userId string) (userSession *db.UserSession) {
@@ -50,63 +70,54 @@ func (app *App) createUserSessionIfNotExist(userId string) (userSession *db.User
 	return
 }
 
-func (app *App) updateUserState(userId string, state db.UserState) {
-	err := app.Db.UpdateSessionUserState(userId, state)
+// downloadVideo streams the content of a video message to dst.
+func (app *App) downloadVideo(messageId string, dst string) error {
+	resp, err := app.Bot.GetMessageContent(messageId)
 	if err != nil {
-		app.WarnLogger.Println("\n\tError updating user state:", err)
+		return err
 	}
-}
+	defer resp.Content.Close()
 
-func (app *App) resetUserSession(userId string) {
-	err := app.Db.UpdateUserSession(
-		userId,
-		db.UserSession{
-			UserState: db.None,
-			Skill:     "",
-		},
-	)
+	file, err := os.Create(dst)
 	if err != nil {
-		app.ErrorLogger.Println("\n\tError resetting user session:", err)
+		return err
 	}
+	if _, err := io.Copy(file, resp.Content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-func (app *App) downloadVideo(event *linebot.Event) (io.Reader, error) {
-	resp, err := app.Bot.GetVideoContent(event)
-	if err != nil {
-		return nil, err
+// getVideoFolder returns the folder of a skill, creating it for users who
+// signed up before the skill was added to the registry.
+func (app *App) getVideoFolder(user *db.UserData, skill string) (string, error) {
+	if folderId, ok := user.FolderIds.Skills[skill]; ok && folderId != "" {
+		return folderId, nil
 	}
-	defer resp.Content.Close()
 
-	// Read the body into a byte slice
-	blob, err := io.ReadAll(resp.Content)
+	app.InfoLogger.Println("\n\tCreating", skill, "folder for user", user.Id)
+	folderId, err := app.Storage.CreateSkillFolder(user.FolderIds.Root, skill)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return strings.NewReader(string(blob)), nil
-}
-
-func (app *App) getVideoFolder(user *db.UserData, skill string) string {
-	var folderId string
-	switch skill {
-	case "serve":
-		folderId = user.FolderIds.Serve
-	case "smash":
-		folderId = user.FolderIds.Smash
-	case "clear":
-		folderId = user.FolderIds.Clear
-	}
-	return folderId
+	if user.FolderIds.Skills == nil {
+		user.FolderIds.Skills = map[string]string{}
+	}
+	if err := app.Db.UpdateUserSkillFolder(user, skill, folderId); err != nil {
+		return "", err
+	}
+	return folderId, nil
 }
 
 func (app *App) getUserPortfolio(user *db.UserData, skill string) *map[string]db.Work {
-	var work map[string]db.Work
-	switch skill {
-	case "serve":
-		work = user.Portfolio.Serve
-	case "smash":
-		work = user.Portfolio.Smash
-	case "clear":
-		work = user.Portfolio.Clear
+	if user.Portfolio.Skills == nil {
+		user.Portfolio.Skills = map[string]map[string]db.Work{}
+	}
+	work, ok := user.Portfolio.Skills[skill]
+	if !ok {
+		work = map[string]db.Work{}
+		user.Portfolio.Skills[skill] = work
 	}
 	return &work
 }
@@ -118,7 +129,8 @@ func (app *App) updateUserReflection(event *linebot.Event, user *db.UserData, se
 	if err != nil {
 		return err
 	}
-	_, err = app.Bot.SendReply(event.ReplyToken, "已成功更新個人學習反思!")
+	bot := app.botFor(user)
+	_, err = bot.SendReply(event.ReplyToken, bot.T("reflection.saved"))
 	if err != nil {
 		return err
 	}
@@ -132,7 +144,8 @@ func (app *App) updateUserPreviewNote(event *linebot.Event, user *db.UserData, s
 	if err != nil {
 		return err
 	}
-	_, err = app.Bot.SendReply(event.ReplyToken, "已成功更新課前檢視要點!")
+	bot := app.botFor(user)
+	_, err = bot.SendReply(event.ReplyToken, bot.T("preview_note.saved"))
 	if err != nil {
 		return err
 	}
@@ -146,9 +159,9 @@ func (app *App) resolveWritingReflection(event *linebot.Event, user *db.UserData
 		if err != nil {
 			return err
 		}
-		app.resetUserSession(user.Id)
 	default:
-		_, err := app.Bot.SendReply(event.ReplyToken, "請輸入學習反思")
+		bot := app.botFor(user)
+		_, err := bot.SendReply(event.ReplyToken, bot.T("hint.write_reflection"))
 		if err != nil {
 			return err
 		}
@@ -163,12 +176,19 @@ func (app *App) resolveWritingPreviewNote(event *linebot.Event, user *db.UserDat
 		if err != nil {
 			return err
 		}
-		app.resetUserSession(user.Id)
 	default:
-		_, err := app.Bot.SendReply(event.ReplyToken, "請輸入課前檢視要點")
+		bot := app.botFor(user)
+		_, err := bot.SendReply(event.ReplyToken, bot.T("hint.write_preview_note"))
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// replyError tells the user the event could not be handled and counts the
+// failure under the handler's name.
+func (app *App) replyError(user *db.UserData, replyToken string, handler string) {
+	metrics.HandlerErrors.WithLabelValues(handler).Inc()
+	app.botFor(user).SendDefaultErrorReply(replyToken)
+}
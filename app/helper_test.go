@@ -0,0 +1,74 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/drive"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// flakyStore fails to load users, as Firestore does during an outage.
+type flakyStore struct {
+	db.Store
+	created int
+}
+
+func (store *flakyStore) GetUserData(userId string) (*db.UserData, error) {
+	return nil, errors.New("deadline exceeded")
+}
+
+func (store *flakyStore) CreateUserData(userFolders *drive.UserFolders) (*db.UserData, error) {
+	store.created++
+	return store.Store.CreateUserData(userFolders)
+}
+
+// brokenStorage cannot create folders.
+type brokenStorage struct {
+	drive.Storage
+}
+
+func (storage brokenStorage) CreateUserFolders(userId string, userName string, skills []string) (*drive.UserFolders, error) {
+	return nil, errors.New("quota exceeded")
+}
+
+func TestLoadUserErrors(t *testing.T) {
+	t.Run("store outage keeps the user", func(t *testing.T) {
+		app := newTestApp(t)
+		app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+		app.sendText("12")
+
+		memory := app.Db
+		store := &flakyStore{Store: memory}
+		app.Db = store
+		app.sendText("13")
+		if got := app.line.lastReply(); got != app.Bot.T("error_reply") {
+			t.Errorf("reply %q, want the error reply", got)
+		}
+
+		if store.created != 0 {
+			t.Errorf("user created %d times during the outage", store.created)
+		}
+		user, err := memory.GetUserData(testUserId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.TestNumber != 12 {
+			t.Errorf("test number %d, want 12", user.TestNumber)
+		}
+	})
+
+	t.Run("folders not created", func(t *testing.T) {
+		app := newTestApp(t)
+		app.Storage = brokenStorage{app.Storage}
+		app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+		if got := app.line.lastReply(); got != app.Bot.T("error_reply") {
+			t.Errorf("reply %q, want the error reply", got)
+		}
+
+		if _, err := app.Db.GetUserData(testUserId); !errors.Is(err, db.ErrUserNotFound) {
+			t.Errorf("err = %v, want %v", err, db.ErrUserNotFound)
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+)
+
+var (
+	ErrQueueFull        = errors.New("job queue is full")
+	ErrRetriesExhausted = errors.New("job was delayed too many times")
+	ErrQueueStopped     = errors.New("job queue is stopped")
+	ErrJobPending       = errors.New("job is already in the queue")
+)
+
+// JobQueue runs video analysis jobs on a fixed number of workers. Job status
+// is persisted by the caller, the queue itself only holds the pending work.
+type JobQueue struct {
+	jobs    chan *db.Job
+	workers int
+	process func(ctx context.Context, job *db.Job)
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	delays  map[string]int
+	pending map[string]bool
+	running map[string]*runningJob
+	// jobs waiting for their delay to end
+	delayed map[string]*delayedJob
+	stopped bool
+}
+
+type runningJob struct {
+	job    *db.Job
+	cancel context.CancelFunc
+	// committed jobs are storing their result and are left to finish
+	committed bool
+	// abandoned jobs were reported as interrupted by Stop
+	abandoned bool
+}
+
+type delayedJob struct {
+	job   *db.Job
+	timer *time.Timer
+}
+
+func NewJobQueue(workers int, capacity int, process func(ctx context.Context, job *db.Job)) *JobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &JobQueue{
+		jobs:    make(chan *db.Job, capacity),
+		workers: workers,
+		process: process,
+		delays:  map[string]int{},
+		pending: map[string]bool{},
+		running: map[string]*runningJob{},
+		delayed: map[string]*delayedJob{},
+	}
+}
+
+func (queue *JobQueue) Start() {
+	for i := 0; i < queue.workers; i++ {
+		queue.wg.Add(1)
+		go func() {
+			defer queue.wg.Done()
+			for job := range queue.jobs {
+				ctx := queue.setRunning(job)
+				queue.process(ctx, job)
+				queue.setDone(job)
+			}
+		}()
+	}
+}
+
+// setRunning returns the context of the job, cancelled when Stop gives up on
+// it.
+func (queue *JobQueue) setRunning(job *db.Job) context.Context {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	delete(queue.pending, job.Id)
+	queue.running[job.Id] = &runningJob{job: job, cancel: cancel}
+	return ctx
+}
+
+func (queue *JobQueue) setDone(job *db.Job) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if running, ok := queue.running[job.Id]; ok {
+		running.cancel()
+		delete(queue.running, job.Id)
+	}
+}
+
+// Commit is called by a running job before it stores its result. It returns
+// false when Stop already reported the job as interrupted, the job must then
+// end without storing or pushing anything.
+func (queue *JobQueue) Commit(jobId string) bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	running, ok := queue.running[jobId]
+	if !ok || running.abandoned {
+		return false
+	}
+	running.committed = true
+	return true
+}
+
+// Enqueue never blocks the webhook; ErrQueueFull is returned when every slot
+// is taken and ErrQueueStopped once the queue is shutting down. A job already
+// waiting, running or delayed here is not queued twice and ErrJobPending is
+// returned.
+func (queue *JobQueue) Enqueue(job *db.Job) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.stopped {
+		return ErrQueueStopped
+	}
+	if queue.pending[job.Id] || queue.running[job.Id] != nil || queue.delayed[job.Id] != nil {
+		return ErrJobPending
+	}
+	select {
+	case queue.jobs <- job:
+		queue.pending[job.Id] = true
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Delay queues the job again after delay and returns how often it has been
+// delayed. ErrRetriesExhausted is returned once it was delayed maxDelays
+// times. A job that cannot be queued when the delay ends is picked up by
+// resumeJobs once its lease expires.
+func (queue *JobQueue) Delay(job *db.Job, delay time.Duration, maxDelays int) (int, error) {
+	queue.mu.Lock()
+	delays := queue.delays[job.Id] + 1
+	if delays > maxDelays {
+		delete(queue.delays, job.Id)
+		queue.mu.Unlock()
+		return delays - 1, ErrRetriesExhausted
+	}
+	queue.delays[job.Id] = delays
+	queue.delayed[job.Id] = &delayedJob{job, time.AfterFunc(delay, func() {
+		queue.mu.Lock()
+		// Stop took the job over
+		if _, ok := queue.delayed[job.Id]; !ok {
+			queue.mu.Unlock()
+			return
+		}
+		delete(queue.delayed, job.Id)
+		queue.mu.Unlock()
+		queue.Enqueue(job)
+	})}
+	queue.mu.Unlock()
+	return delays, nil
+}
+
+// Stop stops taking jobs and waits for the running ones until ctx is done.
+// It returns the jobs still waiting in the queue or for their delay, which
+// were not started, and the jobs still running when ctx is done. Those are
+// cancelled and will not store or push their result, see Commit; jobs
+// already storing their result are left to finish.
+func (queue *JobQueue) Stop(ctx context.Context) (notStarted []*db.Job, interrupted []*db.Job) {
+	queue.mu.Lock()
+	if queue.stopped {
+		queue.mu.Unlock()
+		return nil, nil
+	}
+	queue.stopped = true
+
+	for pending := true; pending; {
+		select {
+		case job := <-queue.jobs:
+			delete(queue.pending, job.Id)
+			notStarted = append(notStarted, job)
+		default:
+			pending = false
+		}
+	}
+	close(queue.jobs)
+	for id, delayed := range queue.delayed {
+		delayed.timer.Stop()
+		notStarted = append(notStarted, delayed.job)
+		delete(queue.delayed, id)
+	}
+	queue.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		queue.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return notStarted, nil
+	case <-ctx.Done():
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	for _, running := range queue.running {
+		if running.committed {
+			continue
+		}
+		running.abandoned = true
+		running.cancel()
+		interrupted = append(interrupted, running.job)
+	}
+	return notStarted, interrupted
+}
+
+// Done forgets how often a finished job was delayed.
+func (queue *JobQueue) Done(jobId string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	delete(queue.delays, jobId)
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/line"
+	"github.com/HeavenAQ/fsm"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// languageCommand is followed by the language to switch to, e.g. "Language en"
+const languageCommand = "language"
+
+// botFor returns the bot replying in the user's language.
+func (app *App) botFor(user *db.UserData) *line.LineBotHandler {
+	if user == nil || user.Language == "" {
+		return app.Bot
+	}
+	return app.Bot.In(user.Language)
+}
+
+// botForId is botFor for pushes of jobs, which only know the user id. The
+// default language is used when the user cannot be loaded.
+func (app *App) botForId(userId string) *line.LineBotHandler {
+	user, err := app.Db.GetUserData(userId)
+	if err != nil {
+		app.WarnLogger.Println("\n\tError getting user", userId, "for their language:", err)
+		return app.Bot
+	}
+	return app.botFor(user)
+}
+
+// setUserLanguage stores the catalog language matching the language of the
+// user's LINE app.
+func (app *App) setUserLanguage(user *db.UserData, profileLanguage string) {
+	language := app.Messages.Match(profileLanguage)
+	if err := app.Db.UpdateUserLanguage(user, language); err != nil {
+		app.WarnLogger.Println("\n\tError updating user language:", err)
+		return
+	}
+	app.InfoLogger.Println("\n\tLanguage of user", user.Id, "set to", language, "from", profileLanguage)
+}
+
+// detectUserLanguage takes the language of users created before languages
+// were stored from their LINE profile. It is tried again on the next event
+// when LINE cannot be reached.
+func (app *App) detectUserLanguage(user *db.UserData) {
+	profile, err := app.Bot.GetUserProfile(user.Id)
+	if err != nil {
+		app.WarnLogger.Println("\n\tError getting user profile:", err)
+		return
+	}
+	app.setUserLanguage(user, profile.Language)
+}
+
+// handleLanguageCommand switches the language of the replies and reports
+// whether the text was the language command. Without a known language the
+// languages are offered as quick replies.
+func (app *App) handleLanguageCommand(event *linebot.Event, user *db.UserData, session *db.UserSession, command string, arg string) bool {
+	if command != languageCommand {
+		return false
+	}
+	if _, ok := app.transition(event.ReplyToken, user, session, fsm.Input{Event: fsm.EventMenu}); !ok {
+		return true
+	}
+
+	bot := app.botFor(user)
+	language, ok := app.Messages.Lookup(arg)
+	if !ok {
+		if _, err := bot.PromptLanguageSelection(event.ReplyToken); err != nil {
+			app.WarnLogger.Println("\n\tError prompting language selection:", err)
+		}
+		return true
+	}
+
+	if err := app.Db.UpdateUserLanguage(user, language); err != nil {
+		app.ErrorLogger.Println("\n\tError updating user language:", err)
+		app.replyError(user, event.ReplyToken, "language")
+		return true
+	}
+	bot = app.botFor(user)
+	bot.SendReply(event.ReplyToken, bot.T("language.set", app.Messages.Name(language)))
+	return true
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/fsm"
+	"github.com/HeavenAQ/scheduler"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	reminderOffCommand = "reminder_off"
+	reminderOnCommand  = "reminder_on"
+)
+
+// reminderConfig is read from the JSON file at REMINDER_CONFIG. Schedules
+// are cron specs in TIME_ZONE; an empty one turns reminders off for its
+// students.
+type reminderConfig struct {
+	// Default is for students whose class has no schedule of its own
+	Default string `json:"default"`
+	// Classes maps a class code to its schedule
+	Classes    map[string]string `json:"classes"`
+	QuietHours string            `json:"quietHours"`
+}
+
+// defaultReminderConfig reminds everyone on Friday evening, leaving the
+// weekend to catch up before the week ends on Sunday.
+var defaultReminderConfig = reminderConfig{
+	Default:    "0 19 * * 5",
+	QuietHours: "22:00-08:00",
+}
+
+const (
+	// how long an instance has to send the reminders of a group before
+	// another may send them again
+	reminderLease = 10 * time.Minute
+	// sent reminders are remembered past the end of their week
+	reminderSentTTL = 8 * 24 * time.Hour
+)
+
+func loadReminderConfig(path string) (*reminderConfig, error) {
+	config := defaultReminderConfig
+	if path == "" {
+		return &config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// unknown keys are refused, the zone used to be set here and is now
+	// TIME_ZONE
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse reminder config: %v", err)
+	}
+	return &config, nil
+}
+
+// reminderGroup is the students reminded on one schedule: a class with its
+// own, or everyone else on the default one.
+type reminderGroup struct {
+	// name is the class code, default for the default schedule
+	name     string
+	schedule scheduler.Schedule
+	includes func(user *db.UserData) bool
+}
+
+type reminderSchedule struct {
+	groups []reminderGroup
+	quiet  scheduler.QuietHours
+
+	mu sync.Mutex
+	// recheck holds when the store is asked again about a group and week
+	// that was sent or claimed, rather than every minute
+	recheck map[string]time.Time
+}
+
+func newReminderSchedule(config *reminderConfig) (*reminderSchedule, error) {
+	quiet, err := scheduler.ParseQuietHours(config.QuietHours)
+	if err != nil {
+		return nil, err
+	}
+	reminders := &reminderSchedule{quiet: quiet, recheck: map[string]time.Time{}}
+	if config.Default != "" {
+		schedule, err := scheduler.Parse(config.Default)
+		if err != nil {
+			return nil, err
+		}
+		reminders.groups = append(reminders.groups, reminderGroup{"default", schedule, func(user *db.UserData) bool {
+			_, hasOwn := config.Classes[user.ClassCode]
+			return !hasOwn
+		}})
+	}
+	for classCode, spec := range config.Classes {
+		if spec == "" {
+			continue
+		}
+		schedule, err := scheduler.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		classCode := classCode
+		reminders.groups = append(reminders.groups, reminderGroup{classCode, schedule, func(user *db.UserData) bool {
+			return user.ClassCode == classCode
+		}})
+	}
+	return reminders, nil
+}
+
+// dueAt returns when the group is reminded in the week of now: its first
+// scheduled minute of the week so far, moved past the quiet hours. It is
+// false before that minute.
+func (reminders *reminderSchedule) dueAt(group reminderGroup, now time.Time) (time.Time, bool) {
+	for t := db.WeekStart(now); !t.After(now); t = t.Add(time.Minute) {
+		if group.schedule.Matches(t) {
+			return reminders.quiet.After(t), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// reminderKey names the reminders of a group in the week of now, claimed in
+// the store next to the webhook events.
+func reminderKey(group reminderGroup, now time.Time) string {
+	return "reminders:" + group.name + ":" + db.WeekStart(now).Format("2006-01-02")
+}
+
+// startReminders checks every minute for the weekly reminders that are due.
+// Scaled to zero no instance runs the check, so it is also triggered through
+// POST /admin/reminders.
+func (app *App) startReminders() error {
+	config, err := loadReminderConfig(app.Config.ReminderConfig)
+	if err != nil {
+		return err
+	}
+	app.reminders, err = newReminderSchedule(config)
+	if err != nil {
+		return err
+	}
+
+	app.Scheduler = scheduler.New(app.Location)
+	err = app.Scheduler.Add("reminders", "* * * * *", func(now time.Time) {
+		app.sendDueReminders(now)
+	})
+	if err != nil {
+		return err
+	}
+	app.Scheduler.Start()
+	app.InfoLogger.Println("\n\tWeekly reminders scheduled in", app.Location, "with quiet hours", app.reminders.quiet)
+	return nil
+}
+
+// sendDueReminders sends the reminders of every group that is due this week
+// and was not reminded yet, and returns how many students of each group got
+// one. Each group and week is claimed in the store first, so instances
+// checking at the same time send it once.
+func (app *App) sendDueReminders(now time.Time) map[string]int {
+	sent := map[string]int{}
+	for _, group := range app.reminders.groups {
+		key := reminderKey(group, now)
+		app.reminders.mu.Lock()
+		recheck := app.reminders.recheck[key]
+		app.reminders.mu.Unlock()
+		if now.Before(recheck) {
+			continue
+		}
+		if at, ok := app.reminders.dueAt(group, now); !ok || at.After(now) {
+			continue
+		}
+
+		claimed, err := app.Db.ClaimWebhookEvent(key, reminderLease)
+		if err != nil {
+			app.ErrorLogger.Println("\n\tError claiming reminders", key, ":", err)
+			continue
+		}
+		// another instance sent them, or is sending them and may still stop
+		// before it is done
+		recheck = now.Add(reminderLease)
+		if claimed {
+			// left unfinished, the claim expires and a later check sends
+			// them again
+			count, err := app.sendReminders(now, group.includes)
+			if err != nil {
+				app.ErrorLogger.Println("\n\tError sending reminders", key, ":", err)
+				continue
+			}
+			if err := app.Db.CompleteWebhookEvent(key, reminderSentTTL); err != nil {
+				app.WarnLogger.Println("\n\tError completing reminders", key, ":", err)
+			}
+			app.InfoLogger.Println("\n\tWeekly reminders", key, "sent to", count, "students")
+			sent[group.name] = count
+			recheck = now.Add(reminderSentTTL)
+		}
+		app.reminders.mu.Lock()
+		app.reminders.recheck[key] = recheck
+		app.reminders.mu.Unlock()
+	}
+	return sent
+}
+
+// sendReminders pushes a reminder to every student of the group who missed
+// something this week. A failed push is logged and not sent again.
+func (app *App) sendReminders(now time.Time, inGroup func(user *db.UserData) bool) (int, error) {
+	users, err := app.Db.ListUsers()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, user := range users {
+		// teachers and users who have not registered are not reminded
+		if user.Role != db.Student || user.TestNumber == -1 || user.ReminderOptOut || !inGroup(user) {
+			continue
+		}
+		progress := user.WeeklyProgress(now)
+		if progress.Videos > 0 && progress.Reflections > 0 && progress.PreviewNotes > 0 {
+			continue
+		}
+
+		err := app.withRetry(lineEndpoint, func() error {
+			_, err := app.botFor(user).SendWeeklyReminderPush(user, progress, now)
+			return err
+		})
+		if err != nil {
+			app.WarnLogger.Println("\n\tError sending reminder to", user.Id, ":", err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// handleReminderCommand turns the weekly reminders on or off and reports
+// whether the text was one of those commands.
+func (app *App) handleReminderCommand(event *linebot.Event, user *db.UserData, session *db.UserSession, command string, arg string) bool {
+	if (command != reminderOffCommand && command != reminderOnCommand) || arg != "" {
+		return false
+	}
+	if _, ok := app.transition(event.ReplyToken, user, session, fsm.Input{Event: fsm.EventMenu}); !ok {
+		return true
+	}
+
+	bot := app.botFor(user)
+	optOut := command == reminderOffCommand
+	if err := app.Db.UpdateUserReminderOptOut(user, optOut); err != nil {
+		app.ErrorLogger.Println("\n\tError updating reminder opt-out:", err)
+		app.replyError(user, event.ReplyToken, "reminder")
+		return true
+	}
+	if optOut {
+		bot.SendReply(event.ReplyToken, bot.T("reminder.off", app.Messages.Command(bot.Lang(), reminderOnCommand)))
+	} else {
+		bot.SendReply(event.ReplyToken, bot.T("reminder.on"))
+	}
+	return true
+}
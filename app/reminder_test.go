@@ -0,0 +1,83 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/HeavenAQ/scheduler"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+func TestReminderDueAt(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		now  time.Time
+		want time.Time
+		due  bool
+	}{
+		{"before the schedule", "0 19 * * 5", time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC), time.Time{}, false},
+		{"at the schedule", "0 19 * * 5", time.Date(2024, 3, 8, 19, 0, 0, 0, time.UTC), time.Date(2024, 3, 8, 19, 0, 0, 0, time.UTC), true},
+		{"missed earlier in the week", "0 19 * * 5", time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2024, 3, 8, 19, 0, 0, 0, time.UTC), true},
+		{"in quiet hours", "30 23 * * 3", time.Date(2024, 3, 6, 23, 30, 0, 0, time.UTC), time.Date(2024, 3, 7, 8, 0, 0, 0, time.UTC), true},
+		{"missed last week", "0 19 * * 5", time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC), time.Time{}, false},
+	}
+
+	quiet, err := scheduler.ParseQuietHours("22:00-08:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminders := &reminderSchedule{quiet: quiet}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := scheduler.Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			at, due := reminders.dueAt(reminderGroup{name: "default", schedule: schedule}, tt.now)
+			if due != tt.due || !at.Equal(tt.want) {
+				t.Errorf("due at %v (%v), want %v (%v)", at, due, tt.want, tt.due)
+			}
+		})
+	}
+}
+
+func TestDueRemindersSentOnce(t *testing.T) {
+	app := newTestApp(t)
+	app.send(&linebot.Event{Type: linebot.EventTypeFollow})
+	app.sendText("12")
+
+	// a second instance sharing the store
+	other := newTestApp(t)
+	other.Db = app.Db
+	for _, instance := range []*testApp{app, other} {
+		reminders, err := newReminderSchedule(&defaultReminderConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.reminders = reminders
+	}
+
+	steps := []struct {
+		instance *testApp
+		now      time.Time
+		want     int
+	}{
+		{app, time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC), 0},
+		{app, time.Date(2024, 3, 8, 19, 0, 0, 0, time.UTC), 1},
+		{other, time.Date(2024, 3, 8, 19, 1, 0, 0, time.UTC), 0},
+		{app, time.Date(2024, 3, 8, 19, 2, 0, 0, time.UTC), 0},
+		// the week after, the second instance catches up on a missed one
+		{other, time.Date(2024, 3, 16, 10, 0, 0, 0, time.UTC), 1},
+		{app, time.Date(2024, 3, 16, 10, 1, 0, 0, time.UTC), 0},
+	}
+	for i, step := range steps {
+		sent := step.instance.sendDueReminders(step.now)
+		if sent["default"] != step.want {
+			t.Fatalf("step %d at %v: sent %v, want %d", i, step.now, sent, step.want)
+		}
+	}
+	if pushes := app.line.pushCount() + other.line.pushCount(); pushes != 2 {
+		t.Errorf("%d reminders pushed, want 2", pushes)
+	}
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/HeavenAQ/config"
+	"github.com/HeavenAQ/metrics"
+	"github.com/HeavenAQ/resilience"
+)
+
+// endpoints guarded by a circuit breaker
+const (
+	genaiEndpoint   = "genai"
+	storageEndpoint = "storage"
+	lineEndpoint    = "line"
+)
+
+func newBreakers(cfg config.Breaker, logger *log.Logger) *resilience.Breakers {
+	return resilience.NewBreakers(
+		cfg.Threshold,
+		cfg.Cooldown,
+		func(name string, from resilience.BreakerState, to resilience.BreakerState) {
+			logger.Println("\n\tCircuit breaker", name, "changed from", from, "to", to)
+		},
+	)
+}
+
+func retryPolicy(logger *log.Logger, endpoint string, maxAttempts int, backoff resilience.Backoff) resilience.Policy {
+	return resilience.Policy{
+		MaxAttempts:   maxAttempts,
+		Backoff:       backoff,
+		MaxRetryAfter: 2 * time.Minute,
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			logger.Println("\n\t"+endpoint, "call failed, retry", attempt, "in", delay.Round(time.Millisecond), ":", err)
+			metrics.Retries.WithLabelValues(endpoint).Inc()
+		},
+	}
+}
+
+// genaiPolicy retries the AI server for a few minutes before the job is
+// delayed, see delayJob.
+func genaiPolicy(cfg config.Analysis, logger *log.Logger) resilience.Policy {
+	return retryPolicy(logger, genaiEndpoint, cfg.MaxAttempts, resilience.Backoff{
+		Initial:    5 * time.Second,
+		Max:        time.Minute,
+		Multiplier: 2,
+	})
+}
+
+// withRetry calls a storage or LINE endpoint through its breaker.
+func (app *App) withRetry(endpoint string, fn func() error) error {
+	policy := retryPolicy(app.WarnLogger, endpoint, 4, resilience.Backoff{
+		Initial:    time.Second,
+		Max:        20 * time.Second,
+		Multiplier: 2,
+	})
+	return resilience.Call(context.Background(), app.Breakers.Get(endpoint), policy, func(ctx context.Context) error {
+		return fn()
+	})
+}
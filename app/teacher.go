@@ -0,0 +1,221 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/line"
+	"github.com/HeavenAQ/fsm"
+	"github.com/HeavenAQ/richmenu"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// text commands taking an argument, e.g. "加入班級 A1" or "Join Class A1"
+const (
+	verifyTeacherCommand = "verify_teacher"
+	joinClassCommand     = "join_class"
+	studentListCommand   = "student_list"
+	weeklyReportCommand  = "weekly_report"
+	viewStudentCommand   = "view_student"
+	faultCommand         = "faults"
+)
+
+// handleClassCommand resolves the class and teacher commands and reports
+// whether the text was one of them.
+func (app *App) handleClassCommand(event *linebot.Event, user *db.UserData, session *db.UserSession, command string, arg string) bool {
+	replyToken := event.ReplyToken
+	bot := app.botFor(user)
+
+	switch command {
+	case verifyTeacherCommand, joinClassCommand:
+	case studentListCommand, weeklyReportCommand, viewStudentCommand, faultCommand:
+		if user.Role != db.Teacher {
+			bot.SendReply(replyToken, bot.T("teacher.only"))
+			return true
+		}
+		if user.ClassCode == "" {
+			bot.SendReply(replyToken, bot.T("class.required", app.Messages.Command(bot.Lang(), joinClassCommand)))
+			return true
+		}
+	default:
+		return false
+	}
+
+	if _, ok := app.transition(replyToken, user, session, fsm.Input{Event: fsm.EventMenu}); !ok {
+		return true
+	}
+
+	var err error
+	switch command {
+	case verifyTeacherCommand:
+		err = app.verifyTeacher(replyToken, user, arg)
+	case joinClassCommand:
+		err = app.joinClass(replyToken, user, arg)
+	case studentListCommand:
+		err = app.sendStudentList(replyToken, user)
+	case weeklyReportCommand:
+		err = app.sendWeeklyReport(replyToken, user)
+	case viewStudentCommand:
+		err = app.promptViewStudent(replyToken, user, arg)
+	case faultCommand:
+		err = app.sendFaultReport(replyToken, user, arg)
+	}
+	if err != nil {
+		app.ErrorLogger.Println("\n\tError handling class command:", err)
+		app.replyError(user, replyToken, "class_command")
+	}
+	return true
+}
+
+func (app *App) verifyTeacher(replyToken string, user *db.UserData, passcode string) error {
+	bot := app.botFor(user)
+	expected := app.Config.TeacherPasscode
+	if expected == "" || subtle.ConstantTimeCompare([]byte(passcode), []byte(expected)) != 1 {
+		app.WarnLogger.Println("\n\tInvalid teacher passcode from user", user.Id)
+		_, err := bot.SendReply(replyToken, bot.T("teacher.invalid_passcode"))
+		return err
+	}
+
+	if err := app.Db.UpdateUserRole(user, db.Teacher); err != nil {
+		return err
+	}
+	app.linkRoleMenu(user)
+	_, err := bot.SendReply(replyToken, bot.T("teacher.verified", app.Messages.Command(bot.Lang(), joinClassCommand)))
+	return err
+}
+
+func (app *App) joinClass(replyToken string, user *db.UserData, classCode string) error {
+	bot := app.botFor(user)
+	if classCode == "" || len(classCode) > 32 {
+		_, err := bot.SendReply(replyToken, bot.T("class.join_usage", app.Messages.Command(bot.Lang(), joinClassCommand)))
+		return err
+	}
+
+	if err := app.Db.UpdateUserClassCode(user, classCode); err != nil {
+		return err
+	}
+	_, err := bot.SendReply(replyToken, bot.T("class.joined", classCode))
+	return err
+}
+
+// getClassStudents returns the students of the teacher's class, leaving out
+// other teachers.
+func (app *App) getClassStudents(teacher *db.UserData) ([]*db.UserData, error) {
+	users, err := app.Db.GetUsersByClass(teacher.ClassCode)
+	if err != nil {
+		return nil, err
+	}
+
+	students := []*db.UserData{}
+	for _, user := range users {
+		if user.Role == db.Student {
+			students = append(students, user)
+		}
+	}
+	return students, nil
+}
+
+func (app *App) sendStudentList(replyToken string, teacher *db.UserData) error {
+	students, err := app.getClassStudents(teacher)
+	if err != nil {
+		return err
+	}
+	_, err = app.botFor(teacher).SendStudentList(replyToken, teacher.ClassCode, students)
+	return err
+}
+
+func (app *App) sendWeeklyReport(replyToken string, teacher *db.UserData) error {
+	students, err := app.getClassStudents(teacher)
+	if err != nil {
+		return err
+	}
+	_, err = app.botFor(teacher).SendWeeklyProgressReport(replyToken, teacher.ClassCode, students, time.Now().In(app.Location))
+	return err
+}
+
+// sendFaultReport lists the fault codes of the class, or the works having
+// the given code.
+func (app *App) sendFaultReport(replyToken string, teacher *db.UserData, code string) error {
+	students, err := app.getClassStudents(teacher)
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		_, err = app.botFor(teacher).SendFaultCodes(replyToken, teacher.ClassCode, db.CountFaults(students))
+		return err
+	}
+	matches := db.FindFaults(students, code, "", "")
+	_, err = app.botFor(teacher).SendFaultReport(replyToken, teacher.ClassCode, code, matches)
+	return err
+}
+
+func (app *App) promptViewStudent(replyToken string, teacher *db.UserData, arg string) error {
+	bot := app.botFor(teacher)
+	testNumber, err := strconv.Atoi(arg)
+	if err != nil {
+		_, err := bot.SendReply(replyToken, bot.T("student.view_usage", app.Messages.Command(bot.Lang(), viewStudentCommand)))
+		return err
+	}
+
+	students, err := app.getClassStudents(teacher)
+	if err != nil {
+		return err
+	}
+	for _, student := range students {
+		if student.TestNumber == testNumber {
+			_, err := bot.PromptStudentSkillSelection(replyToken, student)
+			return err
+		}
+	}
+	_, err = bot.SendReply(replyToken, bot.T("student.not_found", arg))
+	return err
+}
+
+// resolveViewStudentPortfolio shows a student's portfolio to a teacher of the
+// same class.
+func (app *App) resolveViewStudentPortfolio(event *linebot.Event, teacher *db.UserData, session *db.UserSession, postback *line.StudentPortfolioPostback) error {
+	bot := app.botFor(teacher)
+	if teacher.Role != db.Teacher {
+		_, err := bot.SendReply(event.ReplyToken, bot.T("teacher.only"))
+		return err
+	}
+	if teacher.ClassCode == "" {
+		_, err := bot.SendReply(event.ReplyToken, bot.T("class.required", app.Messages.Command(bot.Lang(), joinClassCommand)))
+		return err
+	}
+
+	student, err := app.Db.GetUserData(postback.StudentId)
+	if err != nil {
+		return err
+	}
+	if student.ClassCode != teacher.ClassCode {
+		return errors.New("\n\tStudent " + student.Id + " is not in class " + teacher.ClassCode)
+	}
+
+	skill, ok := app.Skills.Get(postback.Skill)
+	if !ok {
+		return errors.New("\n\tUnknown skill: " + postback.Skill)
+	}
+
+	if _, ok := app.transition(event.ReplyToken, teacher, session, fsm.Input{Event: fsm.EventStudent}); !ok {
+		return nil
+	}
+	return bot.ResolveViewPortfolio(event, student, skill, db.None)
+}
+
+// linkRoleMenu shows the rich menu of the user's role. A failure only costs
+// the user the shortcuts, so it is logged instead of failing the reply.
+func (app *App) linkRoleMenu(user *db.UserData) {
+	var err error
+	if menu, ok := richmenu.ForRole(user.Role); ok {
+		err = app.Bot.LinkRichMenu(menu.Alias, user.Id)
+	} else {
+		err = app.Bot.UnlinkRichMenu(user.Id)
+	}
+	if err != nil {
+		app.WarnLogger.Println("\n\tError linking the rich menu of user", user.Id, ":", err)
+	}
+}
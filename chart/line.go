@@ -0,0 +1,171 @@
+// Package chart renders simple PNG charts without external services so the
+// bot can serve them itself.
+package chart
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var ErrNoPoints = errors.New("chart has no points")
+
+// Point is one value on the x axis. Labels are drawn with an ASCII font, so
+// they should be dates or numbers.
+type Point struct {
+	Label string
+	Value float64
+}
+
+// LineChart draws points left to right on a fixed y range.
+type LineChart struct {
+	Width  int
+	Height int
+	Min    float64
+	Max    float64
+	// GridStep is the distance between horizontal grid lines in y units
+	GridStep float64
+	Line     color.Color
+}
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	gridColor  = color.RGBA{0xe6, 0xe6, 0xe6, 0xff}
+	axisColor  = color.RGBA{0x8c, 0x8c, 0x8c, 0xff}
+	labelColor = color.RGBA{0x66, 0x66, 0x66, 0xff}
+)
+
+// DefaultLineChart fits the 0-100 AI rating and the 20:13 hero image of a
+// Flex bubble.
+func DefaultLineChart() LineChart {
+	return LineChart{
+		Width:    1040,
+		Height:   676,
+		Min:      0,
+		Max:      100,
+		GridStep: 20,
+		Line:     color.RGBA{0x06, 0xc7, 0x55, 0xff},
+	}
+}
+
+const (
+	marginLeft   = 60
+	marginRight  = 30
+	marginTop    = 30
+	marginBottom = 50
+	pointRadius  = 6
+	lineWidth    = 4
+)
+
+// Render writes the chart as a PNG.
+func (c LineChart) Render(w io.Writer, points []Point) error {
+	if len(points) == 0 {
+		return ErrNoPoints
+	}
+	if c.Max <= c.Min {
+		return errors.New("chart max must be greater than min")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{background}, image.Point{}, draw.Src)
+
+	left, right := marginLeft, c.Width-marginRight
+	top, bottom := marginTop, c.Height-marginBottom
+	y := func(value float64) int {
+		value = math.Max(c.Min, math.Min(c.Max, value))
+		return bottom - int(math.Round((value-c.Min)/(c.Max-c.Min)*float64(bottom-top)))
+	}
+	x := func(i int) int {
+		if len(points) == 1 {
+			return (left + right) / 2
+		}
+		return left + i*(right-left)/(len(points)-1)
+	}
+
+	// grid and y labels
+	if c.GridStep > 0 {
+		for value := c.Min; value <= c.Max; value += c.GridStep {
+			fillRect(img, left, y(value), right, y(value)+1, gridColor)
+			label := strconv.FormatFloat(value, 'f', -1, 64)
+			drawText(img, left-10-textWidth(label), y(value)+5, label)
+		}
+	}
+	fillRect(img, left, top, left+2, bottom, axisColor)
+	fillRect(img, left, bottom, right, bottom+2, axisColor)
+
+	// x labels, thinned out so they do not overlap
+	maxLabels := (right - left) / 80
+	every := 1
+	if maxLabels > 0 && len(points) > maxLabels {
+		every = (len(points) + maxLabels - 1) / maxLabels
+	}
+	for i, point := range points {
+		if i%every != 0 && i != len(points)-1 {
+			continue
+		}
+		drawText(img, x(i)-textWidth(point.Label)/2, bottom+25, point.Label)
+	}
+
+	// line and points
+	for i := 1; i < len(points); i++ {
+		drawLine(img, x(i-1), y(points[i-1].Value), x(i), y(points[i].Value), lineWidth, c.Line)
+	}
+	for i, point := range points {
+		fillCircle(img, x(i), y(point.Value), pointRadius, c.Line)
+	}
+
+	return png.Encode(w, img)
+}
+
+func fillRect(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.Color) {
+	draw.Draw(img, image.Rect(x0, y0, x1, y1), &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+func fillCircle(img *image.RGBA, cx int, cy int, r int, c color.Color) {
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy <= r*r {
+				img.Set(cx+dx, cy+dy, c)
+			}
+		}
+	}
+}
+
+// drawLine stamps a filled circle along the segment, which keeps the
+// thickness even at any slope.
+func drawLine(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, width int, c color.Color) {
+	steps := int(math.Max(math.Abs(float64(x1-x0)), math.Abs(float64(y1-y0))))
+	if steps == 0 {
+		fillCircle(img, x0, y0, width/2, c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		px := int(math.Round(float64(x0) + t*float64(x1-x0)))
+		py := int(math.Round(float64(y0) + t*float64(y1-y0)))
+		fillCircle(img, px, py, width/2, c)
+	}
+}
+
+func textWidth(text string) int {
+	return font.MeasureString(basicfont.Face7x13, text).Round()
+}
+
+func drawText(img *image.RGBA, x int, y int, text string) {
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  &image.Uniform{labelColor},
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
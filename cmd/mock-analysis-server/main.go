@@ -0,0 +1,82 @@
+// Command mock-analysis-server runs a stand-in for the AI server so the video
+// upload flow can be exercised offline. Point GENAI_URL at it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/HeavenAQ/api/analysis"
+)
+
+func parseStatuses(value string) []int {
+	statuses := []int{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		status, err := strconv.Atoi(field)
+		if err != nil || status < 100 || status > 599 {
+			log.Fatal("invalid status code: ", field)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func main() {
+	config := analysis.MockServerConfig{}
+	addr := flag.String("addr", ":8081", "address to listen on")
+	statuses := flag.String("statuses", "", "comma separated statuses answered before succeeding, e.g. 502,500")
+	errorCodes := flag.String("error-codes", "500,502,503,504", "statuses picked for random errors")
+	video := flag.String("video", "", "canned skeleton video file, the upload is echoed back when empty")
+	suggestions := flag.String("suggestions", "", "suggestions separated by |")
+	details := flag.String("details", "", `structured results: "sample" or a JSON file of phases, joint_angles, faults and keyframes`)
+	flag.DurationVar(&config.Latency, "latency", 0, "delay before every answer")
+	flag.DurationVar(&config.RetryAfter, "retry-after", 0, "Retry-After sent with 429 and 503 answers")
+	flag.Float64Var(&config.ErrorRate, "error-rate", 0, "chance of a random error after the statuses are used up")
+	flag.StringVar(&config.Score, "score", "80", "score returned")
+	flag.StringVar(&config.User, "user", os.Getenv("GENAI_USER"), "basic auth user, disabled when empty")
+	flag.StringVar(&config.Password, "password", os.Getenv("GENAI_PASSWORD"), "basic auth password")
+	flag.Parse()
+
+	config.Statuses = parseStatuses(*statuses)
+	config.ErrorCodes = parseStatuses(*errorCodes)
+	if *suggestions != "" {
+		config.Suggestions = strings.Split(*suggestions, "|")
+	}
+	if *video != "" {
+		blob, err := os.ReadFile(*video)
+		if err != nil {
+			log.Fatal("Error reading skeleton video: ", err)
+		}
+		config.SkeletonVideo = blob
+	}
+
+	switch *details {
+	case "":
+	case "sample":
+		config.Details = analysis.SampleDetails()
+	default:
+		blob, err := os.ReadFile(*details)
+		if err != nil {
+			log.Fatal("Error reading details: ", err)
+		}
+		if err := json.Unmarshal(blob, &config.Details); err != nil {
+			log.Fatal("Error parsing details: ", err)
+		}
+	}
+
+	logger := log.New(log.Writer(), "[INFO] ", log.LstdFlags)
+	server := analysis.NewMockServer(config, logger)
+	logger.Println("\n\tMock analysis server started on " + *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,224 @@
+// Command richmenu deploys the rich menus defined in the richmenu package,
+// so the buttons always send commands the bot understands.
+//
+//	richmenu print                  print the menus sent to LINE
+//	richmenu deploy -images <dir>   create the menus, set the default one and link users
+//	richmenu link                   link every user to the menu of their role
+//	richmenu list                   list the menus on the channel
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/HeavenAQ/api/line"
+	"github.com/HeavenAQ/app"
+	"github.com/HeavenAQ/config"
+	"github.com/HeavenAQ/i18n"
+	"github.com/HeavenAQ/richmenu"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const usage = `usage: richmenu <command> [flags]
+
+commands:
+  print    print the menus sent to LINE
+  deploy   create the menus, set the default one and link users by role
+  link     link every user to the menu of their role
+  list     list the menus on the channel
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// only the settings of LINE, the locales and the database are used, so
+	// the rest is not validated
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Error loading config: ", err)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	lang := flags.String("lang", i18n.DefaultLanguage, "language of the chat bar labels and command texts")
+	locales := flags.String("locales", cfg.LocalesDir, "directory of locale overrides")
+	images := flags.String("images", "", "directory of <alias>.png or <alias>.jpg menu images, required by deploy")
+	link := flags.Bool("link", true, "link users to the menu of their role after deploying")
+	flags.Parse(os.Args[2:])
+
+	messages, err := i18n.Load(*locales)
+	if err != nil {
+		log.Fatal("Error loading locales: ", err)
+	}
+	if !messages.Supports(*lang) {
+		log.Fatal("Unknown language: ", *lang)
+	}
+	menus := buildMenus(messages, *lang)
+
+	switch os.Args[1] {
+	case "print":
+		printMenus(menus)
+	case "deploy":
+		if *images == "" {
+			log.Fatal("deploy needs -images")
+		}
+		bot := newBot(cfg, messages)
+		deploy(bot, menus, *images)
+		if *link {
+			linkUsers(cfg, bot)
+		}
+		prune(bot)
+	case "link":
+		linkUsers(cfg, newBot(cfg, messages))
+	case "list":
+		list(newBot(cfg, messages))
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func buildMenus(messages *i18n.Catalog, lang string) []linebot.RichMenu {
+	objects := []linebot.RichMenu{}
+	for _, menu := range richmenu.Menus {
+		object, err := menu.Build(messages, lang)
+		if err != nil {
+			log.Fatal("Error building rich menu: ", err)
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// printMenus prints the menus as the JSON sent to LINE, which the SDK types
+// do not marshal to by themselves.
+func printMenus(objects []linebot.RichMenu) {
+	type richMenu struct {
+		Size        linebot.RichMenuSize `json:"size"`
+		Selected    bool                 `json:"selected"`
+		Name        string               `json:"name"`
+		ChatBarText string               `json:"chatBarText"`
+		Areas       []linebot.AreaDetail `json:"areas"`
+	}
+	menus := []richMenu{}
+	for _, object := range objects {
+		menus = append(menus, richMenu(object))
+	}
+	blob, err := json.MarshalIndent(menus, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(blob))
+}
+
+func newBot(cfg *config.Config, messages *i18n.Catalog) *line.LineBotHandler {
+	bot, err := line.NewLineBotHandler(cfg.Line.ChannelSecret, cfg.Line.ChannelToken, nil, nil, messages)
+	if err != nil {
+		log.Fatal("Error initializing line bot client: ", err)
+	}
+	return bot
+}
+
+// deploy creates every menu and points its alias at it. Images are checked
+// first so a bad image does not leave half of the menus deployed.
+func deploy(bot *line.LineBotHandler, objects []linebot.RichMenu, dir string) {
+	paths := []string{}
+	for _, menu := range richmenu.Menus {
+		path, err := richmenu.FindImage(dir, menu)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := richmenu.CheckImage(path, menu.Size); err != nil {
+			log.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	for i, menu := range richmenu.Menus {
+		id, err := bot.CreateRichMenu(objects[i], paths[i])
+		if err != nil {
+			log.Fatal("Error creating rich menu ", menu.Alias, ": ", err)
+		}
+		if err := bot.SetRichMenuAlias(menu.Alias, id); err != nil {
+			log.Fatal("Error setting rich menu alias ", menu.Alias, ": ", err)
+		}
+		log.Println("Created rich menu", menu.Alias, id)
+		if i == 0 {
+			if err := bot.SetDefaultRichMenu(id); err != nil {
+				log.Fatal("Error setting the default rich menu: ", err)
+			}
+			log.Println("Set the default rich menu to", menu.Alias)
+		}
+	}
+}
+
+// linkUsers links the users of every role with its own menu. Links point at
+// a menu id, so they are renewed on every deploy.
+func linkUsers(cfg *config.Config, bot *line.LineBotHandler) {
+	secrets, err := app.NewSecrets(cfg, log.Default())
+	if err != nil {
+		log.Fatal("Error initializing secret provider: ", err)
+	}
+	store, err := app.NewStore(cfg, secrets)
+	if err != nil {
+		log.Fatal("Error initializing database client: ", err)
+	}
+	users, err := store.ListUsers()
+	if err != nil {
+		log.Fatal("Error listing users: ", err)
+	}
+
+	linked := map[string][]string{}
+	for _, user := range users {
+		if menu, ok := richmenu.ForRole(user.Role); ok {
+			linked[menu.Alias] = append(linked[menu.Alias], user.Id)
+		}
+	}
+	for alias, userIds := range linked {
+		if err := bot.LinkRichMenu(alias, userIds...); err != nil {
+			log.Fatal("Error linking rich menu ", alias, ": ", err)
+		}
+		log.Println("Linked", len(userIds), "users to rich menu", alias)
+	}
+}
+
+// prune deletes the menus replaced by this deploy, once nothing points at
+// them anymore.
+func prune(bot *line.LineBotHandler) {
+	for _, menu := range richmenu.Menus {
+		id, err := bot.GetRichMenuId(menu.Alias)
+		if err != nil {
+			log.Fatal("Error resolving rich menu alias ", menu.Alias, ": ", err)
+		}
+		deleted, err := bot.DeleteRichMenus(menu.Alias, id)
+		if err != nil {
+			log.Fatal("Error deleting old rich menus: ", err)
+		}
+		for _, old := range deleted {
+			log.Println("Deleted old rich menu", menu.Alias, old)
+		}
+	}
+}
+
+func list(bot *line.LineBotHandler) {
+	menus, err := bot.ListRichMenus()
+	if err != nil {
+		log.Fatal("Error listing rich menus: ", err)
+	}
+	defaultId, err := bot.GetDefaultRichMenu()
+	if err != nil {
+		log.Fatal("Error getting the default rich menu: ", err)
+	}
+	for _, menu := range menus {
+		mark := ""
+		if menu.RichMenuID == defaultId {
+			mark = " (default)"
+		}
+		fmt.Printf("%v\t%v\t%v%v\n", menu.RichMenuID, menu.Name, menu.ChatBarText, mark)
+	}
+}
@@ -0,0 +1,191 @@
+// Package config loads the settings of the bot once at startup. Values come
+// from the environment, a .env file and an optional YAML file at CONFIG_FILE,
+// in that order of precedence, on top of the defaults below.
+package config
+
+import (
+	"time"
+
+	"github.com/HeavenAQ/api/video"
+)
+
+// Every setting has a YAML key and an environment variable. Settings tagged
+// secret are never printed.
+type Config struct {
+	Port string `yaml:"port" env:"PORT"`
+	// PublicBaseURL is where the bot is reachable, for chart and local
+	// media links
+	PublicBaseURL   string        `yaml:"publicBaseUrl" env:"PUBLIC_BASE_URL"`
+	GCPProjectID    string        `yaml:"gcpProjectId" env:"GCP_PROJECT_ID"`
+	AdminToken      string        `yaml:"adminToken" env:"ADMIN_TOKEN" secret:"true"`
+	TeacherPasscode string        `yaml:"teacherPasscode" env:"TEACHER_PASSCODE" secret:"true"`
+	ChartSigningKey string        `yaml:"chartSigningKey" env:"CHART_SIGNING_KEY" secret:"true"`
+	WebhookDedupTTL time.Duration `yaml:"webhookDedupTtl" env:"WEBHOOK_DEDUP_TTL"`
+	// WebhookClaimLease is how long an event being handled is held before a
+	// redelivery may handle it again
+	WebhookClaimLease time.Duration `yaml:"webhookClaimLease" env:"WEBHOOK_CLAIM_LEASE"`
+	SkillsConfig      string        `yaml:"skillsConfig" env:"SKILLS_CONFIG"`
+	LocalesDir        string        `yaml:"localesDir" env:"LOCALES_DIR"`
+	ReminderConfig    string        `yaml:"reminderConfig" env:"REMINDER_CONFIG"`
+	// TimeZone is the zone work dates are stamped in and weekly reminders
+	// run in
+	TimeZone string `yaml:"timeZone" env:"TIME_ZONE"`
+
+	Server   Server   `yaml:"server"`
+	Line     Line     `yaml:"line"`
+	Secrets  Secrets  `yaml:"secrets"`
+	Database Database `yaml:"database"`
+	Firebase Firebase `yaml:"firebase"`
+	Storage  Storage  `yaml:"storage"`
+	Drive    Drive    `yaml:"drive"`
+	Analysis Analysis `yaml:"analysis"`
+	Breaker  Breaker  `yaml:"breaker"`
+	Jobs     Jobs     `yaml:"jobs"`
+	Video    Video    `yaml:"video"`
+}
+
+type Server struct {
+	ReadTimeout  time.Duration `yaml:"readTimeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"writeTimeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idleTimeout" env:"HTTP_IDLE_TIMEOUT"`
+	// ShutdownTimeout bounds the wait for in-flight webhooks and analyses
+	// after SIGTERM; Cloud Run kills the container 10s after it
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	// ProbeTimeout bounds each dependency check of /readyz
+	ProbeTimeout time.Duration `yaml:"probeTimeout" env:"HEALTH_PROBE_TIMEOUT"`
+}
+
+type Line struct {
+	ChannelSecret string `yaml:"channelSecret" env:"CHANNEL_SECRET" secret:"true"`
+	ChannelToken  string `yaml:"channelToken" env:"CHANNEL_TOKEN" secret:"true"`
+}
+
+// Secrets selects where the FIREBASE_CREDENTIALS and
+// GOOGLE_DRIVE_CREDENTIALS secrets are read from.
+type Secrets struct {
+	// Backend is gcp for Secret Manager, env for SECRET_<ID> variables or
+	// file for files named after the id in Dir
+	Backend  string        `yaml:"backend" env:"SECRETS_BACKEND"`
+	Dir      string        `yaml:"dir" env:"SECRETS_DIR"`
+	CacheTTL time.Duration `yaml:"cacheTtl" env:"SECRETS_CACHE_TTL"`
+}
+
+type Database struct {
+	// Backend is firebase, sqlite, postgres or memory
+	Backend string `yaml:"backend" env:"DB_BACKEND"`
+	DSN     string `yaml:"dsn" env:"DB_DSN" secret:"true"`
+}
+
+type Firebase struct {
+	ProjectID string `yaml:"projectId" env:"FIREBASE_PROJECT_ID"`
+	// Credentials is the id of the secret holding the service account
+	Credentials   string `yaml:"credentials" env:"FIREBASE_CREDENTIALS"`
+	Users         string `yaml:"users" env:"FIREBASE_USERS"`
+	Sessions      string `yaml:"sessions" env:"FIREBASE_SESSIONS"`
+	Jobs          string `yaml:"jobs" env:"FIREBASE_JOBS"`
+	WebhookEvents string `yaml:"webhookEvents" env:"FIREBASE_WEBHOOK_EVENTS"`
+}
+
+type Storage struct {
+	// Backend is drive or local
+	Backend  string `yaml:"backend" env:"STORAGE_BACKEND"`
+	LocalDir string `yaml:"localDir" env:"LOCAL_STORAGE_DIR"`
+	// SigningKey signs the links to locally stored videos and thumbnails
+	SigningKey string `yaml:"signingKey" env:"MEDIA_SIGNING_KEY" secret:"true"`
+}
+
+type Drive struct {
+	// Credentials is the id of the secret holding the service account
+	Credentials       string `yaml:"credentials" env:"GOOGLE_DRIVE_CREDENTIALS"`
+	RootFolderID      string `yaml:"rootFolderId" env:"GOOGLE_DRIVE_ROOT_FOLDER_ID"`
+	ThumbnailFolderID string `yaml:"thumbnailFolderId" env:"GOOGLE_DRIVE_THUMBNAIL_FOLDER_ID"`
+}
+
+type Analysis struct {
+	// Backend is http, or fake to answer uploads locally
+	Backend     string `yaml:"backend" env:"ANALYSIS_BACKEND"`
+	URL         string `yaml:"url" env:"GENAI_URL"`
+	User        string `yaml:"user" env:"GENAI_USER"`
+	Password    string `yaml:"password" env:"GENAI_PASSWORD" secret:"true"`
+	MaxAttempts int    `yaml:"maxAttempts" env:"GENAI_MAX_ATTEMPTS"`
+}
+
+type Breaker struct {
+	Threshold int           `yaml:"threshold" env:"BREAKER_THRESHOLD"`
+	Cooldown  time.Duration `yaml:"cooldown" env:"BREAKER_COOLDOWN"`
+}
+
+type Jobs struct {
+	Workers    int           `yaml:"workers" env:"JOB_WORKERS"`
+	QueueSize  int           `yaml:"queueSize" env:"JOB_QUEUE_SIZE"`
+	RetryDelay time.Duration `yaml:"retryDelay" env:"JOB_RETRY_DELAY"`
+	MaxDelays  int           `yaml:"maxDelays" env:"JOB_MAX_DELAYS"`
+	// Lease is how long a running job stays with its instance without a
+	// renewal before another instance may resume it
+	Lease        time.Duration `yaml:"lease" env:"JOB_LEASE"`
+	WorkspaceDir string        `yaml:"workspaceDir" env:"WORKSPACE_DIR"`
+}
+
+// Video holds the accepted uploads. A limit set to 0 is not checked.
+type Video struct {
+	MinDuration time.Duration `yaml:"minDuration" env:"VIDEO_MIN_DURATION"`
+	MaxDuration time.Duration `yaml:"maxDuration" env:"VIDEO_MAX_DURATION"`
+	TrimLong    bool          `yaml:"trimLong" env:"VIDEO_TRIM_LONG"`
+	MaxSizeMB   int           `yaml:"maxSizeMb" env:"VIDEO_MAX_SIZE_MB"`
+	MinFPS      float64       `yaml:"minFps" env:"VIDEO_MIN_FPS"`
+	MaxFPS      float64       `yaml:"maxFps" env:"VIDEO_MAX_FPS"`
+	Codecs      []string      `yaml:"codecs" env:"VIDEO_CODECS"`
+}
+
+func Default() *Config {
+	limits := video.DefaultLimits()
+	return &Config{
+		Port:              "8080",
+		WebhookDedupTTL:   24 * time.Hour,
+		WebhookClaimLease: time.Minute,
+		TimeZone:          "Asia/Taipei",
+		Server: Server{
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 8 * time.Second,
+			ProbeTimeout:    3 * time.Second,
+		},
+		Secrets:  Secrets{Backend: "gcp", CacheTTL: 5 * time.Minute},
+		Database: Database{Backend: "firebase"},
+		Firebase: Firebase{Jobs: "jobs", WebhookEvents: "webhook_events"},
+		Storage:  Storage{Backend: "drive"},
+		Analysis: Analysis{Backend: "http", MaxAttempts: 6},
+		Breaker:  Breaker{Threshold: 5, Cooldown: time.Minute},
+		Jobs: Jobs{
+			Workers:    2,
+			QueueSize:  100,
+			RetryDelay: 5 * time.Minute,
+			MaxDelays:  3,
+			Lease:      2 * time.Minute,
+		},
+		Video: Video{
+			MinDuration: limits.MinDuration,
+			MaxDuration: limits.MaxDuration,
+			TrimLong:    limits.TrimLong,
+			MaxSizeMB:   int(limits.MaxSize / 1024 / 1024),
+			MinFPS:      limits.MinFPS,
+			MaxFPS:      limits.MaxFPS,
+			Codecs:      limits.Codecs,
+		},
+	}
+}
+
+// VideoLimits returns the accepted uploads, keeping the output size of
+// video.DefaultLimits.
+func (c *Config) VideoLimits() video.Limits {
+	limits := video.DefaultLimits()
+	limits.MinDuration = c.Video.MinDuration
+	limits.MaxDuration = c.Video.MaxDuration
+	limits.TrimLong = c.Video.TrimLong
+	limits.MaxSize = int64(c.Video.MaxSizeMB) * 1024 * 1024
+	limits.MinFPS = c.Video.MinFPS
+	limits.MaxFPS = c.Video.MaxFPS
+	limits.Codecs = c.Video.Codecs
+	return limits
+}
@@ -0,0 +1,330 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig is the default firebase and drive deployment with every
+// required setting filled in.
+func validConfig() *Config {
+	c := Default()
+	c.GCPProjectID = "badminton"
+	c.Line = Line{ChannelSecret: "secret", ChannelToken: "token"}
+	c.Firebase.ProjectID = "badminton"
+	c.Firebase.Credentials = "firebase-credentials"
+	c.Firebase.Users = "users"
+	c.Firebase.Sessions = "sessions"
+	c.Drive = Drive{Credentials: "drive-credentials", RootFolderID: "root", ThumbnailFolderID: "thumbnails"}
+	c.Analysis.URL = "https://genai.example.com"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		// every message expected in the error, none for a valid config
+		want []string
+	}{
+		{
+			name:   "valid",
+			change: func(c *Config) {},
+		},
+		{
+			name: "local backends",
+			change: func(c *Config) {
+				c.GCPProjectID = ""
+				c.Database = Database{Backend: "sqlite", DSN: "bot.db"}
+				c.Storage = Storage{Backend: "local", LocalDir: "media", SigningKey: "key"}
+				c.PublicBaseURL = "https://bot.example.com"
+				c.Analysis.Backend = "fake"
+				c.Analysis.URL = ""
+			},
+		},
+		{
+			name:   "missing line channel",
+			change: func(c *Config) { c.Line = Line{} },
+			want:   []string{"CHANNEL_SECRET is required", "CHANNEL_TOKEN is required"},
+		},
+		{
+			name:   "missing firebase settings",
+			change: func(c *Config) { c.Firebase = Firebase{} },
+			want: []string{
+				"FIREBASE_PROJECT_ID is required",
+				"FIREBASE_CREDENTIALS is required",
+				"FIREBASE_JOBS is required",
+				"FIREBASE_WEBHOOK_EVENTS is required",
+			},
+		},
+		{
+			name:   "missing dsn",
+			change: func(c *Config) { c.Database = Database{Backend: "postgres"} },
+			want:   []string{"DB_DSN is required"},
+		},
+		{
+			name:   "missing drive settings",
+			change: func(c *Config) { c.Drive = Drive{} },
+			want: []string{
+				"GOOGLE_DRIVE_CREDENTIALS is required",
+				"GOOGLE_DRIVE_ROOT_FOLDER_ID is required",
+				"GOOGLE_DRIVE_THUMBNAIL_FOLDER_ID is required",
+			},
+		},
+		{
+			name:   "local storage without signing key",
+			change: func(c *Config) { c.Storage = Storage{Backend: "local", LocalDir: "media"} },
+			want:   []string{"MEDIA_SIGNING_KEY is required", "PUBLIC_BASE_URL is required"},
+		},
+		{
+			name:   "project only needed for gcp secrets",
+			change: func(c *Config) { c.GCPProjectID = "" },
+			want:   []string{"GCP_PROJECT_ID is required"},
+		},
+		{
+			name:   "file secrets without dir",
+			change: func(c *Config) { c.Secrets.Backend = "file" },
+			want:   []string{"SECRETS_DIR is required"},
+		},
+		{
+			name: "unknown backends",
+			change: func(c *Config) {
+				c.Secrets.Backend = "vault"
+				c.Database.Backend = "mysql"
+				c.Storage.Backend = "s3"
+				c.Analysis.Backend = "grpc"
+			},
+			want: []string{
+				`SECRETS_BACKEND must be gcp, env or file, got "vault"`,
+				`DB_BACKEND must be firebase, sqlite, postgres or memory, got "mysql"`,
+				`STORAGE_BACKEND must be drive or local, got "s3"`,
+				`ANALYSIS_BACKEND must be http or fake, got "grpc"`,
+			},
+		},
+		{
+			name:   "invalid time zone",
+			change: func(c *Config) { c.TimeZone = "Mars/Olympus" },
+			want:   []string{`TIME_ZONE must be a time zone name like Asia/Taipei, got "Mars/Olympus"`},
+		},
+		{
+			name:   "empty time zone",
+			change: func(c *Config) { c.TimeZone = "" },
+			want:   []string{"TIME_ZONE must be a time zone name"},
+		},
+		{
+			name: "malformed values",
+			change: func(c *Config) {
+				c.Port = "http"
+				c.PublicBaseURL = "bot.example.com"
+				c.Analysis.URL = "ftp://genai.example.com"
+				c.Jobs.Workers = 0
+				c.Video.MinFPS = -1
+			},
+			want: []string{
+				`PORT must be a port number, got "http"`,
+				`PUBLIC_BASE_URL must be an http or https url, got "bot.example.com"`,
+				`GENAI_URL must be an http or https url, got "ftp://genai.example.com"`,
+				"JOB_WORKERS must be positive",
+				"VIDEO_MIN_FPS must not be negative",
+			},
+		},
+		{
+			name: "inverted video limits",
+			change: func(c *Config) {
+				c.Video.MinDuration, c.Video.MaxDuration = time.Minute, time.Second
+				c.Video.Codecs = nil
+			},
+			want: []string{
+				"VIDEO_MIN_DURATION must not exceed VIDEO_MAX_DURATION",
+				"VIDEO_CODECS must list at least one codec",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.change(c)
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("valid config rejected: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("config accepted, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not report %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateReportsOnce(t *testing.T) {
+	c := validConfig()
+	c.Storage = Storage{Backend: "local", LocalDir: "media", SigningKey: "key"}
+	c.PublicBaseURL = ""
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("config without PUBLIC_BASE_URL accepted")
+	}
+	if n := strings.Count(err.Error(), "PUBLIC_BASE_URL is required"); n != 1 {
+		t.Errorf("PUBLIC_BASE_URL reported %d times, want once", n)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(c *Config) bool
+		err   string
+	}{
+		{
+			name:  "string",
+			env:   map[string]string{"PORT": "9090"},
+			check: func(c *Config) bool { return c.Port == "9090" },
+		},
+		{
+			name:  "nested duration",
+			env:   map[string]string{"HTTP_READ_TIMEOUT": "15s"},
+			check: func(c *Config) bool { return c.Server.ReadTimeout == 15*time.Second },
+		},
+		{
+			name:  "int, float and bool",
+			env:   map[string]string{"JOB_WORKERS": "4", "VIDEO_MAX_FPS": "59.94", "VIDEO_TRIM_LONG": "false"},
+			check: func(c *Config) bool { return c.Jobs.Workers == 4 && c.Video.MaxFPS == 59.94 && !c.Video.TrimLong },
+		},
+		{
+			name: "list",
+			env:  map[string]string{"VIDEO_CODECS": " h264, ,hevc "},
+			check: func(c *Config) bool {
+				return reflect.DeepEqual(c.Video.Codecs, []string{"h264", "hevc"})
+			},
+		},
+		{
+			name:  "empty is unset",
+			env:   map[string]string{"PORT": "  ", "TIME_ZONE": ""},
+			check: func(c *Config) bool { return c.Port == "8080" && c.TimeZone == "Asia/Taipei" },
+		},
+		{
+			name: "invalid duration",
+			env:  map[string]string{"JOB_LEASE": "2"},
+			err:  `invalid JOB_LEASE "2"`,
+		},
+		{
+			name: "invalid int",
+			env:  map[string]string{"JOB_WORKERS": "two"},
+			err:  `invalid JOB_WORKERS "two"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			err := apply(reflect.ValueOf(c).Elem(), func(key string) string { return tt.env[key] })
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(c) {
+				t.Errorf("settings %+v not applied", tt.env)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "port: \"9090\"\ntimeZone: Europe/Berlin\njobs:\n  workers: 3\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	// empty variables leave the file settings alone
+	t.Setenv("PORT", "")
+	t.Setenv("TIME_ZONE", "")
+	// the environment wins over the file
+	t.Setenv("JOB_WORKERS", "5")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "9090" || c.TimeZone != "Europe/Berlin" {
+		t.Errorf("port %q and time zone %q, want the file settings", c.Port, c.TimeZone)
+	}
+	if c.Jobs.Workers != 5 {
+		t.Errorf("%d workers, want 5 from the environment", c.Jobs.Workers)
+	}
+	if c.Jobs.QueueSize != Default().Jobs.QueueSize {
+		t.Errorf("queue size %d, want the default", c.Jobs.QueueSize)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"unknown key", "prot: \"9090\"\n", "field prot not found"},
+		{"wrong type", "jobs:\n  workers: many\n", "failed to parse config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := Default().loadFile(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("empty file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := Default().loadFile(path); err != nil {
+			t.Errorf("empty file rejected: %v", err)
+		}
+	})
+}
+
+func TestSummaryRedactsSecrets(t *testing.T) {
+	c := validConfig()
+	c.AdminToken = "admin-token"
+	summary := c.Summary() + "\n"
+
+	for _, line := range []string{
+		"CHANNEL_SECRET=(redacted)",
+		"ADMIN_TOKEN=(redacted)",
+		"MEDIA_SIGNING_KEY=(unset)",
+		"PORT=8080",
+		"VIDEO_CODECS=" + strings.Join(c.Video.Codecs, ","),
+	} {
+		if !strings.Contains(summary, line+"\n") {
+			t.Errorf("summary misses %q", line)
+		}
+	}
+	if strings.Contains(summary, "admin-token") {
+		t.Error("summary shows the admin token")
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load reads the configuration without validating it, so tools needing only
+// a few settings can use it too. The bot calls Validate before starting.
+func Load() (*Config, error) {
+	// .env only sets variables missing from the environment
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env: %v", err)
+	}
+
+	c := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := apply(reflect.ValueOf(c).Elem(), os.Getenv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// unknown keys are typos rather than settings to ignore
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %v: %v", path, err)
+	}
+	return nil
+}
+
+// apply sets the fields whose environment variable is set. Empty variables
+// are treated as unset.
+func apply(v reflect.Value, getenv func(string) string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			if err := apply(value, getenv); err != nil {
+				return err
+			}
+			continue
+		}
+		key := field.Tag.Get("env")
+		raw := strings.TrimSpace(getenv(key))
+		if key == "" || raw == "" {
+			continue
+		}
+		if err := set(value, raw); err != nil {
+			return fmt.Errorf("invalid %v %q: %v", key, raw, err)
+		}
+	}
+	return nil
+}
+
+func set(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %v", value.Type())
+	}
+	return nil
+}
+
+// Summary lists every setting by its environment variable, one per line,
+// with secrets redacted.
+func (c *Config) Summary() string {
+	lines := []string{}
+	summarize(reflect.ValueOf(c).Elem(), &lines)
+	return strings.Join(lines, "\n")
+}
+
+func summarize(v reflect.Value, lines *[]string) {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			summarize(value, lines)
+			continue
+		}
+
+		shown := fmt.Sprint(value.Interface())
+		if value.Kind() == reflect.Slice {
+			shown = strings.Join(value.Interface().([]string), ",")
+		}
+		switch {
+		case value.IsZero() && value.Kind() == reflect.String:
+			shown = "(unset)"
+		case field.Tag.Get("secret") == "true":
+			shown = "(redacted)"
+		}
+		*lines = append(*lines, field.Tag.Get("env")+"="+shown)
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+	_ "time/tzdata" // Asia/Taipei on images without zoneinfo
+)
+
+// Validate reports every missing or malformed setting at once, so a
+// deployment can be fixed in one go.
+func (c *Config) Validate() error {
+	v := &validator{missing: map[string]bool{}}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		v.errorf("PORT must be a port number, got %q", c.Port)
+	}
+	v.url("PUBLIC_BASE_URL", c.PublicBaseURL)
+	if _, err := time.LoadLocation(c.TimeZone); err != nil || c.TimeZone == "" {
+		v.errorf("TIME_ZONE must be a time zone name like Asia/Taipei, got %q", c.TimeZone)
+	}
+	v.positive("WEBHOOK_DEDUP_TTL", int64(c.WebhookDedupTTL))
+	v.positive("WEBHOOK_CLAIM_LEASE", int64(c.WebhookClaimLease))
+	v.positive("HTTP_READ_TIMEOUT", int64(c.Server.ReadTimeout))
+	v.positive("HTTP_WRITE_TIMEOUT", int64(c.Server.WriteTimeout))
+	v.positive("HTTP_IDLE_TIMEOUT", int64(c.Server.IdleTimeout))
+	v.positive("SHUTDOWN_TIMEOUT", int64(c.Server.ShutdownTimeout))
+	v.positive("HEALTH_PROBE_TIMEOUT", int64(c.Server.ProbeTimeout))
+
+	v.require("CHANNEL_SECRET", c.Line.ChannelSecret)
+	v.require("CHANNEL_TOKEN", c.Line.ChannelToken)
+
+	// secrets are only read for the firebase and drive backends
+	usesSecrets := c.Database.Backend == "firebase" || c.Storage.Backend == "drive"
+	switch c.Secrets.Backend {
+	case "gcp":
+		if usesSecrets {
+			v.require("GCP_PROJECT_ID", c.GCPProjectID)
+		}
+	case "file":
+		v.require("SECRETS_DIR", c.Secrets.Dir)
+	case "env":
+	default:
+		v.errorf("SECRETS_BACKEND must be gcp, env or file, got %q", c.Secrets.Backend)
+	}
+	v.positive("SECRETS_CACHE_TTL", int64(c.Secrets.CacheTTL))
+
+	switch c.Database.Backend {
+	case "firebase":
+		v.require("FIREBASE_PROJECT_ID", c.Firebase.ProjectID)
+		v.require("FIREBASE_CREDENTIALS", c.Firebase.Credentials)
+		v.require("FIREBASE_USERS", c.Firebase.Users)
+		v.require("FIREBASE_SESSIONS", c.Firebase.Sessions)
+		v.require("FIREBASE_JOBS", c.Firebase.Jobs)
+		v.require("FIREBASE_WEBHOOK_EVENTS", c.Firebase.WebhookEvents)
+	case "sqlite", "postgres":
+		v.require("DB_DSN", c.Database.DSN)
+	case "memory":
+	default:
+		v.errorf("DB_BACKEND must be firebase, sqlite, postgres or memory, got %q", c.Database.Backend)
+	}
+
+	switch c.Storage.Backend {
+	case "drive":
+		v.require("GOOGLE_DRIVE_CREDENTIALS", c.Drive.Credentials)
+		v.require("GOOGLE_DRIVE_ROOT_FOLDER_ID", c.Drive.RootFolderID)
+		v.require("GOOGLE_DRIVE_THUMBNAIL_FOLDER_ID", c.Drive.ThumbnailFolderID)
+	case "local":
+		v.require("LOCAL_STORAGE_DIR", c.Storage.LocalDir)
+		v.require("MEDIA_SIGNING_KEY", c.Storage.SigningKey)
+		v.require("PUBLIC_BASE_URL", c.PublicBaseURL)
+	default:
+		v.errorf("STORAGE_BACKEND must be drive or local, got %q", c.Storage.Backend)
+	}
+
+	switch c.Analysis.Backend {
+	case "http":
+		v.require("GENAI_URL", c.Analysis.URL)
+		v.url("GENAI_URL", c.Analysis.URL)
+	case "fake":
+	default:
+		v.errorf("ANALYSIS_BACKEND must be http or fake, got %q", c.Analysis.Backend)
+	}
+	v.positive("GENAI_MAX_ATTEMPTS", int64(c.Analysis.MaxAttempts))
+
+	v.positive("BREAKER_THRESHOLD", int64(c.Breaker.Threshold))
+	v.positive("BREAKER_COOLDOWN", int64(c.Breaker.Cooldown))
+	v.positive("JOB_WORKERS", int64(c.Jobs.Workers))
+	v.positive("JOB_QUEUE_SIZE", int64(c.Jobs.QueueSize))
+	v.positive("JOB_RETRY_DELAY", int64(c.Jobs.RetryDelay))
+	v.notNegative("JOB_MAX_DELAYS", float64(c.Jobs.MaxDelays))
+	v.positive("JOB_LEASE", int64(c.Jobs.Lease))
+
+	v.notNegative("VIDEO_MIN_DURATION", float64(c.Video.MinDuration))
+	v.notNegative("VIDEO_MAX_DURATION", float64(c.Video.MaxDuration))
+	v.notNegative("VIDEO_MAX_SIZE_MB", float64(c.Video.MaxSizeMB))
+	v.notNegative("VIDEO_MIN_FPS", c.Video.MinFPS)
+	v.notNegative("VIDEO_MAX_FPS", c.Video.MaxFPS)
+	if c.Video.MaxDuration > 0 && c.Video.MinDuration > c.Video.MaxDuration {
+		v.errorf("VIDEO_MIN_DURATION must not exceed VIDEO_MAX_DURATION")
+	}
+	if c.Video.MaxFPS > 0 && c.Video.MinFPS > c.Video.MaxFPS {
+		v.errorf("VIDEO_MIN_FPS must not exceed VIDEO_MAX_FPS")
+	}
+	if len(c.Video.Codecs) == 0 {
+		v.errorf("VIDEO_CODECS must list at least one codec")
+	}
+
+	return errors.Join(v.errs...)
+}
+
+type validator struct {
+	errs []error
+	// settings required by several backends are reported once
+	missing map[string]bool
+}
+
+func (v *validator) errorf(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+func (v *validator) require(key string, value string) {
+	if value == "" && !v.missing[key] {
+		v.missing[key] = true
+		v.errorf("%v is required", key)
+	}
+}
+
+// url checks an absolute http(s) url, if set
+func (v *validator) url(key string, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.errorf("%v must be an http or https url, got %q", key, value)
+	}
+}
+
+func (v *validator) positive(key string, value int64) {
+	if value <= 0 {
+		v.errorf("%v must be positive", key)
+	}
+}
+
+func (v *validator) notNegative(key string, value float64) {
+	if value < 0 {
+		v.errorf("%v must not be negative", key)
+	}
+}
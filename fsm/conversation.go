@@ -0,0 +1,77 @@
+package fsm
+
+import (
+	"log"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/line"
+)
+
+func actionIs(action line.Action) Guard {
+	return func(session db.UserSession, input Input) bool {
+		return input.Action == action
+	}
+}
+
+// Conversation lists every flow of the bot:
+//
+//	analyze video:  handedness -> skill -> upload
+//	reflection:     skill -> date -> text
+//	preview note:   skill -> date -> text
+//	expert video:   handedness -> skill
+//
+// Menu commands are accepted in every state and restart the flow.
+var Conversation = []Transition{
+	// menu
+	{From: Any, Event: EventMenu, To: db.None},
+	{From: Any, Event: EventAnalyzeCommand, To: db.SelectingAnalyzeHandedness},
+	{From: Any, Event: EventReflectionCommand, To: db.SelectingReflectionSkill},
+	{From: Any, Event: EventPreviewCommand, To: db.SelectingPreviewNoteSkill},
+	{From: Any, Event: EventExpertCommand, To: db.SelectingExpertHandedness},
+
+	// portfolio and videos can be viewed at any time
+	{From: db.None, Event: EventSkill, To: db.None, Guard: actionIs(line.ViewPortfolio)},
+	{From: Any, Event: EventViewVideo, To: Same},
+	{From: db.None, Event: EventStudent, To: db.None},
+
+	// analyze video
+	{From: db.SelectingAnalyzeHandedness, Event: EventHandedness, To: db.SelectingAnalyzeSkill},
+	{From: db.SelectingAnalyzeSkill, Event: EventSkill, To: db.UploadingVideo, Guard: actionIs(line.AnalyzeVideo)},
+	{From: db.UploadingVideo, Event: EventVideo, To: db.None},
+
+	// reflection
+	{From: db.SelectingReflectionSkill, Event: EventSkill, To: db.SelectingReflectionDate, Guard: actionIs(line.AddReflection)},
+	{From: db.SelectingReflectionDate, Event: EventDate, To: db.WritingReflection, Guard: actionIs(line.AddReflection)},
+	{From: db.WritingReflection, Event: EventDate, To: db.WritingReflection, Guard: actionIs(line.AddReflection)},
+	{From: db.WritingReflection, Event: EventText, To: db.None},
+
+	// preview note
+	{From: db.SelectingPreviewNoteSkill, Event: EventSkill, To: db.SelectingPreviewNoteDate, Guard: actionIs(line.AddPreviewNote)},
+	{From: db.SelectingPreviewNoteDate, Event: EventDate, To: db.WritingPreviewNote, Guard: actionIs(line.AddPreviewNote)},
+	{From: db.WritingPreviewNote, Event: EventDate, To: db.WritingPreviewNote, Guard: actionIs(line.AddPreviewNote)},
+	{From: db.WritingPreviewNote, Event: EventText, To: db.None},
+
+	// expert video
+	{From: db.SelectingExpertHandedness, Event: EventHandedness, To: db.SelectingExpertSkill},
+	{From: db.SelectingExpertSkill, Event: EventSkill, To: db.None, Guard: actionIs(line.ViewExpertVideo)},
+}
+
+// ConversationHints are the message keys of what the bot replies with when
+// an input does not fit the current step.
+var ConversationHints = map[db.UserState]string{
+	db.SelectingAnalyzeHandedness: "hint.select_handedness",
+	db.SelectingAnalyzeSkill:      "hint.select_analyze_skill",
+	db.UploadingVideo:             "hint.upload_video",
+	db.SelectingReflectionSkill:   "hint.select_reflection_skill",
+	db.SelectingReflectionDate:    "hint.select_reflection_date",
+	db.WritingReflection:          "hint.write_reflection",
+	db.SelectingPreviewNoteSkill:  "hint.select_preview_note_skill",
+	db.SelectingPreviewNoteDate:   "hint.select_preview_note_date",
+	db.WritingPreviewNote:         "hint.write_preview_note",
+	db.SelectingExpertHandedness:  "hint.select_handedness",
+	db.SelectingExpertSkill:       "hint.select_expert_skill",
+}
+
+func NewConversation(logger *log.Logger) *Machine {
+	return NewMachine(Conversation, ConversationHints, "default_reply", logger)
+}
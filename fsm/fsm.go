@@ -0,0 +1,136 @@
+package fsm
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/line"
+)
+
+type Event string
+
+const (
+	// menu commands
+	EventMenu              Event = "menu"
+	EventAnalyzeCommand    Event = "analyze_command"
+	EventReflectionCommand Event = "reflection_command"
+	EventPreviewCommand    Event = "preview_note_command"
+	EventExpertCommand     Event = "expert_command"
+
+	// messages
+	EventText  Event = "text"
+	EventVideo Event = "video"
+
+	// postbacks
+	EventHandedness Event = "handedness"
+	EventSkill      Event = "skill"
+	EventDate       Event = "date"
+	EventViewVideo  Event = "view_video"
+	EventStudent    Event = "student"
+)
+
+const (
+	// Any matches every state in Transition.From
+	Any db.UserState = -1
+	// Same keeps the current state in Transition.To
+	Same db.UserState = -2
+)
+
+// Input is an event together with the postback payload that came with it.
+type Input struct {
+	Event  Event
+	Action line.Action
+	Skill  string
+	Date   string
+}
+
+type Guard func(session db.UserSession, input Input) bool
+
+type Transition struct {
+	From  db.UserState
+	Event Event
+	To    db.UserState
+	Guard Guard
+}
+
+// InvalidInputError is returned when no transition accepts the input. Hint is
+// the message key of the reply telling the user what the current state
+// expects.
+type InvalidInputError struct {
+	State db.UserState
+	Event Event
+	Hint  string
+}
+
+func (e *InvalidInputError) Error() string {
+	return fmt.Sprintf("event %s is not allowed in state %s", e.Event, e.State)
+}
+
+type Machine struct {
+	transitions []Transition
+	hints       map[db.UserState]string
+	defaultHint string
+	logger      *log.Logger
+}
+
+func NewMachine(transitions []Transition, hints map[db.UserState]string, defaultHint string, logger *log.Logger) *Machine {
+	return &Machine{transitions, hints, defaultHint, logger}
+}
+
+func (m *Machine) match(session db.UserSession, input Input) (*Transition, bool) {
+	for i := range m.transitions {
+		t := &m.transitions[i]
+		if t.Event != input.Event || (t.From != Any && t.From != session.UserState) {
+			continue
+		}
+		if t.Guard != nil && !t.Guard(session, input) {
+			continue
+		}
+		return t, true
+	}
+	return nil, false
+}
+
+func (m *Machine) Hint(state db.UserState) string {
+	if hint, ok := m.hints[state]; ok {
+		return hint
+	}
+	return m.defaultHint
+}
+
+// Can reports whether the input is accepted in the session's current state.
+func (m *Machine) Can(session db.UserSession, input Input) bool {
+	_, ok := m.match(session, input)
+	return ok
+}
+
+// Fire returns the session after applying the input. Returning to None or
+// starting a new flow clears the selected skill and date, and postback
+// payloads are recorded so that later steps can use them.
+func (m *Machine) Fire(userId string, session db.UserSession, input Input) (db.UserSession, error) {
+	t, ok := m.match(session, input)
+	if !ok {
+		m.logger.Printf("\n\tRejected %s in state %s for user %s", input.Event, session.UserState, userId)
+		return session, &InvalidInputError{session.UserState, input.Event, m.Hint(session.UserState)}
+	}
+
+	next := session
+	if t.To != Same {
+		next.UserState = t.To
+	}
+
+	switch {
+	case next.UserState == db.None || (t.From == Any && t.To != Same):
+		next.Skill = ""
+		next.UpdatingDate = ""
+	case input.Event == EventSkill:
+		next.Skill = input.Skill
+		next.UpdatingDate = ""
+	case input.Event == EventDate:
+		next.UpdatingDate = input.Date
+	}
+
+	m.logger.Printf("\n\tTransition %s --%s--> %s for user %s", session.UserState, input.Event, next.UserState, userId)
+	return next, nil
+}
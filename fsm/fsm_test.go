@@ -0,0 +1,193 @@
+package fsm
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/HeavenAQ/api/db"
+	"github.com/HeavenAQ/api/line"
+)
+
+func newTestMachine() *Machine {
+	return NewConversation(log.New(io.Discard, "", 0))
+}
+
+func TestFlows(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []Input
+		states []db.UserState
+		// session once every input is applied
+		want db.UserSession
+	}{
+		{
+			name: "analyze video",
+			inputs: []Input{
+				{Event: EventAnalyzeCommand},
+				{Event: EventHandedness},
+				{Event: EventSkill, Action: line.AnalyzeVideo, Skill: "serve"},
+				{Event: EventVideo},
+			},
+			states: []db.UserState{db.SelectingAnalyzeHandedness, db.SelectingAnalyzeSkill, db.UploadingVideo, db.None},
+			want:   db.UserSession{UserState: db.None},
+		},
+		{
+			name: "reflection",
+			inputs: []Input{
+				{Event: EventReflectionCommand},
+				{Event: EventSkill, Action: line.AddReflection, Skill: "serve"},
+				{Event: EventDate, Action: line.AddReflection, Date: "2024-03-01"},
+			},
+			states: []db.UserState{db.SelectingReflectionSkill, db.SelectingReflectionDate, db.WritingReflection},
+			want:   db.UserSession{Skill: "serve", UpdatingDate: "2024-03-01", UserState: db.WritingReflection},
+		},
+		{
+			name: "reflection date changed before writing",
+			inputs: []Input{
+				{Event: EventReflectionCommand},
+				{Event: EventSkill, Action: line.AddReflection, Skill: "serve"},
+				{Event: EventDate, Action: line.AddReflection, Date: "2024-03-01"},
+				{Event: EventDate, Action: line.AddReflection, Date: "2024-03-08"},
+				{Event: EventText},
+			},
+			states: []db.UserState{db.SelectingReflectionSkill, db.SelectingReflectionDate, db.WritingReflection, db.WritingReflection, db.None},
+			want:   db.UserSession{UserState: db.None},
+		},
+		{
+			name: "preview note",
+			inputs: []Input{
+				{Event: EventPreviewCommand},
+				{Event: EventSkill, Action: line.AddPreviewNote, Skill: "clear"},
+				{Event: EventDate, Action: line.AddPreviewNote, Date: "2024-03-01"},
+			},
+			states: []db.UserState{db.SelectingPreviewNoteSkill, db.SelectingPreviewNoteDate, db.WritingPreviewNote},
+			want:   db.UserSession{Skill: "clear", UpdatingDate: "2024-03-01", UserState: db.WritingPreviewNote},
+		},
+		{
+			name: "expert video",
+			inputs: []Input{
+				{Event: EventExpertCommand},
+				{Event: EventHandedness},
+				{Event: EventSkill, Action: line.ViewExpertVideo, Skill: "serve"},
+			},
+			states: []db.UserState{db.SelectingExpertHandedness, db.SelectingExpertSkill, db.None},
+			want:   db.UserSession{UserState: db.None},
+		},
+		{
+			name: "command restarts a flow",
+			inputs: []Input{
+				{Event: EventReflectionCommand},
+				{Event: EventSkill, Action: line.AddReflection, Skill: "serve"},
+				{Event: EventPreviewCommand},
+			},
+			states: []db.UserState{db.SelectingReflectionSkill, db.SelectingReflectionDate, db.SelectingPreviewNoteSkill},
+			want:   db.UserSession{UserState: db.SelectingPreviewNoteSkill},
+		},
+		{
+			name: "video viewed mid flow",
+			inputs: []Input{
+				{Event: EventReflectionCommand},
+				{Event: EventSkill, Action: line.AddReflection, Skill: "serve"},
+				{Event: EventViewVideo},
+			},
+			states: []db.UserState{db.SelectingReflectionSkill, db.SelectingReflectionDate, db.SelectingReflectionDate},
+			want:   db.UserSession{Skill: "serve", UserState: db.SelectingReflectionDate},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMachine()
+			session := db.UserSession{UserState: db.None}
+			for i, input := range tt.inputs {
+				next, err := m.Fire("user", session, input)
+				if err != nil {
+					t.Fatalf("input %d (%s): %v", i, input.Event, err)
+				}
+				if next.UserState != tt.states[i] {
+					t.Fatalf("input %d (%s): state %s, want %s", i, input.Event, next.UserState, tt.states[i])
+				}
+				session = next
+			}
+			if session != tt.want {
+				t.Errorf("session %+v, want %+v", session, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuards(t *testing.T) {
+	tests := []struct {
+		name  string
+		state db.UserState
+		input Input
+		want  bool
+	}{
+		{"portfolio skill when idle", db.None, Input{Event: EventSkill, Action: line.ViewPortfolio}, true},
+		{"analyze skill when idle", db.None, Input{Event: EventSkill, Action: line.AnalyzeVideo}, false},
+		{"analyze skill", db.SelectingAnalyzeSkill, Input{Event: EventSkill, Action: line.AnalyzeVideo}, true},
+		{"portfolio skill while analyzing", db.SelectingAnalyzeSkill, Input{Event: EventSkill, Action: line.ViewPortfolio}, false},
+		{"reflection skill", db.SelectingReflectionSkill, Input{Event: EventSkill, Action: line.AddReflection}, true},
+		{"preview skill in reflection", db.SelectingReflectionSkill, Input{Event: EventSkill, Action: line.AddPreviewNote}, false},
+		{"reflection date", db.SelectingReflectionDate, Input{Event: EventDate, Action: line.AddReflection}, true},
+		{"preview date in reflection", db.SelectingReflectionDate, Input{Event: EventDate, Action: line.AddPreviewNote}, false},
+		{"preview date", db.SelectingPreviewNoteDate, Input{Event: EventDate, Action: line.AddPreviewNote}, true},
+		{"reflection date while writing preview", db.WritingPreviewNote, Input{Event: EventDate, Action: line.AddReflection}, false},
+		{"expert skill", db.SelectingExpertSkill, Input{Event: EventSkill, Action: line.ViewExpertVideo}, true},
+		{"analyze skill in expert", db.SelectingExpertSkill, Input{Event: EventSkill, Action: line.AnalyzeVideo}, false},
+		{"student when idle", db.None, Input{Event: EventStudent}, true},
+		{"student mid flow", db.UploadingVideo, Input{Event: EventStudent}, false},
+	}
+
+	m := newTestMachine()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Can(db.UserSession{UserState: tt.state}, tt.input); got != tt.want {
+				t.Errorf("Can = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidInputHints(t *testing.T) {
+	tests := []struct {
+		state db.UserState
+		input Input
+		hint  string
+	}{
+		{db.None, Input{Event: EventVideo}, "default_reply"},
+		{db.None, Input{Event: EventText}, "default_reply"},
+		{db.SelectingAnalyzeHandedness, Input{Event: EventVideo}, "hint.select_handedness"},
+		{db.SelectingAnalyzeSkill, Input{Event: EventText}, "hint.select_analyze_skill"},
+		{db.UploadingVideo, Input{Event: EventText}, "hint.upload_video"},
+		{db.SelectingReflectionSkill, Input{Event: EventDate, Action: line.AddReflection}, "hint.select_reflection_skill"},
+		{db.SelectingReflectionDate, Input{Event: EventText}, "hint.select_reflection_date"},
+		{db.WritingReflection, Input{Event: EventVideo}, "hint.write_reflection"},
+		{db.SelectingPreviewNoteSkill, Input{Event: EventHandedness}, "hint.select_preview_note_skill"},
+		{db.SelectingPreviewNoteDate, Input{Event: EventText}, "hint.select_preview_note_date"},
+		{db.WritingPreviewNote, Input{Event: EventVideo}, "hint.write_preview_note"},
+		{db.SelectingExpertHandedness, Input{Event: EventSkill, Action: line.ViewExpertVideo}, "hint.select_handedness"},
+		{db.SelectingExpertSkill, Input{Event: EventText}, "hint.select_expert_skill"},
+	}
+
+	m := newTestMachine()
+	for _, tt := range tests {
+		t.Run(tt.state.String()+"/"+string(tt.input.Event), func(t *testing.T) {
+			session := db.UserSession{Skill: "serve", UserState: tt.state}
+			next, err := m.Fire("user", session, tt.input)
+
+			var invalid *InvalidInputError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("err = %v, want *InvalidInputError", err)
+			}
+			if invalid.Hint != tt.hint || invalid.State != tt.state || invalid.Event != tt.input.Event {
+				t.Errorf("got %+v, want hint %s in state %s", invalid, tt.hint, tt.state)
+			}
+			if next != session {
+				t.Errorf("session changed to %+v", next)
+			}
+		})
+	}
+}
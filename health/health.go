@@ -0,0 +1,132 @@
+// Package health serves the liveness and readiness endpoints of the bot.
+// Readiness probes every service the bot depends on and reports each one
+// separately, so on-call can see which one is down.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pinger is implemented by the clients of the services the bot depends on.
+// Ping makes the cheapest call that proves the service can be used.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+const (
+	Up   = "up"
+	Down = "down"
+)
+
+// Status is the result of the last probe of a dependency. LastError is kept
+// after the dependency recovered, to help tell flapping services apart.
+type Status struct {
+	Status      string     `json:"status"`
+	LatencyMs   int64      `json:"latencyMs"`
+	Error       string     `json:"error,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+type Report struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]Status `json:"checks"`
+}
+
+type probe struct {
+	name   string
+	pinger Pinger
+}
+
+// Checker probes the registered dependencies. Probes run in parallel, each
+// bounded by the timeout.
+type Checker struct {
+	timeout time.Duration
+	probes  []probe
+
+	mu        sync.Mutex
+	lastError map[string]Status
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, lastError: map[string]Status{}}
+}
+
+// Add registers a dependency. It must be called before the handlers serve.
+func (c *Checker) Add(name string, pinger Pinger) {
+	c.probes = append(c.probes, probe{name, pinger})
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	report := Report{Ready: true, Checks: map[string]Status{}}
+	statuses := make([]Status, len(c.probes))
+	var wg sync.WaitGroup
+	for i, p := range c.probes {
+		wg.Add(1)
+		go func(i int, p probe) {
+			defer wg.Done()
+			statuses[i] = c.run(ctx, p)
+		}(i, p)
+	}
+	wg.Wait()
+
+	for i, p := range c.probes {
+		report.Checks[p.name] = statuses[i]
+		if statuses[i].Status != Up {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, p probe) Status {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	err := p.pinger.Ping(ctx)
+	status := Status{Status: Up, LatencyMs: time.Since(start).Milliseconds()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		now := time.Now()
+		status.Status = Down
+		status.Error = err.Error()
+		c.lastError[p.name] = Status{LastError: err.Error(), LastErrorAt: &now}
+	}
+	if last, ok := c.lastError[p.name]; ok {
+		status.LastError = last.LastError
+		status.LastErrorAt = last.LastErrorAt
+	}
+	return status
+}
+
+// LivenessHandler answers as long as the process can serve requests.
+func (c *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadinessHandler probes every dependency and answers 503 when one is down.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := c.Check(req.Context())
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
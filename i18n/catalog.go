@@ -0,0 +1,281 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//go:embed locales/*.json
+var builtinLocales embed.FS
+
+// DefaultLanguage is used for users whose LINE profile has no language and
+// for messages missing from another locale.
+const DefaultLanguage = "zh"
+
+// FallbackLanguage is used for LINE profiles in a language the catalog does
+// not have, since Chinese readers all have a zh profile.
+const FallbackLanguage = "en"
+
+// Locale is the content of a locale file, named after its language.
+type Locale struct {
+	// Name is shown when the user picks a language
+	Name string `json:"name"`
+	// Commands maps a command id to the texts accepted for it, the first one
+	// being the one shown to users
+	Commands map[string][]string `json:"commands"`
+	// Messages maps a message key to a fmt format
+	Messages map[string]string `json:"messages"`
+}
+
+type alias struct {
+	text    string
+	command string
+}
+
+type Catalog struct {
+	locales map[string]*Locale
+	// aliases of every locale, longest first so "Reminders Off" wins over a
+	// shorter alias it starts with
+	aliases []alias
+}
+
+// Load reads the built-in locales and the <lang>.json files in dir, if any.
+// Files in dir replace single messages and commands of a built-in locale or
+// add a new language.
+func Load(dir string) (*Catalog, error) {
+	locales := map[string]*Locale{}
+	builtins, err := builtinLocales.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range builtins {
+		data, err := builtinLocales.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeLocale(locales, entry.Name(), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := mergeLocale(locales, filepath.Base(path), data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return NewCatalog(locales)
+}
+
+func mergeLocale(locales map[string]*Locale, filename string, data []byte) error {
+	lang := strings.ToLower(strings.TrimSuffix(filename, ".json"))
+	var locale Locale
+	if err := json.Unmarshal(data, &locale); err != nil {
+		return fmt.Errorf("failed to parse locale %v: %v", filename, err)
+	}
+
+	existing, ok := locales[lang]
+	if !ok {
+		existing = &Locale{Commands: map[string][]string{}, Messages: map[string]string{}}
+		locales[lang] = existing
+	}
+	if locale.Name != "" {
+		existing.Name = locale.Name
+	}
+	for command, texts := range locale.Commands {
+		existing.Commands[command] = texts
+	}
+	for key, message := range locale.Messages {
+		existing.Messages[key] = message
+	}
+	return nil
+}
+
+// NewCatalog checks that every locale only has messages and commands the
+// default locale knows, which catches typos in the keys, and that no alias
+// is used for two commands.
+func NewCatalog(locales map[string]*Locale) (*Catalog, error) {
+	base, ok := locales[DefaultLanguage]
+	if !ok {
+		return nil, errors.New("missing locale " + DefaultLanguage)
+	}
+
+	catalog := &Catalog{locales: locales}
+	seen := map[string]string{}
+	for lang, locale := range locales {
+		if locale.Name == "" {
+			return nil, fmt.Errorf("locale %v has no name", lang)
+		}
+		for key := range locale.Messages {
+			if _, ok := base.Messages[key]; !ok {
+				return nil, fmt.Errorf("locale %v has unknown message %q", lang, key)
+			}
+		}
+		for command, texts := range locale.Commands {
+			if _, ok := base.Commands[command]; !ok {
+				return nil, fmt.Errorf("locale %v has unknown command %q", lang, command)
+			}
+			for _, text := range texts {
+				text = normalize(text)
+				if text == "" {
+					return nil, fmt.Errorf("locale %v has an empty alias for %q", lang, command)
+				}
+				if other, ok := seen[text]; ok && other != command {
+					return nil, fmt.Errorf("alias %q is used for both %q and %q", text, other, command)
+				}
+				if _, ok := seen[text]; !ok {
+					seen[text] = command
+					catalog.aliases = append(catalog.aliases, alias{text, command})
+				}
+			}
+		}
+	}
+	sort.Slice(catalog.aliases, func(i, j int) bool {
+		return len(catalog.aliases[i].text) > len(catalog.aliases[j].text)
+	})
+	return catalog, nil
+}
+
+// normalize lowercases the text and collapses its whitespace so aliases match
+// however they were typed.
+func normalize(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// Languages returns the languages of the catalog, the default one first.
+func (c *Catalog) Languages() []string {
+	langs := []string{}
+	for lang := range c.locales {
+		if lang != DefaultLanguage {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return append([]string{DefaultLanguage}, langs...)
+}
+
+func (c *Catalog) Supports(lang string) bool {
+	_, ok := c.locales[lang]
+	return ok
+}
+
+// Name returns the name of a language in that language.
+func (c *Catalog) Name(lang string) string {
+	if locale, ok := c.locales[lang]; ok {
+		return locale.Name
+	}
+	return lang
+}
+
+// Lookup finds the language a user typed, by its code or its name.
+func (c *Catalog) Lookup(text string) (string, bool) {
+	text = normalize(text)
+	for lang, locale := range c.locales {
+		if text == lang || text == normalize(locale.Name) {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// Match picks the language for a BCP 47 tag such as the one of a LINE
+// profile, e.g. "zh-TW" is zh and "en-US" is en.
+func (c *Catalog) Match(tag string) string {
+	tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+	if tag == "" {
+		return DefaultLanguage
+	}
+	if c.Supports(tag) {
+		return tag
+	}
+	base, _, _ := strings.Cut(tag, "-")
+	if c.Supports(base) {
+		return base
+	}
+	if c.Supports(FallbackLanguage) {
+		return FallbackLanguage
+	}
+	return DefaultLanguage
+}
+
+// T formats the message of key in lang, falling back to the default
+// language. Unknown keys are returned as they are so they show up in
+// replies instead of an empty message.
+func (c *Catalog) T(lang string, key string, args ...any) string {
+	format, ok := c.message(lang, key)
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+func (c *Catalog) message(lang string, key string) (string, bool) {
+	if locale, ok := c.locales[lang]; ok {
+		if format, ok := locale.Messages[key]; ok {
+			return format, true
+		}
+	}
+	format, ok := c.locales[DefaultLanguage].Messages[key]
+	return format, ok
+}
+
+// Command returns the text users type for a command in lang.
+func (c *Catalog) Command(lang string, command string) string {
+	if locale, ok := c.locales[lang]; ok && len(locale.Commands[command]) > 0 {
+		return locale.Commands[command][0]
+	}
+	if texts := c.locales[DefaultLanguage].Commands[command]; len(texts) > 0 {
+		return texts[0]
+	}
+	return command
+}
+
+// ParseCommand finds the command a text message starts with, in any
+// language, and returns it with the rest of the text as its argument.
+// command is empty when the text is not a command.
+func (c *Catalog) ParseCommand(text string) (command string, arg string) {
+	text = strings.TrimSpace(text)
+	normalized := normalize(text)
+	for _, alias := range c.aliases {
+		if normalized == alias.text {
+			return alias.command, ""
+		}
+		if strings.HasPrefix(normalized, alias.text+" ") {
+			return alias.command, argument(text, len(strings.Fields(alias.text)))
+		}
+	}
+	return "", ""
+}
+
+// argument drops the first n words of text, keeping the case and spacing of
+// the rest.
+func argument(text string, n int) string {
+	for i := 0; i < n; i++ {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		end := strings.IndexFunc(text, unicode.IsSpace)
+		if end == -1 {
+			return ""
+		}
+		text = text[end:]
+	}
+	return strings.TrimSpace(text)
+}
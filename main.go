@@ -1,26 +1,74 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/HeavenAQ/api/drive"
 	"github.com/HeavenAQ/app"
-	"github.com/joho/godotenv"
+	"github.com/HeavenAQ/config"
+	"github.com/HeavenAQ/metrics"
 )
 
 func main() {
-	// load env
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
-		log.Println("Trying to load from system environment variables")
+	// load env, .env and CONFIG_FILE
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Error loading config: ", err)
+	}
+	log.Println("\n\tConfiguration:\n\t\t" + strings.ReplaceAll(cfg.Summary(), "\n", "\n\t\t"))
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid config:\n", err)
 	}
 
-	app := app.NewApp()
+	app, err := app.NewApp(cfg)
+	if err != nil {
+		log.Fatal("Error initializing app: ", err)
+	}
 	http.HandleFunc("/callback", app.HandleCallback)
+	http.Handle("/admin/", app.AdminHandler())
+	http.Handle("/charts/progress.png", app.ChartHandler())
+	http.Handle("/healthz", app.Health.LivenessHandler())
+	http.Handle("/readyz", app.Health.ReadinessHandler())
+	http.Handle("/metrics", metrics.Handler())
+
+	// serve videos and thumbnails when they are stored on local disk
+	if media, ok := app.Storage.(http.Handler); ok {
+		http.Handle(drive.LocalMediaPath, media)
+	}
+
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	go func() {
+		app.InfoLogger.Println("\n\tServer started on port: http://localhost:" + cfg.Port)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Cloud Run sends SIGTERM before stopping the container
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+	<-stop.Done()
+	app.InfoLogger.Println("\n\tShutting down, waiting up to", cfg.Server.ShutdownTimeout)
 
-	app.InfoLogger.Println("\n\tServer started on port: http://localhost:" + os.Getenv("PORT"))
-	if err := http.ListenAndServe(":"+os.Getenv("PORT"), nil); err != nil {
-		log.Fatal(err)
+	// stop taking webhooks and let the ones in flight queue their uploads
+	// before the analyses are drained
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelTimeout()
+	if err := server.Shutdown(ctx); err != nil {
+		app.ErrorLogger.Println("\n\tError shutting down server:", err)
 	}
+	app.Shutdown(ctx)
 }
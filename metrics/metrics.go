@@ -0,0 +1,117 @@
+// Package metrics holds the Prometheus metrics of the bot, served at
+// /metrics. Metrics are registered on their own registry so only the bot's
+// metrics and the Go runtime ones are exported.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "linebot"
+
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	WebhookEvents = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Webhook events received, by event type.",
+	}, []string{"type"})
+
+	HandlerErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_errors_total",
+		Help:      "Events that could not be handled and got an error reply, by handler.",
+	}, []string{"handler"})
+
+	StageDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "video_stage_duration_seconds",
+		Help:      "Duration of each stage of the video analysis pipeline, failed runs included.",
+		// 100ms to about 3 minutes, the analysis stage taking the longest
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"stage"})
+
+	StageFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "video_stage_failures_total",
+		Help:      "Failed runs of each stage of the video analysis pipeline.",
+	}, []string{"stage"})
+
+	AnalysisResponses = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "analysis_responses_total",
+		Help:      `Answers of the AI server by status code, "error" when none came back.`,
+	}, []string{"code"})
+
+	Retries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Calls retried after a transient failure, by endpoint.",
+	}, []string{"endpoint"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Stage runs one stage of the video pipeline and records how long it took
+// and whether it failed.
+func Stage(stage string, run func() error) error {
+	start := time.Now()
+	err := run()
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+	if err != nil {
+		StageFailures.WithLabelValues(stage).Inc()
+	}
+	return err
+}
+
+// RegisterSessions exports the number of sessions in each conversation
+// state, counted by count on every scrape.
+func RegisterSessions(count func() (map[string]int, error)) {
+	registry.MustRegister(&sessionCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "sessions"),
+			"Sessions by conversation state.",
+			[]string{"state"}, nil,
+		),
+		count: count,
+	})
+}
+
+type sessionCollector struct {
+	desc  *prometheus.Desc
+	count func() (map[string]int, error)
+}
+
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.count()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), state)
+	}
+}
+
+func Handler() http.Handler {
+	// a failing session count must not hide the other metrics
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
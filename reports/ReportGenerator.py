@@ -49,6 +49,16 @@ class ReportGenerator:
             wb.remove(wb.active)
         return wb
 
+    @staticmethod
+    def get_skill_works(doc_dict: dict, skill: str) -> dict:
+        # users are migrated from the fixed Serve/Smash/Clear fields to the
+        # per-skill map when the bot first reads them
+        portfolio = doc_dict["Portfolio"]
+        works = (portfolio.get("Skills") or {}).get(skill)
+        if works is None:
+            works = portfolio.get(skill.capitalize())
+        return works or {}
+
     def extract_student_data(self, doc: DocumentSnapshot) -> Student:
         doc_dict = doc.to_dict()
         if not doc_dict:
@@ -80,7 +90,8 @@ class ReportGenerator:
                         }
                     )
                     for record in (
-                        doc_dict["Portfolio"]["Serve"] | doc_dict["Portfolio"]["Clear"]
+                        self.get_skill_works(doc_dict, "serve")
+                        | self.get_skill_works(doc_dict, "clear")
                     ).values()
                 ],
             }
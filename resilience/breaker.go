@@ -0,0 +1,183 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int8
+
+const (
+	Closed BreakerState = iota
+	Open
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	return [...]string{"closed", "open", "half_open"}[s]
+}
+
+// Breaker fails calls to an endpoint fast after Threshold consecutive
+// failures. Once Cooldown has passed a single probe is let through; its
+// result closes the breaker again or keeps it open for another cooldown.
+type Breaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError error
+	// OnStateChange is called whenever the breaker opens or closes
+	OnStateChange func(name string, from BreakerState, to BreakerState)
+}
+
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(b.name, from, state)
+	}
+}
+
+// Allow returns an error wrapping ErrCircuitOpen when the call must not be
+// made. Every allowed call must be followed by Success or Failure.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+		}
+		b.setState(HalfOpen)
+		b.probing = true
+		return nil
+	case HalfOpen:
+		if b.probing {
+			return fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.lastError = nil
+	b.setState(Closed)
+}
+
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	b.lastError = err
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(Open)
+	}
+}
+
+// State returns the current state and the error of the last failure.
+func (b *Breaker) State() (BreakerState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.lastError
+}
+
+// Breakers hands out one breaker per endpoint, all sharing the same settings.
+type Breakers struct {
+	mu            sync.Mutex
+	threshold     int
+	cooldown      time.Duration
+	breakers      map[string]*Breaker
+	onStateChange func(name string, from BreakerState, to BreakerState)
+}
+
+func NewBreakers(threshold int, cooldown time.Duration, onStateChange func(name string, from BreakerState, to BreakerState)) *Breakers {
+	return &Breakers{
+		threshold:     threshold,
+		cooldown:      cooldown,
+		breakers:      map[string]*Breaker{},
+		onStateChange: onStateChange,
+	}
+}
+
+func (set *Breakers) Get(name string) *Breaker {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	breaker, ok := set.breakers[name]
+	if !ok {
+		breaker = NewBreaker(name, set.threshold, set.cooldown)
+		breaker.OnStateChange = set.onStateChange
+		set.breakers[name] = breaker
+	}
+	return breaker
+}
+
+// All returns every breaker handed out so far.
+func (set *Breakers) All() []*Breaker {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	breakers := []*Breaker{}
+	for _, breaker := range set.breakers {
+		breakers = append(breakers, breaker)
+	}
+	return breakers
+}
+
+// release frees the probe slot without judging the endpoint, used when the
+// caller gave up before getting an answer.
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Call runs fn through the breaker with retries. Only retryable errors count
+// as failures of the endpoint; any other answer shows the endpoint is up.
+func Call(ctx context.Context, breaker *Breaker, policy Policy, fn func(ctx context.Context) error) error {
+	return Do(ctx, policy, func(ctx context.Context) error {
+		if err := breaker.Allow(); err != nil {
+			return err
+		}
+		err := fn(ctx)
+		switch {
+		case ctx.Err() != nil:
+			breaker.release()
+		case IsRetryable(err):
+			breaker.Failure(err)
+		default:
+			breaker.Success()
+		}
+		return err
+	})
+}
@@ -0,0 +1,185 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// step is one call through the breaker: whether it may be made and, if so,
+// how it ends.
+type step struct {
+	wait    time.Duration
+	allowed bool
+	fail    bool
+	state   BreakerState
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "opens after the threshold",
+			threshold: 2,
+			steps: []step{
+				{allowed: true, fail: true, state: Closed},
+				{allowed: true, fail: true, state: Open},
+				{allowed: false, state: Open},
+			},
+		},
+		{
+			name:      "success resets the count",
+			threshold: 2,
+			steps: []step{
+				{allowed: true, fail: true, state: Closed},
+				{allowed: true, state: Closed},
+				{allowed: true, fail: true, state: Closed},
+			},
+		},
+		{
+			name:      "successful probe closes",
+			threshold: 1,
+			steps: []step{
+				{allowed: true, fail: true, state: Open},
+				{wait: 2 * cooldown, allowed: true, state: Closed},
+				{allowed: true, state: Closed},
+			},
+		},
+		{
+			name:      "failed probe opens again",
+			threshold: 3,
+			steps: []step{
+				{allowed: true, fail: true, state: Closed},
+				{allowed: true, fail: true, state: Closed},
+				{allowed: true, fail: true, state: Open},
+				{wait: 2 * cooldown, allowed: true, fail: true, state: Open},
+				{allowed: false, state: Open},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewBreaker("genai", tt.threshold, cooldown)
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+				err := breaker.Allow()
+				if allowed := err == nil; allowed != s.allowed {
+					t.Fatalf("step %d: allowed = %v, want %v", i, allowed, s.allowed)
+				}
+				if err != nil && !errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("step %d: err = %v, want %v", i, err, ErrCircuitOpen)
+				}
+				if s.allowed && s.fail {
+					breaker.Failure(errors.New("down"))
+				} else if s.allowed {
+					breaker.Success()
+				}
+				if state, _ := breaker.State(); state != s.state {
+					t.Fatalf("step %d: breaker %s, want %s", i, state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	breaker := NewBreaker("genai", 1, 0)
+	breaker.Failure(errors.New("down"))
+
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("probe not allowed: %v", err)
+	}
+	if state, _ := breaker.State(); state != HalfOpen {
+		t.Errorf("breaker %s while probing, want half_open", state)
+	}
+	// a second call waits for the probe to answer
+	if err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v during the probe, want %v", err, ErrCircuitOpen)
+	}
+}
+
+func TestBreakersStateChanges(t *testing.T) {
+	type change struct {
+		name     string
+		from, to BreakerState
+	}
+	var changes []change
+	set := NewBreakers(1, 0, func(name string, from BreakerState, to BreakerState) {
+		changes = append(changes, change{name, from, to})
+	})
+	if set.Get("line") != set.Get("line") {
+		t.Fatal("the same name handed out two breakers")
+	}
+
+	breaker := set.Get("drive")
+	breaker.Failure(errors.New("down"))
+	breaker.Allow()
+	breaker.Success()
+
+	want := []change{{"drive", Closed, Open}, {"drive", Open, HalfOpen}, {"drive", HalfOpen, Closed}}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d is %v, want %v", i, changes[i], want[i])
+		}
+	}
+	if got := len(set.All()); got != 2 {
+		t.Errorf("%d breakers, want 2", got)
+	}
+}
+
+func TestCall(t *testing.T) {
+	errBad := errors.New("bad request")
+	errDown := errors.New("down")
+
+	tests := []struct {
+		name  string
+		err   error
+		state BreakerState
+	}{
+		{"success", nil, Closed},
+		{"other errors show the endpoint is up", errBad, Closed},
+		{"retryable errors count as failures", Retryable(errDown, 0), Open},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewBreaker("genai", 1, time.Minute)
+			err := Call(context.Background(), breaker, Policy{MaxAttempts: 3}, func(ctx context.Context) error {
+				return tt.err
+			})
+			if state, _ := breaker.State(); state != tt.state {
+				t.Errorf("breaker %s, want %s", state, tt.state)
+			}
+			if tt.state == Open && !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("err = %v, want %v once the breaker opened", err, ErrCircuitOpen)
+			} else if tt.state == Closed && !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCallCanceledProbe(t *testing.T) {
+	breaker := NewBreaker("genai", 1, 0)
+	breaker.Failure(errors.New("down"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Call(ctx, breaker, Policy{}, func(ctx context.Context) error {
+		return Retryable(ctx.Err(), 0)
+	})
+	// giving up frees the probe without judging the endpoint
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("err = %v after a canceled probe, want the next probe allowed", err)
+	}
+}
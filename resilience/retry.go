@@ -0,0 +1,152 @@
+// Package resilience wraps outbound calls (AI server, Google Drive, LINE)
+// with retries, exponential backoff and circuit breakers.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backoff grows the delay between attempts exponentially. Half of every delay
+// is randomized so that clients failing together do not retry in lockstep.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// Delay returns the wait before retry number attempt, counting from 0.
+func (b Backoff) Delay(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(attempt))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	half := delay / 2
+	return time.Duration(half + rand.Float64()*half)
+}
+
+// Policy decides how often and how long to retry.
+type Policy struct {
+	MaxAttempts int
+	Backoff     Backoff
+	// MaxRetryAfter caps the wait a server may ask for with Retry-After
+	MaxRetryAfter time.Duration
+	// OnRetry is called before waiting for the next attempt
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// RetryableError marks a failure worth retrying, e.g. a 503 or a timeout.
+// RetryAfter is the wait asked for by the server, if any.
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable wraps err so that Do tries again.
+func Retryable(err error, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{err, retryAfter}
+}
+
+func IsRetryable(err error) bool {
+	var retryable *RetryableError
+	return errors.As(err, &retryable)
+}
+
+// IsUnavailable reports whether err means the remote side is down or
+// overloaded rather than the request being wrong, so the caller can tell the
+// user to wait instead of showing a generic error.
+func IsUnavailable(err error) bool {
+	return IsRetryable(err) || errors.Is(err, ErrCircuitOpen)
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable or
+// the attempts run out. The last error is returned as is.
+func Do(ctx context.Context, policy Policy, fn func(ctx context.Context) error) error {
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		err = fn(ctx)
+		if err == nil || !IsRetryable(err) || attempt == attempts-1 {
+			return err
+		}
+
+		delay := policy.Backoff.Delay(attempt)
+		var retryable *RetryableError
+		if errors.As(err, &retryable) && retryable.RetryAfter > delay {
+			delay = retryable.RetryAfter
+			if policy.MaxRetryAfter > 0 && delay > policy.MaxRetryAfter {
+				delay = policy.MaxRetryAfter
+			}
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt+1, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// ParseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date. It returns 0 when the header is missing or invalid.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// IsTransientStatus reports whether an HTTP status is worth retrying.
+func IsTransientStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
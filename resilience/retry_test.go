@@ -0,0 +1,193 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		// the delay before jitter, the result lies in [want/2, want]
+		want time.Duration
+	}{
+		{"first attempt", Backoff{Initial: 100 * time.Millisecond}, 0, 100 * time.Millisecond},
+		{"doubles by default", Backoff{Initial: 100 * time.Millisecond}, 3, 800 * time.Millisecond},
+		{"custom multiplier", Backoff{Initial: 100 * time.Millisecond, Multiplier: 3}, 2, 900 * time.Millisecond},
+		{"capped by max", Backoff{Initial: time.Second, Max: 5 * time.Second}, 10, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				if got := tt.backoff.Delay(tt.attempt); got < tt.want/2 || got > tt.want {
+					t.Fatalf("delay %v, want between %v and %v", got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	errDown := errors.New("down")
+	errBad := errors.New("bad request")
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		errs     []error
+		calls    int
+		want     error
+		delays   []time.Duration
+		canceled bool
+	}{
+		{
+			name:   "success",
+			policy: Policy{MaxAttempts: 3},
+			errs:   []error{nil},
+			calls:  1,
+		},
+		{
+			name:   "recovers after retryable errors",
+			policy: Policy{MaxAttempts: 3},
+			errs:   []error{Retryable(errDown, 0), Retryable(errDown, 0), nil},
+			calls:  3,
+			delays: []time.Duration{0, 0},
+		},
+		{
+			name:   "other errors are not retried",
+			policy: Policy{MaxAttempts: 3},
+			errs:   []error{errBad},
+			calls:  1,
+			want:   errBad,
+		},
+		{
+			name:   "stops at max attempts",
+			policy: Policy{MaxAttempts: 2},
+			errs:   []error{Retryable(errDown, 0), Retryable(errDown, 0), nil},
+			calls:  2,
+			want:   errDown,
+			delays: []time.Duration{0},
+		},
+		{
+			name:   "at least one attempt",
+			policy: Policy{},
+			errs:   []error{Retryable(errDown, 0)},
+			calls:  1,
+			want:   errDown,
+		},
+		{
+			name:   "honors retry after",
+			policy: Policy{MaxAttempts: 2},
+			errs:   []error{Retryable(errDown, 5*time.Millisecond), nil},
+			calls:  2,
+			delays: []time.Duration{5 * time.Millisecond},
+		},
+		{
+			name:   "retry after capped",
+			policy: Policy{MaxAttempts: 2, MaxRetryAfter: 5 * time.Millisecond},
+			errs:   []error{Retryable(errDown, time.Hour), nil},
+			calls:  2,
+			delays: []time.Duration{5 * time.Millisecond},
+		},
+		{
+			name:     "canceled while waiting",
+			policy:   Policy{MaxAttempts: 3, Backoff: Backoff{Initial: time.Hour}},
+			errs:     []error{Retryable(errDown, 0)},
+			calls:    1,
+			want:     context.Canceled,
+			canceled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var delays []time.Duration
+			tt.policy.OnRetry = func(attempt int, delay time.Duration, err error) {
+				if attempt != len(delays)+1 {
+					t.Errorf("retry %d reported as %d", len(delays)+1, attempt)
+				}
+				delays = append(delays, delay)
+				if tt.canceled {
+					cancel()
+				}
+			}
+
+			calls := 0
+			err := Do(ctx, tt.policy, func(ctx context.Context) error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if calls != tt.calls {
+				t.Errorf("%d calls, want %d", calls, tt.calls)
+			}
+			if !tt.canceled && len(delays) != len(tt.delays) {
+				t.Fatalf("delays %v, want %v", delays, tt.delays)
+			}
+			for i, want := range tt.delays {
+				if delays[i] != want {
+					t.Errorf("delay %d is %v, want %v", i, delays[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"missing", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"padded seconds", " 3 ", 3 * time.Second},
+		{"negative seconds", "-5", 0},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"past date", now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"invalid", "soon", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransientStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+	}
+
+	for _, tt := range tests {
+		if got := IsTransientStatus(tt.status); got != tt.want {
+			t.Errorf("IsTransientStatus(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package richmenu
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// maxImageSize is the largest rich menu image LINE accepts.
+const maxImageSize = 1 << 20
+
+// imageExtensions are tried in order when looking for the image of a menu
+var imageExtensions = []string{".png", ".jpg", ".jpeg"}
+
+// FindImage returns the image of the menu in dir, named after its alias.
+func FindImage(dir string, m Menu) (string, error) {
+	for _, ext := range imageExtensions {
+		path := filepath.Join(dir, m.Alias+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no image for menu %v in %v, expected %v.png or %v.jpg", m.Alias, dir, m.Alias, m.Alias)
+}
+
+// CheckImage makes sure LINE will take the image for a menu of the given
+// size before anything is created, since a refused upload is only noticed
+// after the menu exists.
+func CheckImage(path string, size linebot.RichMenuSize) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > maxImageSize {
+		return fmt.Errorf("image %v is %d bytes, at most %d are allowed", path, info.Size(), maxImageSize)
+	}
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return fmt.Errorf("image %v is not a png or jpeg: %v", path, err)
+	}
+	if format != "png" && format != "jpeg" {
+		return fmt.Errorf("image %v is a %v, only png and jpeg are allowed", path, format)
+	}
+	if config.Width != size.Width || config.Height != size.Height {
+		return fmt.Errorf("image %v is %dx%d, the menu is %dx%d", path, config.Width, config.Height, size.Width, size.Height)
+	}
+	return nil
+}
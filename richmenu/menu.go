@@ -0,0 +1,127 @@
+package richmenu
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/HeavenAQ/api/line"
+	"github.com/HeavenAQ/i18n"
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// sizes LINE accepts for rich menu images
+var (
+	Full = linebot.RichMenuSize{Width: 2500, Height: 1686}
+	Half = linebot.RichMenuSize{Width: 2500, Height: 843}
+)
+
+// maxAreas is the number of tappable areas LINE allows in one menu.
+const maxAreas = 20
+
+// alias ids are at most 32 letters, digits, dashes or underscores
+var validAlias = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Item is one tappable area of a menu. Exactly one of its fields is set.
+type Item struct {
+	// Command is the id of a text command in the message catalog; tapping
+	// the area sends its alias so the bot handles it like typed text
+	Command string
+	// Postback is sent as postback data without showing a message
+	Postback line.Postback
+	URI      string
+}
+
+// Menu is a rich menu laid out as a grid: rows share the height evenly and
+// the items of a row share its width evenly.
+type Menu struct {
+	// Alias is the rich menu alias id the menu is deployed under, and its
+	// name in the LINE console
+	Alias string
+	Size  linebot.RichMenuSize
+	// ChatBarText is the message key of the label of the menu in the chat bar
+	ChatBarText string
+	Rows        [][]Item
+}
+
+// Build turns the menu into the object sent to LINE. Command texts are
+// taken from the catalog in lang and checked to be parsed back into the same
+// command, so the menu cannot send a text the bot does not understand.
+func (m Menu) Build(messages *i18n.Catalog, lang string) (linebot.RichMenu, error) {
+	if !validAlias.MatchString(m.Alias) {
+		return linebot.RichMenu{}, fmt.Errorf("invalid rich menu alias %q", m.Alias)
+	}
+	if m.Size != Full && m.Size != Half {
+		return linebot.RichMenu{}, fmt.Errorf("menu %v has an unsupported size %dx%d", m.Alias, m.Size.Width, m.Size.Height)
+	}
+	if len(m.Rows) == 0 {
+		return linebot.RichMenu{}, fmt.Errorf("menu %v has no items", m.Alias)
+	}
+
+	areas := []linebot.AreaDetail{}
+	for row, items := range m.Rows {
+		if len(items) == 0 {
+			return linebot.RichMenu{}, fmt.Errorf("menu %v has an empty row %d", m.Alias, row)
+		}
+		top, bottom := split(m.Size.Height, len(m.Rows), row)
+		for column, item := range items {
+			left, right := split(m.Size.Width, len(items), column)
+			action, err := item.action(messages, lang)
+			if err != nil {
+				return linebot.RichMenu{}, fmt.Errorf("menu %v row %d item %d: %v", m.Alias, row, column, err)
+			}
+			areas = append(areas, linebot.AreaDetail{
+				Bounds: linebot.RichMenuBounds{X: left, Y: top, Width: right - left, Height: bottom - top},
+				Action: action,
+			})
+		}
+	}
+	if len(areas) > maxAreas {
+		return linebot.RichMenu{}, fmt.Errorf("menu %v has %d areas, at most %d are allowed", m.Alias, len(areas), maxAreas)
+	}
+
+	return linebot.RichMenu{
+		Size:        m.Size,
+		Selected:    true,
+		Name:        m.Alias,
+		ChatBarText: messages.T(lang, m.ChatBarText),
+		Areas:       areas,
+	}, nil
+}
+
+// split returns the start and end of the i-th of n equal parts of length.
+// Parts differ by at most a pixel and always cover the whole length.
+func split(length int, n int, i int) (int, int) {
+	start := length * i / n
+	end := length * (i + 1) / n
+	return start, end
+}
+
+func (item Item) action(messages *i18n.Catalog, lang string) (linebot.RichMenuAction, error) {
+	set := 0
+	for _, ok := range []bool{item.Command != "", item.Postback != nil, item.URI != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return linebot.RichMenuAction{}, errors.New("an item needs exactly one of a command, a postback or a uri")
+	}
+
+	switch {
+	case item.Command != "":
+		text := messages.Command(lang, item.Command)
+		if command, arg := messages.ParseCommand(text); command != item.Command || arg != "" {
+			return linebot.RichMenuAction{}, fmt.Errorf("unknown command %q", item.Command)
+		}
+		return linebot.RichMenuAction{Type: linebot.RichMenuActionTypeMessage, Text: text}, nil
+	case item.Postback != nil:
+		data, err := line.EncodePostback(item.Postback)
+		if err != nil {
+			return linebot.RichMenuAction{}, err
+		}
+		return linebot.RichMenuAction{Type: linebot.RichMenuActionTypePostback, Data: data}, nil
+	default:
+		return linebot.RichMenuAction{Type: linebot.RichMenuActionTypeURI, URI: item.URI}, nil
+	}
+}
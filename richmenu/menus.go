@@ -0,0 +1,38 @@
+package richmenu
+
+import "github.com/HeavenAQ/api/db"
+
+// Student is the default menu, holding every menu command of the bot.
+var Student = Menu{
+	Alias:       "student",
+	Size:        Full,
+	ChatBarText: "menu.student",
+	Rows: [][]Item{
+		{{Command: "instruction"}, {Command: "portfolio"}, {Command: "expert_video"}, {Command: "progress_trend"}},
+		{{Command: "preview_note"}, {Command: "analyze_video"}, {Command: "reflection"}, {Command: "syllabus"}},
+	},
+}
+
+// Teacher is linked to verified teachers and adds the class commands.
+// Commands taking an argument reply with how to type them.
+var Teacher = Menu{
+	Alias:       "teacher",
+	Size:        Full,
+	ChatBarText: "menu.teacher",
+	Rows: [][]Item{
+		{{Command: "student_list"}, {Command: "weekly_report"}, {Command: "view_student"}},
+		{{Command: "faults"}, {Command: "progress_trend"}, {Command: "instruction"}},
+	},
+}
+
+// Menus are deployed in this order; the first one is the default menu.
+var Menus = []Menu{Student, Teacher}
+
+// ForRole returns the menu linked to users of a role. Students use the
+// default menu and are not linked to any.
+func ForRole(role db.Role) (Menu, bool) {
+	if role == db.Teacher {
+		return Teacher, true
+	}
+	return Menu{}, false
+}
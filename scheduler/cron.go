@@ -0,0 +1,127 @@
+// Package scheduler runs functions on cron schedules in a fixed time zone.
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron spec. Each field is a bit set of the values it
+// matches.
+type Schedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	anyDom  bool
+	anyDow  bool
+	literal string
+}
+
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = [...]field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse reads a standard five field spec, "minute hour day-of-month month
+// day-of-week", where each field is *, a value, a range a-b, a list or a
+// step such as */15. Sunday is 0 or 7.
+func Parse(spec string) (Schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("cron spec %q must have %d fields", spec, len(fields))
+	}
+
+	var sets [len(fields)]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return Schedule{}, fmt.Errorf("cron spec %q: %v", spec, err)
+		}
+		sets[i] = set
+	}
+
+	// 7 is another name for Sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return Schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		anyDom:  strings.HasPrefix(parts[2], "*"),
+		anyDow:  strings.HasPrefix(parts[4], "*"),
+		literal: spec,
+	}, nil
+}
+
+func parseField(part string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %v", stepPart, f.name)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return 0, fmt.Errorf("invalid %v %q", f.name, lowPart)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return 0, fmt.Errorf("invalid %v %q", f.name, highPart)
+				}
+			} else if hasStep {
+				high = f.max
+			}
+		}
+		if low < f.min || high > f.max || low > high {
+			return 0, fmt.Errorf("%v %q out of range %d-%d", f.name, rangePart, f.min, f.max)
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+// Matches reports whether the schedule fires in the minute of t. Like cron,
+// when both day fields are restricted either of them may match.
+func (s Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<t.Day()) != 0
+	dowMatch := s.dow&(1<<int(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s Schedule) String() string {
+	return s.literal
+}
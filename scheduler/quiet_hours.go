@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// QuietHours is a daily window, such as 22:00-08:00, in which nobody should
+// be disturbed. Start and End are minutes after midnight; the window wraps
+// past midnight when End is before Start. A zero value has no quiet hours.
+type QuietHours struct {
+	Start int
+	End   int
+}
+
+// ParseQuietHours reads "HH:MM-HH:MM". An empty string disables quiet hours.
+func ParseQuietHours(value string) (QuietHours, error) {
+	if value == "" {
+		return QuietHours{}, nil
+	}
+	var startHour, startMinute, endHour, endMinute int
+	_, err := fmt.Sscanf(value, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	if err != nil || startHour > 23 || endHour > 23 || startMinute > 59 || endMinute > 59 ||
+		startHour < 0 || endHour < 0 || startMinute < 0 || endMinute < 0 {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q, expected HH:MM-HH:MM", value)
+	}
+	return QuietHours{startHour*60 + startMinute, endHour*60 + endMinute}, nil
+}
+
+// Contains reports whether t, read in its own location, is quiet.
+func (q QuietHours) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case q.Start == q.End:
+		return false
+	case q.Start < q.End:
+		return minute >= q.Start && minute < q.End
+	default:
+		return minute >= q.Start || minute < q.End
+	}
+}
+
+// After returns t, or the end of the quiet hours t falls in.
+func (q QuietHours) After(t time.Time) time.Time {
+	if !q.Contains(t) {
+		return t
+	}
+	year, month, day := t.Date()
+	end := time.Date(year, month, day, q.End/60, q.End%60, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+func (q QuietHours) String() string {
+	if q.Start == q.End {
+		return "none"
+	}
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", q.Start/60, q.Start%60, q.End/60, q.End%60)
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	name     string
+	schedule Schedule
+	run      func(now time.Time)
+}
+
+// Scheduler checks its entries once a minute and runs the ones that match in
+// their own goroutine.
+type Scheduler struct {
+	mu      sync.Mutex
+	loc     *time.Location
+	entries []entry
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func New(loc *time.Location) *Scheduler {
+	return &Scheduler{loc: loc}
+}
+
+func (s *Scheduler) Location() *time.Location {
+	return s.loc
+}
+
+// Add registers run under spec, see Parse. run gets the scheduled minute in
+// the scheduler's location.
+func (s *Scheduler) Add(name string, spec string, run func(now time.Time)) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry{name, schedule, run})
+	return nil
+}
+
+// Start begins checking the schedules. It does nothing when already started.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.loop(s.stop)
+}
+
+// Stop ends the loop and waits for running entries to return.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(stop chan struct{}) {
+	defer s.wg.Done()
+	for {
+		// wake at the start of the next minute
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		minute := next.In(s.loc)
+		s.mu.Lock()
+		entries := append([]entry{}, s.entries...)
+		s.mu.Unlock()
+		for _, e := range entries {
+			if !e.schedule.Matches(minute) {
+				continue
+			}
+			s.wg.Add(1)
+			go func(e entry) {
+				defer s.wg.Done()
+				e.run(minute)
+			}(e)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package skill
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// defaultSkills is used when no config file is given and holds the strokes
+// the bot originally shipped with.
+//
+//go:embed skills.json
+var defaultSkills []byte
+
+// maxSkills is the number of quick reply buttons LINE allows in one message.
+const maxSkills = 13
+
+// ids end up in postback data, folder names and database keys
+var validId = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+type Skill struct {
+	Id     string            `json:"id"`
+	Labels map[string]string `json:"labels"`
+	// ExpertVideos maps a handedness ("left" or "right") to demo video urls
+	ExpertVideos map[string][]string `json:"expertVideos"`
+	// ModelId selects the model the AI server uses to analyze this skill
+	ModelId string `json:"modelId"`
+}
+
+func (s Skill) String() string {
+	return s.Id
+}
+
+func (s Skill) ChnString() string {
+	return s.Labels["zh"]
+}
+
+// Label returns the label in the given language, falling back to Chinese.
+func (s Skill) Label(lang string) string {
+	if label, ok := s.Labels[lang]; ok && label != "" {
+		return label
+	}
+	return s.ChnString()
+}
+
+type Registry struct {
+	skills []Skill
+	byId   map[string]Skill
+}
+
+func NewRegistry(skills []Skill) (*Registry, error) {
+	if len(skills) == 0 {
+		return nil, errors.New("no skills configured")
+	}
+	if len(skills) > maxSkills {
+		return nil, fmt.Errorf("at most %d skills can be configured", maxSkills)
+	}
+
+	registry := &Registry{skills, map[string]Skill{}}
+	for _, skill := range skills {
+		if !validId.MatchString(skill.Id) {
+			return nil, fmt.Errorf("invalid skill id %q", skill.Id)
+		}
+		if _, ok := registry.byId[skill.Id]; ok {
+			return nil, fmt.Errorf("duplicated skill id %q", skill.Id)
+		}
+		if skill.ChnString() == "" {
+			return nil, fmt.Errorf("skill %q has no zh label", skill.Id)
+		}
+		registry.byId[skill.Id] = skill
+	}
+	return registry, nil
+}
+
+// Load reads the registry from a JSON file, or the built-in skills when path
+// is empty.
+func Load(path string) (*Registry, error) {
+	data := defaultSkills
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var skills []Skill
+	if err := json.Unmarshal(data, &skills); err != nil {
+		return nil, fmt.Errorf("failed to parse skills config: %v", err)
+	}
+	return NewRegistry(skills)
+}
+
+func (r *Registry) Get(id string) (Skill, bool) {
+	skill, ok := r.byId[id]
+	return skill, ok
+}
+
+// All returns the skills in the order they were configured.
+func (r *Registry) All() []Skill {
+	return r.skills
+}
+
+func (r *Registry) Ids() []string {
+	ids := make([]string, len(r.skills))
+	for i, skill := range r.skills {
+		ids[i] = skill.Id
+	}
+	return ids
+}
@@ -0,0 +1,126 @@
+// Package workspace gives every video analysis its own temp directory so
+// concurrent jobs never share a file and nothing is left behind when a job
+// fails halfway.
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dirPrefix marks the directories created by a Root so Sweep never touches
+// anything else in it
+const dirPrefix = "job-"
+
+// Root is the directory holding the workspaces. It must not be shared with
+// another running process, since Sweep treats every workspace as orphaned.
+type Root struct {
+	dir string
+}
+
+func NewRoot(dir string) (*Root, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "analysis-jobs")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Root{dir}, nil
+}
+
+func (root *Root) Dir() string {
+	return root.dir
+}
+
+// New creates a uniquely named workspace; name only makes it easier to find,
+// e.g. the job id.
+func (root *Root) New(name string) (*Workspace, error) {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == filepath.Separator {
+			return '_'
+		}
+		return r
+	}, name)
+	dir, err := os.MkdirTemp(root.dir, dirPrefix+name+"-")
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{dir: dir}, nil
+}
+
+// Sweep removes the workspaces left by a previous run, e.g. after a crash.
+// It must be called before any job starts.
+func (root *Root) Sweep() ([]string, error) {
+	entries, err := os.ReadDir(root.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), dirPrefix) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root.dir, entry.Name())); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Workspace is the directory of one job. Artifacts are tracked as their paths
+// are handed out and everything is deleted by Remove.
+type Workspace struct {
+	mu        sync.Mutex
+	dir       string
+	artifacts []string
+	removed   bool
+}
+
+func (ws *Workspace) Dir() string {
+	return ws.dir
+}
+
+// Path returns the path of an artifact in the workspace. The file is not
+// created.
+func (ws *Workspace) Path(name string) string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	path := filepath.Join(ws.dir, filepath.Base(name))
+	for _, artifact := range ws.artifacts {
+		if artifact == path {
+			return path
+		}
+	}
+	ws.artifacts = append(ws.artifacts, path)
+	return path
+}
+
+// Artifacts lists the paths handed out so far.
+func (ws *Workspace) Artifacts() []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return append([]string{}, ws.artifacts...)
+}
+
+// Remove deletes the workspace with every artifact in it, including files
+// written by tools such as ffmpeg that were never handed out by Path. It is
+// safe to call more than once.
+func (ws *Workspace) Remove() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.removed {
+		return nil
+	}
+	if err := os.RemoveAll(ws.dir); err != nil {
+		return err
+	}
+	ws.removed = true
+	return nil
+}